@@ -0,0 +1,163 @@
+package apperr
+
+import (
+	"errors"
+	"net/http"
+	"post-service/database"
+	"post-service/logging"
+	"post-service/validation"
+	"strings"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const MIMEProblemJSON = "application/problem+json"
+
+// StatusClientClosedRequest is nginx's status for a request the client
+// abandoned before the response was ready. Nobody reads the response, but the
+// access log shows why the request ended.
+const StatusClientClosedRequest = 499
+
+// Problem is an RFC 7807 problem details document extended with a stable
+// error code and, for validation failures, the rejected fields.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+var kindStatus = map[Kind]int{
+	KindInternal:        http.StatusInternalServerError,
+	KindBadRequest:      http.StatusBadRequest,
+	KindValidation:      http.StatusBadRequest,
+	KindUnauthorized:    http.StatusUnauthorized,
+	KindForbidden:       http.StatusForbidden,
+	KindNotFound:        http.StatusNotFound,
+	KindConflict:        http.StatusConflict,
+	KindTimeout:         http.StatusGatewayTimeout,
+	KindUnavailable:     http.StatusServiceUnavailable,
+	KindTooLarge:        http.StatusRequestEntityTooLarge,
+	KindTooManyRequests: http.StatusTooManyRequests,
+	KindUnprocessable:   http.StatusUnprocessableEntity,
+}
+
+// Status returns the HTTP status code used for errors of kind k.
+func (k Kind) Status() int {
+	if status, ok := kindStatus[k]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// NewHTTPErrorHandler returns echo's error handler, which renders every error
+// returned by a handler or middleware as application/problem+json. Validation
+// messages are translated into the language requested by Accept-Language.
+func NewHTTPErrorHandler(uni *ut.UniversalTranslator) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		handleError(err, c, uni)
+	}
+}
+
+func handleError(err error, c echo.Context, uni *ut.UniversalTranslator) {
+	if c.Response().Committed {
+		return
+	}
+
+	problem := toProblem(err)
+	problem.Instance = c.Request().URL.Path
+
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		trans := validation.Translator(uni, c.Request().Header.Get("Accept-Language"))
+		problem.Errors = translateFields(validationErrors, trans)
+		c.Response().Header().Set("Content-Language", strings.ReplaceAll(trans.Locale(), "_", "-"))
+	}
+
+	if problem.Status >= http.StatusInternalServerError {
+		logging.FromContext(c.Request().Context()).Error("request failed", zap.String("code", problem.Code), zap.Error(err))
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(problem.Status)
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, MIMEProblemJSON)
+		err = c.JSON(problem.Status, problem)
+	}
+	if err != nil {
+		logging.FromContext(c.Request().Context()).Error("failed to write error response", zap.Error(err))
+	}
+}
+
+func toProblem(err error) Problem {
+	if appErr, ok := As(err); ok {
+		return newProblem(appErr.Kind.Status(), appErr.Code, appErr.Message, appErr.Fields)
+	}
+
+	if database.IsCanceled(err) {
+		return newProblem(StatusClientClosedRequest, "client_closed_request", "the client closed the request", nil)
+	}
+	if database.IsTimeout(err) {
+		return newProblem(http.StatusGatewayTimeout, "query_timeout", "request timed out", nil)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return newProblem(http.StatusNotFound, "not_found", "resource not found", nil)
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		detail := http.StatusText(httpErr.Code)
+		if message, ok := httpErr.Message.(string); ok {
+			detail = message
+		}
+		return newProblem(httpErr.Code, statusCode(httpErr.Code), detail, nil)
+	}
+
+	return newProblem(http.StatusInternalServerError, "internal_error", "internal server error", nil)
+}
+
+func translateFields(validationErrors validator.ValidationErrors, trans ut.Translator) []FieldError {
+	fields := make([]FieldError, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		fields = append(fields, FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Message: fe.Translate(trans),
+		})
+	}
+	return fields
+}
+
+func newProblem(status int, code, detail string, fields []FieldError) Problem {
+	return Problem{
+		Type:   "urn:post-service:problem:" + code,
+		Title:  statusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+		Errors: fields,
+	}
+}
+
+func statusText(status int) string {
+	if status == StatusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(status)
+}
+
+// statusCode derives a code such as "method_not_allowed" from an HTTP status.
+func statusCode(status int) string {
+	text := statusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
@@ -0,0 +1,32 @@
+package apperr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestToProblem(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"domain error", NotFound("post_not_found", "post not found"), http.StatusNotFound, "post_not_found"},
+		{"deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "query_timeout"},
+		{"client went away", fmt.Errorf("query: %w", context.Canceled), StatusClientClosedRequest, "client_closed_request"},
+		{"not found", gorm.ErrRecordNotFound, http.StatusNotFound, "not_found"},
+		{"unknown", errors.New("boom"), http.StatusInternalServerError, "internal_error"},
+	}
+	for _, test := range tests {
+		problem := toProblem(test.err)
+		if problem.Status != test.status || problem.Code != test.code || problem.Title == "" {
+			t.Errorf("%s: got %+v, want %d %q", test.name, problem, test.status, test.code)
+		}
+	}
+}
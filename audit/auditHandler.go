@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type AuditHandler struct {
+	service *AuditService
+}
+
+func NewAuditHandler(service *AuditService) *AuditHandler {
+	return &AuditHandler{service: service}
+}
+
+func (handler *AuditHandler) ListEntries(c echo.Context) error {
+	entries, err := handler.service.ListEntries(c.Request().Context(), EntryQuery{
+		ActorID:    c.QueryParam("actorId"),
+		TargetType: c.QueryParam("targetType"),
+		TargetID:   c.QueryParam("targetId"),
+		From:       c.QueryParam("from"),
+		To:         c.QueryParam("to"),
+		Page:       c.QueryParam("page"),
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+func (handler *AuditHandler) VerifyChain(c echo.Context) error {
+	result, err := handler.service.Verify(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
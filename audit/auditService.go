@@ -0,0 +1,227 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"post-service/apperr"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("post-service/audit")
+
+const (
+	TargetPost     = "post"
+	TargetCategory = "category"
+
+	entryPageSize = 50
+	// verifyBatchSize is how many entries Verify reads at a time.
+	verifyBatchSize = 1000
+)
+
+var ErrInvalidActorId = apperr.Validation("invalid_actor_id", "actorId must be a positive integer",
+	apperr.FieldError{Field: "actorId", Rule: "number", Message: "actorId must be a positive integer"})
+var ErrInvalidTargetId = apperr.Validation("invalid_target_id", "targetId must be a positive integer",
+	apperr.FieldError{Field: "targetId", Rule: "number", Message: "targetId must be a positive integer"})
+var ErrInvalidTargetType = apperr.Validation("invalid_target_type", "targetType must be post or category",
+	apperr.FieldError{Field: "targetType", Rule: "oneof", Message: "targetType must be post or category"})
+var ErrInvalidTimeRange = apperr.Validation("invalid_time_range", "from and to must be RFC 3339 times with from before to",
+	apperr.FieldError{Field: "from", Rule: "datetime", Message: "from and to must be RFC 3339 times with from before to"})
+
+type EntryResponse struct {
+	ID         uint64          `json:"id"`
+	ActorID    uint            `json:"actorId"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"targetType"`
+	TargetID   uint            `json:"targetId"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	RequestID  string          `json:"requestId,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	Hash       string          `json:"hash"`
+}
+
+// EntryQuery holds the query parameters of an entry search, unparsed.
+type EntryQuery struct {
+	ActorID    string
+	TargetType string
+	TargetID   string
+	From       string
+	To         string
+	Page       string
+}
+
+// VerifyResult reports whether the hash chain is intact. BrokenAt is the
+// first entry that was changed, or that follows a removed one.
+type VerifyResult struct {
+	Valid    bool    `json:"valid"`
+	Entries  int     `json:"entries"`
+	BrokenAt *uint64 `json:"brokenAt,omitempty"`
+}
+
+type AuditService struct {
+	repo *EntryRepository
+}
+
+func NewAuditService(repo *EntryRepository) *AuditService {
+	return &AuditService{repo: repo}
+}
+
+// Record appends an entry for a change by actorId to a target, with JSON
+// snapshots of it before and after; nil for a side where it did not exist.
+// It is called from the change's database.Hook, so that a change is never
+// kept without its entry.
+func (service *AuditService) Record(ctx context.Context, actorId uint, action, targetType string, targetId uint, before, after interface{}) error {
+	ctx, span := tracer.Start(ctx, "AuditService.Record")
+	defer span.End()
+
+	request := RequestFrom(ctx)
+	entry := &Entry{
+		ActorID:    actorId,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetId,
+		Before:     snapshot(before),
+		After:      snapshot(after),
+		RequestID:  request.ID,
+		IP:         request.IP,
+		CreatedAt:  time.Now(),
+	}
+	if err := service.repo.Append(ctx, entry); err != nil {
+		return fmt.Errorf("recording audit entry %s on %s %d: %w", action, targetType, targetId, err)
+	}
+	return nil
+}
+
+func snapshot(value interface{}) *string {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil || string(data) == "null" {
+		return nil
+	}
+	s := string(data)
+	return &s
+}
+
+// ListEntries pages through the entries matching query, newest first.
+func (service *AuditService) ListEntries(ctx context.Context, query EntryQuery) ([]EntryResponse, error) {
+	ctx, span := tracer.Start(ctx, "AuditService.ListEntries")
+	defer span.End()
+
+	filter, err := query.filter()
+	if err != nil {
+		return nil, err
+	}
+	page, err := strconv.Atoi(query.Page)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	entries, err := service.repo.ListEntries(ctx, *filter, (page-1)*entryPageSize, entryPageSize)
+	if err != nil {
+		return nil, err
+	}
+	responses := []EntryResponse{}
+	for _, entry := range entries {
+		responses = append(responses, EntryResponse{
+			ID:         entry.ID,
+			ActorID:    entry.ActorID,
+			Action:     entry.Action,
+			TargetType: entry.TargetType,
+			TargetID:   entry.TargetID,
+			Before:     rawSnapshot(entry.Before),
+			After:      rawSnapshot(entry.After),
+			RequestID:  entry.RequestID,
+			IP:         entry.IP,
+			CreatedAt:  entry.CreatedAt,
+			Hash:       entry.Hash,
+		})
+	}
+	return responses, nil
+}
+
+func rawSnapshot(value *string) json.RawMessage {
+	if value == nil {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(*value)
+}
+
+func (query EntryQuery) filter() (*EntryFilter, error) {
+	filter := &EntryFilter{}
+	if query.ActorID != "" {
+		id, err := strconv.ParseUint(query.ActorID, 10, 32)
+		if err != nil || id == 0 {
+			return nil, ErrInvalidActorId
+		}
+		actorId := uint(id)
+		filter.ActorID = &actorId
+	}
+	switch query.TargetType {
+	case "", TargetPost, TargetCategory:
+		filter.TargetType = query.TargetType
+	default:
+		return nil, ErrInvalidTargetType
+	}
+	if query.TargetID != "" {
+		id, err := strconv.ParseUint(query.TargetID, 10, 32)
+		if err != nil || id == 0 {
+			return nil, ErrInvalidTargetId
+		}
+		targetId := uint(id)
+		filter.TargetID = &targetId
+	}
+	for _, bound := range []struct {
+		value string
+		into  **time.Time
+	}{{query.From, &filter.From}, {query.To, &filter.To}} {
+		if bound.value == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			return nil, ErrInvalidTimeRange
+		}
+		*bound.into = &at
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return nil, ErrInvalidTimeRange
+	}
+	return filter, nil
+}
+
+// Verify walks the whole chain, recomputing every hash.
+func (service *AuditService) Verify(ctx context.Context) (*VerifyResult, error) {
+	ctx, span := tracer.Start(ctx, "AuditService.Verify")
+	defer span.End()
+
+	result := &VerifyResult{Valid: true}
+	prevHash := genesisHash
+	var lastId uint64
+	for {
+		entries, err := service.repo.EntriesAfter(ctx, lastId, verifyBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.PrevHash != prevHash || entry.computeHash() != entry.Hash {
+				brokenAt := entry.ID
+				result.Valid = false
+				result.BrokenAt = &brokenAt
+				return result, nil
+			}
+			prevHash = entry.Hash
+			lastId = entry.ID
+			result.Entries++
+		}
+		if len(entries) < verifyBatchSize {
+			return result, nil
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"post-service/database"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestService(t *testing.T) (*AuditService, *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&Entry{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewAuditService(NewEntryRepository(db, &database.QueryTimeouts{Default: time.Second})), db
+}
+
+// recordEntries records a create, an update and a delete of post 1.
+func recordEntries(t *testing.T, service *AuditService) {
+	t.Helper()
+	ctx := context.Background()
+	changes := []struct {
+		action        string
+		before, after interface{}
+	}{
+		{"post.create", nil, map[string]string{"title": "Drill"}},
+		{"post.update", map[string]string{"title": "Drill"}, map[string]string{"title": "Hammer drill"}},
+		{"post.delete", map[string]string{"title": "Hammer drill"}, nil},
+	}
+	for _, change := range changes {
+		if err := service.Record(ctx, 1, change.action, TargetPost, 1, change.before, change.after); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAppendChainsEntries(t *testing.T) {
+	service, db := newTestService(t)
+	recordEntries(t, service)
+
+	var entries []Entry
+	if err := db.Order("id").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	prevHash := genesisHash
+	for _, entry := range entries {
+		if entry.PrevHash != prevHash {
+			t.Errorf("entry %d: got previous hash %s, want %s", entry.ID, entry.PrevHash, prevHash)
+		}
+		if entry.Hash != entry.computeHash() {
+			t.Errorf("entry %d: hash does not match its fields", entry.ID)
+		}
+		prevHash = entry.Hash
+	}
+	if entries[0].Before != nil || entries[2].After != nil {
+		t.Errorf("missing sides were stored: %+v, %+v", entries[0], entries[2])
+	}
+}
+
+func TestAppendInTransaction(t *testing.T) {
+	service, db := newTestService(t)
+	failure := errors.New("change failed")
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		ctx := database.WithTx(context.Background(), tx)
+		if err := service.Record(ctx, 1, "post.create", TargetPost, 1, nil, map[string]string{"title": "Drill"}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("got error %v, want %v", err, failure)
+	}
+	var count int64
+	if err := db.Model(&Entry{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d entries after rollback, want 0", count)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	brokenAt := func(result *VerifyResult) uint64 {
+		if result.BrokenAt == nil {
+			return 0
+		}
+		return *result.BrokenAt
+	}
+
+	t.Run("intact", func(t *testing.T) {
+		service, _ := newTestService(t)
+		recordEntries(t, service)
+
+		result, err := service.Verify(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Valid || result.Entries != 3 || result.BrokenAt != nil {
+			t.Errorf("got %+v", result)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		service, _ := newTestService(t)
+		result, err := service.Verify(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Valid || result.Entries != 0 {
+			t.Errorf("got %+v", result)
+		}
+	})
+
+	t.Run("edited", func(t *testing.T) {
+		service, db := newTestService(t)
+		recordEntries(t, service)
+		if err := db.Exec("UPDATE audit_entries SET actor_id = 2 WHERE id = 2").Error; err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := service.Verify(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Valid || result.Entries != 1 || brokenAt(result) != 2 {
+			t.Errorf("got %+v, broken at %d", result, brokenAt(result))
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		service, db := newTestService(t)
+		recordEntries(t, service)
+		if err := db.Exec("DELETE FROM audit_entries WHERE id = 2").Error; err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := service.Verify(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Valid || result.Entries != 1 || brokenAt(result) != 3 {
+			t.Errorf("got %+v, broken at %d", result, brokenAt(result))
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"context"
+	"post-service/logging"
+
+	"github.com/labstack/echo/v4"
+)
+
+type requestKey struct{}
+
+// Request identifies the HTTP request a change was made in.
+type Request struct {
+	ID string
+	IP string
+}
+
+// WithRequest returns a context carrying request.
+func WithRequest(ctx context.Context, request Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, request)
+}
+
+// RequestFrom returns the request stored in ctx, or a zero Request for
+// changes made outside one, such as by background jobs.
+func RequestFrom(ctx context.Context) Request {
+	request, _ := ctx.Value(requestKey{}).(Request)
+	return request
+}
+
+// Middleware stores the request ID and client IP in the request context for
+// the entries recorded while handling it. It must run after the access log
+// middleware, which assigns the ID.
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		ctx = WithRequest(ctx, Request{ID: logging.RequestID(ctx), IP: c.RealIP()})
+		c.SetRequest(c.Request().WithContext(ctx))
+		return next(c)
+	}
+}
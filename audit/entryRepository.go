@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"post-service/database"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// genesisHash is the previous hash of the first entry.
+var genesisHash = strings.Repeat("0", 64)
+
+// Entry records one change. Each entry's Hash covers its fields and the hash
+// of the entry before it, so editing or removing an entry breaks the chain
+// from there on.
+type Entry struct {
+	ID         uint64
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   uint
+	// Before and After are JSON snapshots of the target; nil when it did not
+	// exist on that side of the change.
+	Before    *string `gorm:"column:before_data"`
+	After     *string `gorm:"column:after_data"`
+	RequestID string
+	IP        string `gorm:"column:ip"`
+	CreatedAt time.Time
+	PrevHash  string
+	Hash      string
+}
+
+func (Entry) TableName() string {
+	return "audit_entries"
+}
+
+// computeHash hashes the entry's fields together with PrevHash.
+func (entry *Entry) computeHash() string {
+	data, _ := json.Marshal(struct {
+		PrevHash   string  `json:"prevHash"`
+		ActorID    uint    `json:"actorId"`
+		Action     string  `json:"action"`
+		TargetType string  `json:"targetType"`
+		TargetID   uint    `json:"targetId"`
+		Before     *string `json:"before"`
+		After      *string `json:"after"`
+		RequestID  string  `json:"requestId"`
+		IP         string  `json:"ip"`
+		CreatedAt  string  `json:"createdAt"`
+	}{
+		PrevHash:   entry.PrevHash,
+		ActorID:    entry.ActorID,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Before:     entry.Before,
+		After:      entry.After,
+		RequestID:  entry.RequestID,
+		IP:         entry.IP,
+		CreatedAt:  entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// EntryFilter selects entries. Zero fields match everything.
+type EntryFilter struct {
+	ActorID    *uint
+	TargetType string
+	TargetID   *uint
+	From       *time.Time
+	To         *time.Time
+}
+
+type EntryRepository struct {
+	db       *gorm.DB
+	timeouts *database.QueryTimeouts
+}
+
+func NewEntryRepository(db *gorm.DB, timeouts *database.QueryTimeouts) *EntryRepository {
+	return &EntryRepository{db: db, timeouts: timeouts}
+}
+
+// Append chains entry to the latest one and stores it. Appends are
+// serialized so that every entry links to the one stored just before it.
+// Inside the transaction carried by ctx, the entry commits or rolls back
+// with it.
+func (repo *EntryRepository) Append(ctx context.Context, entry *Entry) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "EntryRepository.Append")
+	defer cancel()
+
+	// Postgres keeps microseconds; hash what will be read back.
+	entry.CreatedAt = entry.CreatedAt.Truncate(time.Microsecond)
+	return database.Tx(ctx, repo.db).WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// SQLite, used in tests, already runs one write transaction at a time.
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("LOCK TABLE audit_entries IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+				return err
+			}
+		}
+
+		var last Entry
+		err := tx.Select("hash").Order("id DESC").Take(&last).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			entry.PrevHash = genesisHash
+		case err != nil:
+			return err
+		default:
+			entry.PrevHash = last.Hash
+		}
+		entry.Hash = entry.computeHash()
+		return tx.Create(entry).Error
+	})
+}
+
+// ListEntries returns entries matching filter, newest first.
+func (repo *EntryRepository) ListEntries(ctx context.Context, filter EntryFilter, offset, limit int) ([]Entry, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "EntryRepository.ListEntries")
+	defer cancel()
+
+	query := repo.db.WithContext(ctx).Model(&Entry{})
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != nil {
+		query = query.Where("target_id = ?", *filter.TargetID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	var entries []Entry
+	err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&entries).Error
+	return entries, err
+}
+
+// EntriesAfter returns up to limit entries following afterId, oldest first.
+func (repo *EntryRepository) EntriesAfter(ctx context.Context, afterId uint64, limit int) ([]Entry, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "EntryRepository.EntriesAfter")
+	defer cancel()
+
+	var entries []Entry
+	err := repo.db.WithContext(ctx).Where("id > ?", afterId).Order("id").Limit(limit).Find(&entries).Error
+	return entries, err
+}
@@ -2,44 +2,68 @@ package auth
 
 import (
 	"errors"
-	"net/http"
+	"post-service/apperr"
+	"post-service/logging"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/labstack/echo/v4"
 )
 
+var ErrNotLoggedIn = apperr.Unauthorized("not_logged_in", "you are not logged in")
+var ErrInvalidHeader = apperr.Unauthorized("invalid_authorization_header", "invalid authorization header format")
+var ErrInvalidToken = apperr.Unauthorized("invalid_token", "invalid or expired token")
+var ErrInvalidClaim = apperr.Unauthorized("invalid_token_claim", "invalid token claim")
+var ErrAdminOnly = apperr.Forbidden("admin_only", "this endpoint is only available to admins")
+
+// RoleAdmin is the value of the token's Role claim for admins.
+const RoleAdmin = "admin"
+
 func AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		authHeader := c.Request().Header.Get("Authorization")
 		if authHeader == "" {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "You are not logged in."})
+			return ErrNotLoggedIn
 		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid authorization header format."})
+			return ErrInvalidHeader
 		}
 
 		token := parts[1]
 
 		claims, err := validateToken(token)
 		if err != nil {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid or expired token."})
+			return ErrInvalidToken
 		}
 
 		userId, ok := claims["UserId"].(float64)
 		if !ok {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid token claim."})
+			return ErrInvalidClaim
 		}
 
 		c.Set("userId", uint(userId))
+		logging.SetUserID(c.Request().Context(), uint(userId))
+		if role, ok := claims["Role"].(string); ok {
+			c.Set("role", role)
+		}
 
 		return next(c)
 
 	}
 }
 
+// AdminMiddleware must run after AuthMiddleware.
+func AdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if role, _ := c.Get("role").(string); role != RoleAdmin {
+			return ErrAdminOnly
+		}
+		return next(c)
+	}
+}
+
 func validateToken(tokenStr string) (jwt.MapClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenStr, &jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte("my_secret_key"), nil
@@ -0,0 +1,115 @@
+package category
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	categoryCacheTTL = 5 * time.Minute
+	// missingSlugTTL is how long a slug no category resolves by is
+	// remembered. Creating a category forgets it sooner.
+	missingSlugTTL = 30 * time.Second
+	// maxMissingSlugs bounds the slugs remembered as missing, which clients
+	// choose.
+	maxMissingSlugs = 1000
+)
+
+type cacheEntry struct {
+	category  Category
+	redirect  bool
+	expiresAt time.Time
+}
+
+// CategoryCache is a read-through cache of categories keyed by id and by every
+// slug they can be resolved by, including that of their name. It also
+// remembers slugs that resolve to no category for a short while. Categories
+// change rarely but are read on almost every post request.
+type CategoryCache struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	byId    map[uint]cacheEntry
+	bySlug  map[string]cacheEntry
+	missing map[string]time.Time
+}
+
+func NewCategoryCache() *CategoryCache {
+	return &CategoryCache{
+		ttl:     categoryCacheTTL,
+		byId:    map[uint]cacheEntry{},
+		bySlug:  map[string]cacheEntry{},
+		missing: map[string]time.Time{},
+	}
+}
+
+// GetById returns a copy of the cached category, so callers may modify it freely.
+func (cache *CategoryCache) GetById(categoryId uint) (*Category, bool) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	category, _, ok := cache.lookup(cache.byId[categoryId])
+	return category, ok
+}
+
+// GetBySlug looks a category up by its slug, an alias slug or the slug of its
+// name. redirect reports whether the slug is one the category no longer uses.
+func (cache *CategoryCache) GetBySlug(slug string) (category *Category, redirect bool, ok bool) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	return cache.lookup(cache.bySlug[slug])
+}
+
+// IsMissing reports whether slug recently resolved to no category.
+func (cache *CategoryCache) IsMissing(slug string) bool {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	expiresAt, ok := cache.missing[slug]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// PutMissing remembers that slug resolves to no category.
+func (cache *CategoryCache) PutMissing(slug string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if len(cache.missing) >= maxMissingSlugs {
+		cache.missing = map[string]time.Time{}
+	}
+	cache.missing[slug] = time.Now().Add(missingSlugTTL)
+}
+
+func (cache *CategoryCache) lookup(entry cacheEntry) (*Category, bool, bool) {
+	if entry.expiresAt.IsZero() || time.Now().After(entry.expiresAt) {
+		return nil, false, false
+	}
+	category := entry.category
+	category.Aliases = append([]CategoryAlias(nil), entry.category.Aliases...)
+	category.Translations = append([]CategoryTranslation(nil), entry.category.Translations...)
+	return &category, entry.redirect, true
+}
+
+func (cache *CategoryCache) Put(category Category) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	expiresAt := time.Now().Add(cache.ttl)
+	cache.byId[category.ID] = cacheEntry{category: category, expiresAt: expiresAt}
+	cache.bySlug[category.Slug] = cacheEntry{category: category, expiresAt: expiresAt}
+	for _, alias := range category.Aliases {
+		cache.bySlug[alias.Slug] = cacheEntry{category: category, redirect: alias.IsRedirect, expiresAt: expiresAt}
+	}
+	// A slug or alias of another category wins over the name.
+	if nameSlug := Slugify(category.Name); nameSlug != "" {
+		if _, ok := cache.bySlug[nameSlug]; !ok {
+			cache.bySlug[nameSlug] = cacheEntry{category: category, expiresAt: expiresAt}
+		}
+	}
+}
+
+// Invalidate drops every cached category. It is called after any category
+// write, since renames and alias changes affect the slug index as well as
+// the entries themselves.
+func (cache *CategoryCache) Invalidate() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.byId = map[uint]cacheEntry{}
+	cache.bySlug = map[string]cacheEntry{}
+	cache.missing = map[string]time.Time{}
+}
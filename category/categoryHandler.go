@@ -2,6 +2,8 @@ package category
 
 import (
 	"net/http"
+	"post-service/apperr"
+	"post-service/validation"
 	"strconv"
 
 	"github.com/go-playground/validator/v10"
@@ -15,27 +17,54 @@ type CategoryHandler struct {
 	validator  *validator.Validate
 }
 
+type CategoryTranslationDto struct {
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=1000,nohtml"`
+}
+
+type CategoryDto struct {
+	Name         string                            `json:"name" validate:"required,max=100"`
+	Slug         string                            `json:"slug" validate:"omitempty,max=120"`
+	Description  string                            `json:"description" validate:"max=1000,nohtml"`
+	Icon         string                            `json:"icon" validate:"omitempty,max=255"`
+	DisplayOrder int                               `json:"displayOrder"`
+	IsActive     *bool                             `json:"isActive"`
+	Aliases      []string                          `json:"aliases" validate:"dive,required,max=100"`
+	Translations map[string]CategoryTranslationDto `json:"translations" validate:"dive,keys,required,max=10,endkeys"`
+}
+
+type UpdateCategoryDto struct {
+	Name         string                            `json:"name" validate:"required,max=100"`
+	Slug         string                            `json:"slug" validate:"omitempty,max=120"`
+	Description  string                            `json:"description" validate:"max=1000,nohtml"`
+	Icon         string                            `json:"icon" validate:"omitempty,max=255"`
+	DisplayOrder int                               `json:"displayOrder"`
+	IsActive     *bool                             `json:"isActive"`
+	Translations map[string]CategoryTranslationDto `json:"translations" validate:"dive,keys,required,max=10,endkeys"`
+}
+
 func NewCategoryHandler(catService *CategoryService, logger *zap.Logger, validator *validator.Validate) *CategoryHandler {
 	return &CategoryHandler{catService: catService, logger: logger, validator: validator}
 }
 
 func (catHandler *CategoryHandler) CreateCategory(c echo.Context) error {
-	var category Category
+	var category CategoryDto
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
 
 	if err := c.Bind(&category); err != nil {
-		catHandler.logger.Error("error binding request", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, "failed to bind request")
+		return apperr.BadRequest("invalid_request", "failed to parse request body")
 	}
 
 	if err := catHandler.validator.Struct(category); err != nil {
-		catHandler.logger.Error("provided data is invalid", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid data")
+		return apperr.FromValidator(err)
 	}
 
-	categoryId, err := catHandler.catService.CreateCategory(category)
+	categoryId, err := catHandler.catService.CreateCategory(c.Request().Context(), userId, category)
 	if err != nil {
-		catHandler.logger.Error("error create category", zap.Error(err))
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create category")
+		return err
 	}
 
 	return c.JSON(http.StatusCreated, map[string]interface{}{
@@ -44,10 +73,12 @@ func (catHandler *CategoryHandler) CreateCategory(c echo.Context) error {
 }
 
 func (catHandler *CategoryHandler) GetAllCategories(c echo.Context) error {
-	categories, err := catHandler.catService.GetAllCategories()
+	languages := validation.PreferredLanguages(c.Request().Header.Get("Accept-Language"))
+	includeInactive := c.QueryParam("includeInactive") == "true"
+
+	categories, err := catHandler.catService.GetAllCategories(c.Request().Context(), languages, includeInactive)
 	if err != nil {
-		catHandler.logger.Error("error retrieving categories")
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to retrieve categories")
+		return err
 	}
 
 	return c.JSON(http.StatusOK, categories)
@@ -55,57 +86,160 @@ func (catHandler *CategoryHandler) GetAllCategories(c echo.Context) error {
 
 func (catHandler *CategoryHandler) GetCategoryById(c echo.Context) error {
 	categoryIdStr := c.Param("categoryId")
-	if categoryIdStr == "" {
-		catHandler.logger.Error("error missing category id")
-		return echo.NewHTTPError(http.StatusBadRequest, "missing category id")
-	}
 	categoryId, err := strconv.ParseUint(categoryIdStr, 10, 32)
 	if err != nil {
-		catHandler.logger.Error("invalid categoryId", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid categoryID")
+		return ErrInvalidCategoryId
 	}
 
-	categoryname, err := catHandler.catService.GetCategoryById(uint(categoryId))
+	languages := validation.PreferredLanguages(c.Request().Header.Get("Accept-Language"))
+	category, err := catHandler.catService.GetCategoryById(c.Request().Context(), uint(categoryId), languages)
 	if err != nil {
-		catHandler.logger.Error("error retrieving category by id", zap.Error(err))
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get category name")
+		return err
 	}
 
-	return c.JSON(http.StatusOK, categoryname)
+	return c.JSON(http.StatusOK, category)
 }
 
 func (catHandler *CategoryHandler) UpdateCategory(c echo.Context) error {
-	categoryIdStr := c.Param("categoryId")
-	if categoryIdStr == "" {
-		catHandler.logger.Error("error missing category id")
-		return echo.NewHTTPError(http.StatusBadRequest, "missing category id")
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
 	}
+
+	categoryIdStr := c.Param("categoryId")
 	categoryId, err := strconv.ParseUint(categoryIdStr, 10, 32)
 	if err != nil {
-		catHandler.logger.Error("invalid categoryId", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid categoryID")
+		return ErrInvalidCategoryId
 	}
 
-	var category struct {
-		Name string `gorm:"unique" json:"name" validate:"required"`
-	}
+	var category UpdateCategoryDto
 	if err := c.Bind(&category); err != nil {
-		catHandler.logger.Error("error binding request")
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+		return apperr.BadRequest("invalid_request", "failed to parse request body")
 	}
 
 	if err := catHandler.validator.Struct(category); err != nil {
-		catHandler.logger.Error("invalid input data", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request data")
+		return apperr.FromValidator(err)
 	}
 
-	err = catHandler.catService.UpdateCategory(uint(categoryId), category.Name)
+	err = catHandler.catService.UpdateCategory(c.Request().Context(), userId, uint(categoryId), category)
 	if err != nil {
-		catHandler.logger.Error("failed to update category", zap.Error(err))
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update category")
+		return err
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "category has been updated seccusfully",
 	})
 }
+
+func (catHandler *CategoryHandler) DeleteCategory(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	categoryId, err := strconv.ParseUint(c.Param("categoryId"), 10, 32)
+	if err != nil {
+		return ErrInvalidCategoryId
+	}
+
+	var reassignTo *uint
+	if reassignToStr := c.QueryParam("reassignTo"); reassignToStr != "" {
+		target, err := strconv.ParseUint(reassignToStr, 10, 32)
+		if err != nil {
+			return ErrInvalidTargetCategory
+		}
+		targetId := uint(target)
+		reassignTo = &targetId
+	}
+
+	moved, err := catHandler.catService.DeleteCategory(c.Request().Context(), userId, uint(categoryId), reassignTo)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":         "category has been deleted successfully",
+		"reassignedPosts": moved,
+	})
+}
+
+func (catHandler *CategoryHandler) MergeCategory(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	categoryId, err := strconv.ParseUint(c.Param("categoryId"), 10, 32)
+	if err != nil {
+		return ErrInvalidCategoryId
+	}
+
+	var merge struct {
+		TargetId uint `json:"targetId" validate:"required"`
+	}
+	if err := c.Bind(&merge); err != nil {
+		return apperr.BadRequest("invalid_request", "failed to parse request body")
+	}
+
+	if err := catHandler.validator.Struct(merge); err != nil {
+		return apperr.FromValidator(err)
+	}
+
+	moved, err := catHandler.catService.MergeCategories(c.Request().Context(), userId, uint(categoryId), merge.TargetId)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"targetId":   merge.TargetId,
+		"movedPosts": moved,
+	})
+}
+
+func (catHandler *CategoryHandler) AddAlias(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	categoryId, err := strconv.ParseUint(c.Param("categoryId"), 10, 32)
+	if err != nil {
+		return ErrInvalidCategoryId
+	}
+
+	var alias struct {
+		Alias string `json:"alias" validate:"required,max=100"`
+	}
+	if err := c.Bind(&alias); err != nil {
+		return apperr.BadRequest("invalid_request", "failed to parse request body")
+	}
+
+	if err := catHandler.validator.Struct(alias); err != nil {
+		return apperr.FromValidator(err)
+	}
+
+	categoryAlias, err := catHandler.catService.AddAlias(c.Request().Context(), userId, uint(categoryId), alias.Alias)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, categoryAlias)
+}
+
+func (catHandler *CategoryHandler) RemoveAlias(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	categoryId, err := strconv.ParseUint(c.Param("categoryId"), 10, 32)
+	if err != nil {
+		return ErrInvalidCategoryId
+	}
+
+	if err := catHandler.catService.RemoveAlias(c.Request().Context(), userId, uint(categoryId), c.Param("alias")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
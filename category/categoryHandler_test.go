@@ -0,0 +1,148 @@
+package category
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"post-service/apperr"
+	"post-service/audit"
+	"post-service/database"
+	"post-service/validation"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// handlerTest serves the category routes over a MemoryCategoryStore.
+type handlerTest struct {
+	e     *echo.Echo
+	posts *memoryPosts
+}
+
+func newHandlerTest(t *testing.T) *handlerTest {
+	t.Helper()
+	validate, uni, err := validation.NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auditLog := audit.NewAuditService(audit.NewEntryRepository(newTestDB(t), &database.QueryTimeouts{Default: time.Second}))
+
+	store, posts := NewMemoryCategoryStore(), &memoryPosts{}
+	store.UsePosts(posts)
+	handler := NewCategoryHandler(NewCategoryService(store, auditLog), zap.NewNop(), validate)
+
+	e := echo.New()
+	e.HTTPErrorHandler = apperr.NewHTTPErrorHandler(uni)
+	e.GET("/categories", handler.GetAllCategories)
+	e.GET("/categories/:categoryId", handler.GetCategoryById)
+	categoryGroup := e.Group("/categories", func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("userId", uint(1))
+			return next(c)
+		}
+	})
+	categoryGroup.POST("", handler.CreateCategory)
+	categoryGroup.DELETE("/:categoryId", handler.DeleteCategory)
+	categoryGroup.POST("/:categoryId/merge", handler.MergeCategory)
+	return &handlerTest{e: e, posts: posts}
+}
+
+// do sends a request and decodes the JSON response into out unless it is nil.
+func (test *handlerTest) do(t *testing.T, method, target string, body interface{}, out interface{}) int {
+	t.Helper()
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, target, payload)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	test.e.ServeHTTP(rec, req)
+
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func (test *handlerTest) createCategory(t *testing.T, dto map[string]interface{}) uint {
+	t.Helper()
+	var created struct {
+		CategoryId uint `json:"categoryId"`
+	}
+	if code := test.do(t, http.MethodPost, "/categories", dto, &created); code != http.StatusCreated {
+		t.Fatalf("creating %v: got status %d", dto["name"], code)
+	}
+	return created.CategoryId
+}
+
+func TestCategoryHandler(t *testing.T) {
+	test := newHandlerTest(t)
+	tools := test.createCategory(t, map[string]interface{}{
+		"name":         "Power Tools",
+		"displayOrder": 2,
+		"aliases":      []string{"Drills"},
+		"translations": map[string]interface{}{"fr": map[string]string{"name": "Outils électriques"}},
+	})
+	garden := test.createCategory(t, map[string]interface{}{"name": "Garden", "displayOrder": 1})
+	test.posts.add(t, tools, true)
+	test.posts.add(t, tools, false)
+
+	var problem apperr.Problem
+	code := test.do(t, http.MethodPost, "/categories", map[string]interface{}{"name": "Drills"}, &problem)
+	if code != http.StatusConflict || problem.Code != "slug_taken" {
+		t.Errorf("duplicate slug: got %d %q", code, problem.Code)
+	}
+
+	var category CategoryResponse
+	if code := test.do(t, http.MethodGet, fmt.Sprintf("/categories/%d", tools), nil, &category); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if category.Slug != "power-tools" || category.PostCount != 1 || len(category.Aliases) != 1 || category.Aliases[0] != "Drills" {
+		t.Errorf("got %+v", category)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/categories", nil)
+	req.Header.Set("Accept-Language", "fr")
+	rec := httptest.NewRecorder()
+	test.e.ServeHTTP(rec, req)
+	var categories []CategoryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &categories); err != nil {
+		t.Fatal(err)
+	}
+	if len(categories) != 2 || categories[0].Name != "Garden" || categories[1].Name != "Outils électriques" {
+		t.Errorf("got %+v", categories)
+	}
+
+	problem = apperr.Problem{}
+	code = test.do(t, http.MethodDelete, fmt.Sprintf("/categories/%d", tools), nil, &problem)
+	if code != http.StatusConflict || problem.Code != "category_in_use" {
+		t.Errorf("deleting a used category: got %d %q", code, problem.Code)
+	}
+
+	var merged struct {
+		MovedPosts int64 `json:"movedPosts"`
+	}
+	code = test.do(t, http.MethodPost, fmt.Sprintf("/categories/%d/merge", tools), map[string]uint{"targetId": garden}, &merged)
+	if code != http.StatusOK || merged.MovedPosts != 2 {
+		t.Errorf("got %d with %+v", code, merged)
+	}
+
+	problem = apperr.Problem{}
+	code = test.do(t, http.MethodGet, fmt.Sprintf("/categories/%d", tools), nil, &problem)
+	if code != http.StatusNotFound || problem.Code != "category_not_found" {
+		t.Errorf("merged category: got %d %q", code, problem.Code)
+	}
+}
@@ -1,64 +1,495 @@
 package category
 
 import (
+	"context"
 	"errors"
-	"strings"
+	"post-service/database"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Category struct {
-	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
-	Name      string    `gorm:"unique" json:"name" validate:"required"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID           uint                  `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name         string                `gorm:"unique" json:"name"`
+	Slug         string                `gorm:"unique" json:"slug"`
+	Description  string                `json:"description"`
+	Icon         string                `json:"icon"`
+	DisplayOrder int                   `json:"displayOrder"`
+	IsActive     bool                  `json:"isActive"`
+	Aliases      []CategoryAlias       `gorm:"foreignKey:CategoryID" json:"aliases,omitempty"`
+	Translations []CategoryTranslation `gorm:"foreignKey:CategoryID" json:"translations,omitempty"`
+	CreatedAt    time.Time             `json:"createdAt"`
+	UpdatedAt    time.Time             `json:"updatedAt"`
+}
+
+// CategoryTranslation holds the name and description of a category in one
+// locale, such as "fa" or "fr_CA". Name and Description on Category itself
+// are the fallback used when no translation matches.
+type CategoryTranslation struct {
+	ID          uint   `gorm:"primaryKey;autoIncrement" json:"-"`
+	CategoryID  uint   `json:"-"`
+	Locale      string `json:"locale"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// CategoryAlias is an alternative name a category can be looked up by.
+// Redirect aliases hold slugs the category used before a rename or merge;
+// requests for them are redirected to the current slug.
+type CategoryAlias struct {
+	ID         uint      `gorm:"primaryKey;autoIncrement" json:"-"`
+	CategoryID uint      `json:"-"`
+	Alias      string    `json:"alias"`
+	Slug       string    `gorm:"unique" json:"slug"`
+	IsRedirect bool      `json:"isRedirect"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// CategoryStore keeps categories and their aliases and translations.
+// CategoryRepository keeps them in the database; MemoryCategoryStore, in
+// this process, stands in for it in tests. Lookups of a missing category
+// fail with gorm.ErrRecordNotFound. Changes run their hook, which may be nil,
+// before they are kept; it must not use the store, and an error from it
+// leaves the store unchanged.
+type CategoryStore interface {
+	AddCategory(ctx context.Context, category *Category, hook database.Hook) error
+	GetAllCategories(ctx context.Context) ([]Category, error)
+	GetCategoryById(ctx context.Context, categoryId uint) (*Category, error)
+	ResolveCategory(ctx context.Context, ref string) (category *Category, redirect bool, err error)
+	UpdateCategory(ctx context.Context, updatedCategory *Category, previousSlug string, hook database.Hook) error
+	AddAlias(ctx context.Context, alias *CategoryAlias, hook database.Hook) error
+	RemoveAlias(ctx context.Context, categoryId uint, slug string, hook database.Hook) error
+	CountPosts(ctx context.Context, categoryIds ...uint) (map[uint]int64, error)
+	DeleteCategory(ctx context.Context, categoryId uint, reassignTo *uint, hook database.Hook) (int64, error)
+	MergeCategories(ctx context.Context, sourceId, targetId uint, hook database.Hook) (int64, error)
 }
 
 type CategoryRepository struct {
-	db *gorm.DB
+	db       *gorm.DB
+	timeouts *database.QueryTimeouts
+	cache    *CategoryCache
 }
 
-func NewCategoryRepository(db *gorm.DB) *CategoryRepository {
-	return &CategoryRepository{db: db}
+func NewCategoryRepository(db *gorm.DB, timeouts *database.QueryTimeouts, cache *CategoryCache) *CategoryRepository {
+	return &CategoryRepository{db: db, timeouts: timeouts, cache: cache}
 }
 
-func (catRepo *CategoryRepository) AddCategory(category *Category) error {
-	if err := catRepo.db.Create(&category).Error; err != nil {
-		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
-			return errors.New("category already exists")
+func (catRepo *CategoryRepository) AddCategory(ctx context.Context, category *Category, hook database.Hook) error {
+	ctx, cancel := catRepo.timeouts.Context(ctx, "CategoryRepository.AddCategory")
+	defer cancel()
+
+	err := catRepo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		slugs := []string{category.Slug}
+		for _, alias := range category.Aliases {
+			slugs = append(slugs, alias.Slug)
+		}
+		if err := ensureSlugsFree(tx, 0, slugs...); err != nil {
+			return err
+		}
+		if err := tx.Create(category).Error; err != nil {
+			return err
+		}
+		return database.RunHook(ctx, tx, hook)
+	})
+	if err != nil {
+		if isDuplicateKey(err) {
+			return ErrCategoryExists
 		}
 		return err
 	}
+	catRepo.cache.Invalidate()
 	return nil
 }
 
-func (catRepo *CategoryRepository) GetAllCategories() ([]Category, error) {
+func (catRepo *CategoryRepository) GetAllCategories(ctx context.Context) ([]Category, error) {
+	ctx, cancel := catRepo.timeouts.Context(ctx, "CategoryRepository.GetAllCategories")
+	defer cancel()
+
 	var categories []Category
-	result := catRepo.db.Find(&categories)
+	result := catRepo.db.WithContext(ctx).Preload("Translations").Order("display_order, name").Find(&categories)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return categories, nil
 }
 
-func (catRepo *CategoryRepository) GetCategoryById(categoryId uint) (*Category, error) {
+func (catRepo *CategoryRepository) GetCategoryById(ctx context.Context, categoryId uint) (*Category, error) {
+	if category, ok := catRepo.cache.GetById(categoryId); ok {
+		return category, nil
+	}
+
+	ctx, cancel := catRepo.timeouts.Context(ctx, "CategoryRepository.GetCategoryById")
+	defer cancel()
+
 	var category Category
-	err := catRepo.db.First(&category, categoryId).Error
+	err := catRepo.db.WithContext(ctx).Preload("Aliases").Preload("Translations").First(&category, categoryId).Error
 	if err != nil {
 		return nil, err
 	}
+	catRepo.cache.Put(category)
 	return &category, nil
 }
 
-func (catRepo *CategoryRepository) GetCategoryByName(categoryName string) (*Category, error) {
-	var category Category
-	if err := catRepo.db.Where("name = ?", categoryName).First(&category).Error; err != nil {
+// ResolveCategory finds a category by its slug, name or any alias, ignoring
+// case and accents. redirect is true when ref matched a slug the category no
+// longer uses, so callers can point clients at the current one.
+func (catRepo *CategoryRepository) ResolveCategory(ctx context.Context, ref string) (category *Category, redirect bool, err error) {
+	slug := Slugify(ref)
+	if slug == "" {
+		return nil, false, gorm.ErrRecordNotFound
+	}
+	if category, redirect, ok := catRepo.cache.GetBySlug(slug); ok {
+		return category, redirect, nil
+	}
+	if catRepo.cache.IsMissing(slug) {
+		return nil, false, gorm.ErrRecordNotFound
+	}
+
+	ctx, cancel := catRepo.timeouts.Context(ctx, "CategoryRepository.ResolveCategory")
+	defer cancel()
+
+	var found Category
+	result := catRepo.db.WithContext(ctx).Preload("Aliases").Preload("Translations").Where("slug = ?", slug).Limit(1).Find(&found)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		var categoryId uint
+		categoryId, redirect, err = catRepo.resolveOther(ctx, slug)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				catRepo.cache.PutMissing(slug)
+			}
+			return nil, false, err
+		}
+		if err := catRepo.db.WithContext(ctx).Preload("Aliases").Preload("Translations").First(&found, categoryId).Error; err != nil {
+			return nil, false, err
+		}
+	}
+
+	catRepo.cache.Put(found)
+	return &found, redirect, nil
+}
+
+// resolveOther finds the category with an alias slug, or failing that a
+// name, matching slug.
+func (catRepo *CategoryRepository) resolveOther(ctx context.Context, slug string) (uint, bool, error) {
+	var alias CategoryAlias
+	result := catRepo.db.WithContext(ctx).Where("slug = ?", slug).Limit(1).Find(&alias)
+	if result.Error != nil {
+		return 0, false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return alias.CategoryID, alias.IsRedirect, nil
+	}
+
+	// Names are compared as slugs, which SQL cannot compute; there are few
+	// categories, and this only runs on a cache miss.
+	var categories []Category
+	if err := catRepo.db.WithContext(ctx).Select("id", "name").Find(&categories).Error; err != nil {
+		return 0, false, err
+	}
+	for _, category := range categories {
+		if Slugify(category.Name) == slug {
+			return category.ID, false, nil
+		}
+	}
+	return 0, false, gorm.ErrRecordNotFound
+}
+
+// UpdateCategory saves a renamed category. When its slug changed, the previous
+// slug is kept as a redirect alias so existing links keep working.
+func (catRepo *CategoryRepository) UpdateCategory(ctx context.Context, updatedCategory *Category, previousSlug string, hook database.Hook) error {
+	ctx, cancel := catRepo.timeouts.Context(ctx, "CategoryRepository.UpdateCategory")
+	defer cancel()
+
+	err := catRepo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := ensureSlugsFree(tx, updatedCategory.ID, updatedCategory.Slug); err != nil {
+			return err
+		}
+		// The new slug may be one this category used before.
+		err := tx.Where("category_id = ? AND slug = ?", updatedCategory.ID, updatedCategory.Slug).Delete(&CategoryAlias{}).Error
+		if err != nil {
+			return err
+		}
+		if previousSlug != "" && previousSlug != updatedCategory.Slug {
+			redirect := CategoryAlias{CategoryID: updatedCategory.ID, Alias: previousSlug, Slug: previousSlug, IsRedirect: true}
+			if err := tx.Create(&redirect).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("category_id = ?", updatedCategory.ID).Delete(&CategoryTranslation{}).Error; err != nil {
+			return err
+		}
+		for i := range updatedCategory.Translations {
+			updatedCategory.Translations[i].ID = 0
+			updatedCategory.Translations[i].CategoryID = updatedCategory.ID
+		}
+		if len(updatedCategory.Translations) > 0 {
+			if err := tx.Create(&updatedCategory.Translations).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Omit(clause.Associations).Save(updatedCategory).Error; err != nil {
+			return err
+		}
+		return database.RunHook(ctx, tx, hook)
+	})
+	if err != nil {
+		if isDuplicateKey(err) {
+			return ErrCategoryExists
+		}
+		return err
+	}
+	catRepo.cache.Invalidate()
+	return nil
+}
+
+func (catRepo *CategoryRepository) AddAlias(ctx context.Context, alias *CategoryAlias, hook database.Hook) error {
+	ctx, cancel := catRepo.timeouts.Context(ctx, "CategoryRepository.AddAlias")
+	defer cancel()
+
+	err := catRepo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := lockCategories(tx, alias.CategoryID); err != nil {
+			return err
+		}
+		if err := ensureSlugsFree(tx, 0, alias.Slug); err != nil {
+			return err
+		}
+		if err := tx.Create(alias).Error; err != nil {
+			return err
+		}
+		return database.RunHook(ctx, tx, hook)
+	})
+	if err != nil {
+		if isDuplicateKey(err) {
+			return ErrSlugTaken
+		}
+		return err
+	}
+	catRepo.cache.Invalidate()
+	return nil
+}
+
+func (catRepo *CategoryRepository) RemoveAlias(ctx context.Context, categoryId uint, slug string, hook database.Hook) error {
+	ctx, cancel := catRepo.timeouts.Context(ctx, "CategoryRepository.RemoveAlias")
+	defer cancel()
+
+	err := catRepo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("category_id = ? AND slug = ?", categoryId, slug).Delete(&CategoryAlias{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrAliasNotFound
+		}
+		return database.RunHook(ctx, tx, hook)
+	})
+	if err != nil {
+		return err
+	}
+	catRepo.cache.Invalidate()
+	return nil
+}
+
+// CountPosts returns the number of active posts per category id. When
+// categoryIds is empty every category is counted.
+func (catRepo *CategoryRepository) CountPosts(ctx context.Context, categoryIds ...uint) (map[uint]int64, error) {
+	ctx, cancel := catRepo.timeouts.Context(ctx, "CategoryRepository.CountPosts")
+	defer cancel()
+
+	var rows []struct {
+		CategoryID uint
+		Count      int64
+	}
+	query := catRepo.db.WithContext(ctx).Table("posts").
+		Select("category_id, COUNT(*) AS count").
+		Where("is_active = ?", true).
+		Group("category_id")
+	if len(categoryIds) > 0 {
+		query = query.Where("category_id IN ?", categoryIds)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
 		return nil, err
 	}
-	return &category, nil
+
+	counts := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		counts[row.CategoryID] = row.Count
+	}
+	return counts, nil
+}
+
+// DeleteCategory removes a category. Posts still referencing it are moved to
+// reassignTo when given; otherwise the delete is refused with ErrCategoryInUse.
+// It returns the number of posts that were moved.
+func (catRepo *CategoryRepository) DeleteCategory(ctx context.Context, categoryId uint, reassignTo *uint, hook database.Hook) (int64, error) {
+	ctx, cancel := catRepo.timeouts.Context(ctx, "CategoryRepository.DeleteCategory")
+	defer cancel()
+
+	var moved int64
+	err := catRepo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if reassignTo == nil {
+			if _, err := lockCategories(tx, categoryId); err != nil {
+				return err
+			}
+			var count int64
+			if err := tx.Table("posts").Where("category_id = ?", categoryId).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrCategoryInUse
+			}
+			if err := deleteCategory(tx, categoryId); err != nil {
+				return err
+			}
+			return database.RunHook(ctx, tx, hook)
+		}
+
+		var err error
+		if moved, err = moveAndDelete(tx, categoryId, *reassignTo); err != nil {
+			return err
+		}
+		return database.RunHook(ctx, tx, hook)
+	})
+	if err != nil {
+		return 0, err
+	}
+	catRepo.cache.Invalidate()
+	return moved, nil
+}
+
+// MergeCategories atomically moves the posts and aliases of the source
+// category into the target and deletes the source, whose slug becomes a
+// redirect alias of the target. It returns the number of moved posts.
+func (catRepo *CategoryRepository) MergeCategories(ctx context.Context, sourceId, targetId uint, hook database.Hook) (int64, error) {
+	ctx, cancel := catRepo.timeouts.Context(ctx, "CategoryRepository.MergeCategories")
+	defer cancel()
+
+	var moved int64
+	err := catRepo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		source, err := lockCategories(tx, sourceId, targetId)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&CategoryAlias{}).Where("category_id = ?", sourceId).Update("category_id", targetId).Error
+		if err != nil {
+			return err
+		}
+
+		moved, err = moveAndDelete(tx, sourceId, targetId)
+		if err != nil {
+			return err
+		}
+
+		redirect := CategoryAlias{CategoryID: targetId, Alias: source.Name, Slug: source.Slug, IsRedirect: true}
+		if err := tx.Create(&redirect).Error; err != nil {
+			return err
+		}
+		return database.RunHook(ctx, tx, hook)
+	})
+	if err != nil {
+		return 0, err
+	}
+	catRepo.cache.Invalidate()
+	return moved, nil
+}
+
+func moveAndDelete(tx *gorm.DB, sourceId, targetId uint) (int64, error) {
+	if sourceId == targetId {
+		return 0, ErrInvalidTargetCategory
+	}
+	if _, err := lockCategories(tx, sourceId, targetId); err != nil {
+		return 0, err
+	}
+
+	result := tx.Table("posts").Where("category_id = ?", sourceId).Updates(map[string]interface{}{
+		"category_id": targetId,
+		"updated_at":  time.Now(),
+	})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, deleteCategory(tx, sourceId)
+}
+
+// lockCategories locks the given categories, in id order to avoid deadlocks
+// between concurrent merges, and fails if any of them does not exist.
+// The first id is the category being changed, which is returned; the others
+// are targets.
+func lockCategories(tx *gorm.DB, categoryIds ...uint) (*Category, error) {
+	var locked []Category
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", categoryIds).
+		Order("id").
+		Find(&locked).Error
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[uint]*Category{}
+	for i := range locked {
+		found[locked[i].ID] = &locked[i]
+	}
+	if found[categoryIds[0]] == nil {
+		return nil, ErrCategoryNotFound
+	}
+	for _, categoryId := range categoryIds[1:] {
+		if found[categoryId] == nil {
+			return nil, ErrInvalidTargetCategory
+		}
+	}
+	return found[categoryIds[0]], nil
+}
+
+// ensureSlugsFree fails with ErrSlugTaken when any of the slugs is already
+// used by a category other than exceptId, or by one of its aliases.
+func ensureSlugsFree(tx *gorm.DB, exceptId uint, slugs ...string) error {
+	var count int64
+	err := tx.Model(&Category{}).Where("slug IN ? AND id <> ?", slugs, exceptId).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrSlugTaken
+	}
+
+	err = tx.Model(&CategoryAlias{}).Where("slug IN ? AND category_id <> ?", slugs, exceptId).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrSlugTaken
+	}
+	return nil
+}
+
+func deleteCategory(tx *gorm.DB, categoryId uint) error {
+	if err := tx.Delete(&Category{}, categoryId).Error; err != nil {
+		if hasErrorCode(err, foreignKeyViolation) {
+			return ErrCategoryInUse
+		}
+		return err
+	}
+	return nil
+}
+
+// PostgreSQL error codes of the constraint violations the store maps.
+const (
+	foreignKeyViolation = "23503"
+	uniqueViolation     = "23505"
+)
+
+func isDuplicateKey(err error) bool {
+	return hasErrorCode(err, uniqueViolation)
 }
 
-func (catRepo *CategoryRepository) UpdateCategory(updatedCategory *Category) error {
-	return catRepo.db.Save(updatedCategory).Error
+func hasErrorCode(err error, code string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == code
 }
@@ -1,81 +1,394 @@
 package category
 
 import (
+	"context"
 	"errors"
-	"net/http"
+	"post-service/apperr"
+	"post-service/audit"
+	"post-service/logging"
+	"strings"
 	"time"
 
-	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+var tracer = otel.Tracer("post-service/category")
+
+var ErrCategoryNotFound = apperr.NotFound("category_not_found", "category not found")
+var ErrCategoryExists = apperr.Conflict("category_exists", "category already exists")
+var ErrInvalidCategoryId = apperr.BadRequest("invalid_category_id", "category id must be a positive integer")
+var ErrSlugTaken = apperr.Conflict("slug_taken", "slug or alias is already used by another category")
+var ErrAliasNotFound = apperr.NotFound("alias_not_found", "alias not found")
+var ErrInvalidSlug = apperr.Validation("invalid_slug", "slug must contain at least one letter or digit",
+	apperr.FieldError{Field: "slug", Rule: "slug", Message: "slug must contain at least one letter or digit"})
+var ErrCategoryInUse = apperr.Conflict("category_in_use", "category still has posts; pass reassignTo to move them to another category")
+var ErrInvalidTargetCategory = apperr.Validation("invalid_target_category", "target category must exist and differ from the deleted one",
+	apperr.FieldError{Field: "targetId", Rule: "exists", Message: "target category must exist and differ from the deleted one"})
+
+// MovedError is returned when a category was requested by a slug it no longer
+// uses. Slug is the category's current slug.
+type MovedError struct {
+	Slug string
+}
+
+func (e *MovedError) Error() string {
+	return "category moved to " + e.Slug
+}
+
+type CategoryResponse struct {
+	ID           uint     `json:"id"`
+	Slug         string   `json:"slug"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Locale       string   `json:"locale,omitempty"`
+	Icon         string   `json:"icon"`
+	DisplayOrder int      `json:"displayOrder"`
+	IsActive     bool     `json:"isActive"`
+	PostCount    int64    `json:"postCount"`
+	Aliases      []string `json:"aliases,omitempty"`
+}
+
+// Audit log actions on categories.
+const (
+	AuditCategoryCreate      = "category.create"
+	AuditCategoryUpdate      = "category.update"
+	AuditCategoryDelete      = "category.delete"
+	AuditCategoryMerge       = "category.merge"
+	AuditCategoryMergeInto   = "category.merge_into"
+	AuditCategoryAliasAdd    = "category.alias_add"
+	AuditCategoryAliasRemove = "category.alias_remove"
+)
+
 type CategoryService struct {
-	catRepo *CategoryRepository
-	logger  *zap.Logger
+	catRepo  CategoryStore
+	auditLog *audit.AuditService
 }
 
-func NewCategoryService(catRepo *CategoryRepository, logger *zap.Logger) *CategoryService {
-	return &CategoryService{catRepo: catRepo, logger: logger}
+func NewCategoryService(catRepo CategoryStore, auditLog *audit.AuditService) *CategoryService {
+	return &CategoryService{catRepo: catRepo, auditLog: auditLog}
 }
 
-func (catService *CategoryService) CreateCategory(category Category) (uint, error) {
-	category.CreatedAt = time.Now()
-	err := catService.catRepo.AddCategory(&category)
+func (catService *CategoryService) CreateCategory(ctx context.Context, actorId uint, dto CategoryDto) (uint, error) {
+	ctx, span := tracer.Start(ctx, "CategoryService.CreateCategory")
+	defer span.End()
+
+	slug := dto.Slug
+	if slug == "" {
+		slug = dto.Name
+	}
+	category := Category{
+		Name:         dto.Name,
+		Slug:         Slugify(slug),
+		Description:  dto.Description,
+		Icon:         dto.Icon,
+		DisplayOrder: dto.DisplayOrder,
+		IsActive:     dto.IsActive == nil || *dto.IsActive,
+		Translations: toTranslations(dto.Translations),
+		CreatedAt:    time.Now(),
+	}
+	if category.Slug == "" {
+		return 0, ErrInvalidSlug
+	}
+	for _, alias := range dto.Aliases {
+		aliasSlug := Slugify(alias)
+		if aliasSlug == "" || aliasSlug == category.Slug {
+			continue
+		}
+		category.Aliases = append(category.Aliases, CategoryAlias{Alias: alias, Slug: aliasSlug, CreatedAt: category.CreatedAt})
+	}
+
+	err := catService.catRepo.AddCategory(ctx, &category, func(ctx context.Context) error {
+		return catService.auditLog.Record(ctx, actorId, AuditCategoryCreate, audit.TargetCategory, category.ID, nil, category)
+	})
 	if err != nil {
-		catService.logger.Error("error adding category", zap.Error(err))
-		return 0, echo.NewHTTPError(http.StatusInternalServerError, "fail to add category")
+		if _, ok := apperr.As(err); !ok {
+			logging.FromContext(ctx).Error("error adding category", zap.Error(err))
+		}
+		return 0, err
 	}
 	return category.ID, nil
 }
 
-func (catService *CategoryService) GetAllCategories() ([]string, error) {
-	categories, err := catService.catRepo.GetAllCategories()
+// GetAllCategories lists categories in display order, localized for the
+// given preferred languages. Inactive categories are left out unless asked for.
+func (catService *CategoryService) GetAllCategories(ctx context.Context, languages []string, includeInactive bool) ([]CategoryResponse, error) {
+	ctx, span := tracer.Start(ctx, "CategoryService.GetAllCategories")
+	defer span.End()
+
+	categories, err := catService.catRepo.GetAllCategories(ctx)
 	if err != nil {
-		catService.logger.Error("error retrieving categories")
-		return nil, echo.NewHTTPError(http.StatusInternalServerError)
+		logging.FromContext(ctx).Error("error retrieving categories", zap.Error(err))
+		return nil, err
 	}
 
-	var categoryList []string
+	counts, err := catService.catRepo.CountPosts(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("error counting posts per category", zap.Error(err))
+		return nil, err
+	}
+
+	categoryList := []CategoryResponse{}
 	for _, category := range categories {
-		categoryList = append(categoryList, category.Name)
+		if !category.IsActive && !includeInactive {
+			continue
+		}
+		categoryList = append(categoryList, toResponse(category, counts[category.ID], languages))
 	}
 
 	return categoryList, nil
 }
 
-func (catService *CategoryService) GetCategoryById(categoryId uint) (string, error) {
-	category, err := catService.catRepo.GetCategoryById(categoryId)
+func (catService *CategoryService) GetCategoryById(ctx context.Context, categoryId uint, languages []string) (*CategoryResponse, error) {
+	ctx, span := tracer.Start(ctx, "CategoryService.GetCategoryById")
+	defer span.End()
+
+	category, err := catService.catRepo.GetCategoryById(ctx, categoryId)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			catService.logger.Error("category not found", zap.Error(err))
-			return "", echo.NewHTTPError(http.StatusNotFound, "category not found")
+			return nil, ErrCategoryNotFound
 		}
-		catService.logger.Error("error retrieving category", zap.Error(err))
-		return "", echo.NewHTTPError(http.StatusInternalServerError, "failed to retrive category")
+		logging.FromContext(ctx).Error("error retrieving category", zap.Error(err))
+		return nil, err
+	}
+
+	counts, err := catService.catRepo.CountPosts(ctx, categoryId)
+	if err != nil {
+		logging.FromContext(ctx).Error("error counting posts per category", zap.Error(err))
+		return nil, err
 	}
 
-	categoryName := category.Name
-	return categoryName, nil
+	response := toResponse(*category, counts[categoryId], languages)
+	return &response, nil
 }
 
-func (catService *CategoryService) UpdateCategory(categoryId uint, categoryName string) error {
-	category, err := catService.catRepo.GetCategoryById(categoryId)
+// UpdateCategory replaces the category's attributes. Translations are only
+// replaced when given, and the active flag is kept when omitted.
+func (catService *CategoryService) UpdateCategory(ctx context.Context, actorId, categoryId uint, dto UpdateCategoryDto) error {
+	ctx, span := tracer.Start(ctx, "CategoryService.UpdateCategory")
+	defer span.End()
+
+	category, err := catService.catRepo.GetCategoryById(ctx, categoryId)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			catService.logger.Error("category not found", zap.Error(err))
-			return echo.NewHTTPError(http.StatusNotFound, "category not found")
+			return ErrCategoryNotFound
 		}
-		catService.logger.Error("error retrieving category", zap.Error(err))
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to retrive category")
+		logging.FromContext(ctx).Error("error retrieving category", zap.Error(err))
+		return err
+	}
+
+	slug := dto.Slug
+	if slug == "" {
+		slug = dto.Name
+	}
+	before := *category
+	previousSlug := category.Slug
+	category.Name = dto.Name
+	category.Slug = Slugify(slug)
+	if category.Slug == "" {
+		return ErrInvalidSlug
+	}
+	category.Description = dto.Description
+	category.Icon = dto.Icon
+	category.DisplayOrder = dto.DisplayOrder
+	if dto.IsActive != nil {
+		category.IsActive = *dto.IsActive
+	}
+	if dto.Translations != nil {
+		category.Translations = toTranslations(dto.Translations)
 	}
 
-	category.Name = categoryName
-	err = catService.catRepo.UpdateCategory(category)
+	err = catService.catRepo.UpdateCategory(ctx, category, previousSlug, func(ctx context.Context) error {
+		return catService.auditLog.Record(ctx, actorId, AuditCategoryUpdate, audit.TargetCategory, category.ID, before, category)
+	})
 	if err != nil {
-		catService.logger.Error("error updating category", zap.Error(err))
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update category")
+		if _, ok := apperr.As(err); !ok {
+			logging.FromContext(ctx).Error("error updating category", zap.Error(err))
+		}
+		return err
 	}
 
 	return nil
 }
+
+func (catService *CategoryService) AddAlias(ctx context.Context, actorId, categoryId uint, alias string) (*CategoryAlias, error) {
+	ctx, span := tracer.Start(ctx, "CategoryService.AddAlias")
+	defer span.End()
+
+	categoryAlias := CategoryAlias{CategoryID: categoryId, Alias: alias, Slug: Slugify(alias), CreatedAt: time.Now()}
+	if categoryAlias.Slug == "" {
+		return nil, ErrInvalidSlug
+	}
+
+	err := catService.catRepo.AddAlias(ctx, &categoryAlias, func(ctx context.Context) error {
+		return catService.auditLog.Record(ctx, actorId, AuditCategoryAliasAdd, audit.TargetCategory, categoryId, nil, categoryAlias)
+	})
+	if err != nil {
+		if _, ok := apperr.As(err); !ok {
+			logging.FromContext(ctx).Error("error adding category alias", zap.Error(err))
+		}
+		return nil, err
+	}
+	return &categoryAlias, nil
+}
+
+func (catService *CategoryService) RemoveAlias(ctx context.Context, actorId, categoryId uint, alias string) error {
+	ctx, span := tracer.Start(ctx, "CategoryService.RemoveAlias")
+	defer span.End()
+
+	slug := Slugify(alias)
+	err := catService.catRepo.RemoveAlias(ctx, categoryId, slug, func(ctx context.Context) error {
+		return catService.auditLog.Record(ctx, actorId, AuditCategoryAliasRemove, audit.TargetCategory, categoryId, CategoryAlias{Alias: alias, Slug: slug}, nil)
+	})
+	if err != nil {
+		if _, ok := apperr.As(err); !ok {
+			logging.FromContext(ctx).Error("error removing category alias", zap.Error(err))
+		}
+		return err
+	}
+	return nil
+}
+
+func (catService *CategoryService) DeleteCategory(ctx context.Context, actorId, categoryId uint, reassignTo *uint) (int64, error) {
+	ctx, span := tracer.Start(ctx, "CategoryService.DeleteCategory")
+	defer span.End()
+
+	before, err := catService.auditSnapshot(ctx, categoryId)
+	if err != nil {
+		return 0, err
+	}
+	moved, err := catService.catRepo.DeleteCategory(ctx, categoryId, reassignTo, func(ctx context.Context) error {
+		return catService.auditLog.Record(ctx, actorId, AuditCategoryDelete, audit.TargetCategory, categoryId, before, nil)
+	})
+	if err != nil {
+		if _, ok := apperr.As(err); !ok {
+			logging.FromContext(ctx).Error("error deleting category", zap.Error(err))
+		}
+		return 0, err
+	}
+	return moved, nil
+}
+
+// MergeCategories moves the source category's posts and slugs to the target
+// and removes the source. Both categories get an audit entry.
+func (catService *CategoryService) MergeCategories(ctx context.Context, actorId, sourceId, targetId uint) (int64, error) {
+	ctx, span := tracer.Start(ctx, "CategoryService.MergeCategories")
+	defer span.End()
+
+	source, err := catService.auditSnapshot(ctx, sourceId)
+	if err != nil {
+		return 0, err
+	}
+	target, err := catService.auditSnapshot(ctx, targetId)
+	if err != nil {
+		return 0, err
+	}
+	moved, err := catService.catRepo.MergeCategories(ctx, sourceId, targetId, func(ctx context.Context) error {
+		if err := catService.auditLog.Record(ctx, actorId, AuditCategoryMerge, audit.TargetCategory, sourceId, source, nil); err != nil {
+			return err
+		}
+		return catService.auditLog.Record(ctx, actorId, AuditCategoryMergeInto, audit.TargetCategory, targetId, target, mergedSnapshot(source, target))
+	})
+	if err != nil {
+		if _, ok := apperr.As(err); !ok {
+			logging.FromContext(ctx).Error("error merging categories", zap.Error(err))
+		}
+		return 0, err
+	}
+	return moved, nil
+}
+
+// mergedSnapshot is target as MergeCategories leaves it: with the source's
+// aliases and a redirect from the source's slug. Hooks cannot use the store,
+// so it is worked out rather than reloaded.
+func mergedSnapshot(source, target *Category) *Category {
+	if source == nil || target == nil {
+		return nil
+	}
+	merged := *target
+	merged.Aliases = append([]CategoryAlias(nil), target.Aliases...)
+	for _, alias := range source.Aliases {
+		alias.CategoryID = target.ID
+		merged.Aliases = append(merged.Aliases, alias)
+	}
+	merged.Aliases = append(merged.Aliases, CategoryAlias{
+		CategoryID: target.ID,
+		Alias:      source.Name,
+		Slug:       source.Slug,
+		IsRedirect: true,
+		CreatedAt:  time.Now(),
+	})
+	return &merged
+}
+
+// auditSnapshot loads a category as the audit log records it, or nil if it
+// does not exist; the change itself reports that.
+func (catService *CategoryService) auditSnapshot(ctx context.Context, categoryId uint) (*Category, error) {
+	category, err := catService.catRepo.GetCategoryById(ctx, categoryId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("error retrieving category", zap.Error(err))
+		return nil, err
+	}
+	return category, nil
+}
+
+func toTranslations(dtos map[string]CategoryTranslationDto) []CategoryTranslation {
+	translations := make([]CategoryTranslation, 0, len(dtos))
+	for locale, dto := range dtos {
+		translations = append(translations, CategoryTranslation{
+			Locale:      normalizeLocale(locale),
+			Name:        dto.Name,
+			Description: dto.Description,
+		})
+	}
+	return translations
+}
+
+// toResponse renders a category with its name and description in the first
+// of the preferred languages it has a translation for.
+func toResponse(category Category, postCount int64, languages []string) CategoryResponse {
+	response := CategoryResponse{
+		ID:           category.ID,
+		Slug:         category.Slug,
+		Name:         category.Name,
+		Description:  category.Description,
+		Icon:         category.Icon,
+		DisplayOrder: category.DisplayOrder,
+		IsActive:     category.IsActive,
+		PostCount:    postCount,
+	}
+	for _, alias := range category.Aliases {
+		if !alias.IsRedirect {
+			response.Aliases = append(response.Aliases, alias.Alias)
+		}
+	}
+
+	for _, language := range languages {
+		for _, translation := range category.Translations {
+			if translation.Locale != normalizeLocale(language) {
+				continue
+			}
+			response.Name = translation.Name
+			if translation.Description != "" {
+				response.Description = translation.Description
+			}
+			response.Locale = translation.Locale
+			return response
+		}
+	}
+	return response
+}
+
+// normalizeLocale turns "fr-ca" or "FR_CA" into "fr_CA", the form the
+// translator packages use.
+func normalizeLocale(locale string) string {
+	base, region, found := strings.Cut(strings.ReplaceAll(locale, "-", "_"), "_")
+	if !found {
+		return strings.ToLower(base)
+	}
+	return strings.ToLower(base) + "_" + strings.ToUpper(region)
+}
@@ -0,0 +1,536 @@
+package category
+
+import (
+	"context"
+	"errors"
+	"post-service/audit"
+	"post-service/database"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testPosts seeds the posts a store counts and moves.
+type testPosts interface {
+	add(t *testing.T, categoryId uint, active bool)
+}
+
+// categoryStores are the CategoryStore implementations the conformance tests
+// run against.
+var categoryStores = []struct {
+	name string
+	new  func(t *testing.T) (CategoryStore, testPosts)
+}{
+	{"gorm", newGormCategoryStore},
+	{"memory", newMemoryCategoryStore},
+}
+
+// gormPosts is the posts table as CategoryRepository sees it.
+type gormPosts struct {
+	db *gorm.DB
+}
+
+func (posts gormPosts) add(t *testing.T, categoryId uint, active bool) {
+	t.Helper()
+	err := posts.db.Exec("INSERT INTO posts (category_id, is_active, updated_at) VALUES (?, ?, ?)", categoryId, active, time.Now()).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testDialector reports SQLite constraint violations as PostgreSQL does, so
+// that the repository maps them the same way in tests.
+type testDialector struct {
+	*sqlite.Dialector
+}
+
+func (dialector testDialector) Translate(err error) error {
+	switch dialector.Dialector.Translate(err) {
+	case gorm.ErrDuplicatedKey:
+		return &pgconn.PgError{Code: uniqueViolation, Message: err.Error()}
+	case gorm.ErrForeignKeyViolated:
+		return &pgconn.PgError{Code: foreignKeyViolation, Message: err.Error()}
+	}
+	return err
+}
+
+// newTestDB returns an empty in-memory database with the tables of
+// categories and the audit log.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dialector := testDialector{&sqlite.Dialector{DSN: "file::memory:"}}
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&Category{}, &CategoryAlias{}, &CategoryTranslation{}, &audit.Entry{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newGormCategoryStore(t *testing.T) (CategoryStore, testPosts) {
+	t.Helper()
+	db := newTestDB(t)
+	err := db.Exec(`CREATE TABLE posts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		category_id INTEGER NOT NULL,
+		is_active BOOLEAN NOT NULL,
+		updated_at DATETIME
+	)`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeouts := &database.QueryTimeouts{Default: time.Second}
+	return NewCategoryRepository(db, timeouts, NewCategoryCache()), gormPosts{db: db}
+}
+
+// memoryPosts is a PostIndex over bare posts.
+type memoryPosts struct {
+	posts []struct {
+		categoryId uint
+		active     bool
+	}
+}
+
+func (posts *memoryPosts) add(t *testing.T, categoryId uint, active bool) {
+	posts.posts = append(posts.posts, struct {
+		categoryId uint
+		active     bool
+	}{categoryId, active})
+}
+
+func (posts *memoryPosts) CountPostsByCategory(activeOnly bool) map[uint]int64 {
+	counts := map[uint]int64{}
+	for _, post := range posts.posts {
+		if post.active || !activeOnly {
+			counts[post.categoryId]++
+		}
+	}
+	return counts
+}
+
+func (posts *memoryPosts) MovePosts(sourceId, targetId uint) int64 {
+	var moved int64
+	for i := range posts.posts {
+		if posts.posts[i].categoryId == sourceId {
+			posts.posts[i].categoryId = targetId
+			moved++
+		}
+	}
+	return moved
+}
+
+func newMemoryCategoryStore(t *testing.T) (CategoryStore, testPosts) {
+	store, posts := NewMemoryCategoryStore(), &memoryPosts{}
+	store.UsePosts(posts)
+	return store, posts
+}
+
+// seedCategories adds the categories the tests share: Tools, with the alias
+// "Hand tools", and Garden, listed first.
+func seedCategories(t *testing.T, store CategoryStore) (tools, garden *Category) {
+	t.Helper()
+	ctx := context.Background()
+	tools = &Category{
+		Name:         "Tools",
+		Slug:         "tools",
+		DisplayOrder: 2,
+		IsActive:     true,
+		Aliases:      []CategoryAlias{{Alias: "Hand tools", Slug: "hand-tools"}},
+		Translations: []CategoryTranslation{{Locale: "fr", Name: "Outils"}},
+	}
+	garden = &Category{Name: "Garden", Slug: "garden", DisplayOrder: 1, IsActive: true}
+	for _, category := range []*Category{tools, garden} {
+		if err := store.AddCategory(ctx, category, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tools, garden
+}
+
+func aliasSlugs(category *Category) []string {
+	slugs := []string{}
+	for _, alias := range category.Aliases {
+		slugs = append(slugs, alias.Slug)
+	}
+	sort.Strings(slugs)
+	return slugs
+}
+
+func assertErr(t *testing.T, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("got error %v, want %v", err, want)
+	}
+}
+
+func TestCategoryStore(t *testing.T) {
+	for _, impl := range categoryStores {
+		t.Run(impl.name, func(t *testing.T) {
+			testCategoryStore(t, impl.new)
+		})
+	}
+}
+
+func testCategoryStore(t *testing.T, newStore func(t *testing.T) (CategoryStore, testPosts)) {
+	ctx := context.Background()
+
+	t.Run("add and get", func(t *testing.T) {
+		store, _ := newStore(t)
+		tools, _ := seedCategories(t, store)
+		if tools.ID == 0 || tools.Aliases[0].CategoryID != tools.ID {
+			t.Fatalf("ids were not assigned: %+v", tools)
+		}
+
+		found, err := store.GetCategoryById(ctx, tools.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.Name != "Tools" || len(found.Translations) != 1 || found.Translations[0].Name != "Outils" {
+			t.Errorf("got %+v", found)
+		}
+		if slugs := aliasSlugs(found); len(slugs) != 1 || slugs[0] != "hand-tools" {
+			t.Errorf("got aliases %v", slugs)
+		}
+
+		_, err = store.GetCategoryById(ctx, tools.ID+100)
+		assertErr(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("add conflicts", func(t *testing.T) {
+		store, _ := newStore(t)
+		seedCategories(t, store)
+
+		assertErr(t, store.AddCategory(ctx, &Category{Name: "Tools", Slug: "other"}, nil), ErrCategoryExists)
+		assertErr(t, store.AddCategory(ctx, &Category{Name: "Other", Slug: "garden"}, nil), ErrSlugTaken)
+		assertErr(t, store.AddCategory(ctx, &Category{Name: "Other", Slug: "hand-tools"}, nil), ErrSlugTaken)
+		assertErr(t, store.AddCategory(ctx, &Category{
+			Name:    "Other",
+			Slug:    "other",
+			Aliases: []CategoryAlias{{Alias: "Tools", Slug: "tools"}},
+		}, nil), ErrSlugTaken)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		store, _ := newStore(t)
+		seedCategories(t, store)
+		if err := store.AddCategory(ctx, &Category{Name: "Bikes", Slug: "bikes", DisplayOrder: 2}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		categories, err := store.GetAllCategories(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, category := range categories {
+			names = append(names, category.Name)
+			if len(category.Aliases) != 0 {
+				t.Errorf("%s: aliases were loaded", category.Name)
+			}
+		}
+		if want := []string{"Garden", "Bikes", "Tools"}; !equalStrings(names, want) {
+			t.Errorf("got %v, want %v", names, want)
+		}
+		if len(categories[2].Translations) != 1 {
+			t.Errorf("translations were not loaded: %+v", categories[2])
+		}
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		store, _ := newStore(t)
+		tools, garden := seedCategories(t, store)
+
+		for _, ref := range []string{"tools", "TOOLS", "Hand Tools", "hand-tools"} {
+			found, redirect, err := store.ResolveCategory(ctx, ref)
+			if err != nil {
+				t.Fatalf("%q: %v", ref, err)
+			}
+			if found.ID != tools.ID || redirect {
+				t.Errorf("%q: got category %d, redirect %v", ref, found.ID, redirect)
+			}
+		}
+		if found, _, err := store.ResolveCategory(ctx, "Garden"); err != nil || found.ID != garden.ID {
+			t.Errorf("Garden: got %+v, %v", found, err)
+		}
+
+		// A name differing from the slug resolves too, after any slug or
+		// alias it collides with.
+		power := &Category{Name: "Power Tools", Slug: "power"}
+		manual := &Category{Name: "Hand Tools", Slug: "manual"}
+		for _, category := range []*Category{power, manual} {
+			if err := store.AddCategory(ctx, category, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for ref, want := range map[string]uint{"Power Tools": power.ID, "power-tools": power.ID, "Hand Tools": tools.ID} {
+			found, redirect, err := store.ResolveCategory(ctx, ref)
+			if err != nil || found.ID != want || redirect {
+				t.Errorf("%q: got %+v, redirect %v, %v", ref, found, redirect, err)
+			}
+		}
+		for _, ref := range []string{"", "!!", "kitchen"} {
+			_, _, err := store.ResolveCategory(ctx, ref)
+			assertErr(t, err, gorm.ErrRecordNotFound)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		store, _ := newStore(t)
+		tools, garden := seedCategories(t, store)
+
+		renamed, err := store.GetCategoryById(ctx, tools.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renamed.Name, renamed.Slug = "Power tools", "power-tools"
+		renamed.Translations = []CategoryTranslation{{Locale: "fa", Name: "ابزار"}}
+		if err := store.UpdateCategory(ctx, renamed, "tools", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		found, redirect, err := store.ResolveCategory(ctx, "tools")
+		if err != nil || found.ID != tools.ID || !redirect {
+			t.Fatalf("old slug: got %+v, redirect %v, %v", found, redirect, err)
+		}
+		if found.Slug != "power-tools" || len(found.Translations) != 1 || found.Translations[0].Locale != "fa" {
+			t.Errorf("got %+v", found)
+		}
+		if slugs := aliasSlugs(found); !equalStrings(slugs, []string{"hand-tools", "tools"}) {
+			t.Errorf("got aliases %v", slugs)
+		}
+
+		// Going back to the old slug drops its redirect.
+		found.Slug = "tools"
+		if err := store.UpdateCategory(ctx, found, "power-tools", nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, redirect, err := store.ResolveCategory(ctx, "tools"); err != nil || redirect {
+			t.Errorf("restored slug: redirect %v, %v", redirect, err)
+		}
+		if _, redirect, err := store.ResolveCategory(ctx, "power-tools"); err != nil || !redirect {
+			t.Errorf("previous slug: redirect %v, %v", redirect, err)
+		}
+
+		conflict, err := store.GetCategoryById(ctx, garden.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conflict.Slug = "hand-tools"
+		assertErr(t, store.UpdateCategory(ctx, conflict, "garden", nil), ErrSlugTaken)
+		conflict.Slug, conflict.Name = "garden", "Power tools"
+		assertErr(t, store.UpdateCategory(ctx, conflict, "garden", nil), ErrCategoryExists)
+	})
+
+	t.Run("aliases", func(t *testing.T) {
+		store, _ := newStore(t)
+		tools, garden := seedCategories(t, store)
+
+		alias := CategoryAlias{CategoryID: garden.ID, Alias: "Yard", Slug: "yard"}
+		if err := store.AddAlias(ctx, &alias, nil); err != nil {
+			t.Fatal(err)
+		}
+		if found, _, err := store.ResolveCategory(ctx, "yard"); err != nil || found.ID != garden.ID {
+			t.Errorf("got %+v, %v", found, err)
+		}
+
+		assertErr(t, store.AddAlias(ctx, &CategoryAlias{CategoryID: tools.ID, Alias: "Yard", Slug: "yard"}, nil), ErrSlugTaken)
+		assertErr(t, store.AddAlias(ctx, &CategoryAlias{CategoryID: tools.ID, Alias: "Garden", Slug: "garden"}, nil), ErrSlugTaken)
+		assertErr(t, store.AddAlias(ctx, &CategoryAlias{CategoryID: garden.ID + 100, Alias: "Lawn", Slug: "lawn"}, nil), ErrCategoryNotFound)
+
+		assertErr(t, store.RemoveAlias(ctx, tools.ID, "yard", nil), ErrAliasNotFound)
+		if err := store.RemoveAlias(ctx, garden.ID, "yard", nil); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err := store.ResolveCategory(ctx, "yard")
+		assertErr(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("count posts", func(t *testing.T) {
+		store, posts := newStore(t)
+		tools, garden := seedCategories(t, store)
+		posts.add(t, tools.ID, true)
+		posts.add(t, tools.ID, true)
+		posts.add(t, tools.ID, false)
+		posts.add(t, garden.ID, false)
+
+		counts, err := store.CountPosts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != 1 || counts[tools.ID] != 2 {
+			t.Errorf("got %v", counts)
+		}
+		counts, err = store.CountPosts(ctx, garden.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != 0 {
+			t.Errorf("got %v", counts)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		store, posts := newStore(t)
+		tools, garden := seedCategories(t, store)
+		posts.add(t, tools.ID, true)
+		posts.add(t, tools.ID, false)
+
+		_, err := store.DeleteCategory(ctx, tools.ID, nil, nil)
+		assertErr(t, err, ErrCategoryInUse)
+		_, err = store.DeleteCategory(ctx, tools.ID+100, nil, nil)
+		assertErr(t, err, ErrCategoryNotFound)
+		_, err = store.DeleteCategory(ctx, tools.ID, &tools.ID, nil)
+		assertErr(t, err, ErrInvalidTargetCategory)
+		missing := garden.ID + 100
+		_, err = store.DeleteCategory(ctx, tools.ID, &missing, nil)
+		assertErr(t, err, ErrInvalidTargetCategory)
+
+		moved, err := store.DeleteCategory(ctx, tools.ID, &garden.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved != 2 {
+			t.Errorf("moved %d posts, want 2", moved)
+		}
+		_, err = store.GetCategoryById(ctx, tools.ID)
+		assertErr(t, err, gorm.ErrRecordNotFound)
+		if counts, err := store.CountPosts(ctx, garden.ID); err != nil || counts[garden.ID] != 1 {
+			t.Errorf("got %v, %v", counts, err)
+		}
+
+		empty := &Category{Name: "Empty", Slug: "empty"}
+		if err := store.AddCategory(ctx, empty, nil); err != nil {
+			t.Fatal(err)
+		}
+		if moved, err := store.DeleteCategory(ctx, empty.ID, nil, nil); err != nil || moved != 0 {
+			t.Errorf("got %d, %v", moved, err)
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		store, posts := newStore(t)
+		tools, garden := seedCategories(t, store)
+		posts.add(t, tools.ID, true)
+		posts.add(t, garden.ID, true)
+
+		_, err := store.MergeCategories(ctx, tools.ID, tools.ID, nil)
+		assertErr(t, err, ErrInvalidTargetCategory)
+		_, err = store.MergeCategories(ctx, tools.ID+100, garden.ID, nil)
+		assertErr(t, err, ErrCategoryNotFound)
+		_, err = store.MergeCategories(ctx, tools.ID, garden.ID+100, nil)
+		assertErr(t, err, ErrInvalidTargetCategory)
+
+		moved, err := store.MergeCategories(ctx, tools.ID, garden.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved != 1 {
+			t.Errorf("moved %d posts, want 1", moved)
+		}
+		merged, err := store.GetCategoryById(ctx, garden.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slugs := aliasSlugs(merged); !equalStrings(slugs, []string{"hand-tools", "tools"}) {
+			t.Errorf("got aliases %v", slugs)
+		}
+		found, redirect, err := store.ResolveCategory(ctx, "tools")
+		if err != nil || found.ID != garden.ID || !redirect {
+			t.Errorf("merged slug: got %+v, redirect %v, %v", found, redirect, err)
+		}
+		if counts, err := store.CountPosts(ctx); err != nil || len(counts) != 1 || counts[garden.ID] != 2 {
+			t.Errorf("got %v, %v", counts, err)
+		}
+	})
+
+	t.Run("failing hook", func(t *testing.T) {
+		store, posts := newStore(t)
+		tools, garden := seedCategories(t, store)
+		posts.add(t, tools.ID, true)
+		errHook := errors.New("hook failed")
+		fail := func(context.Context) error { return errHook }
+
+		assertErr(t, store.AddCategory(ctx, &Category{Name: "Bikes", Slug: "bikes"}, fail), errHook)
+		renamed, err := store.GetCategoryById(ctx, tools.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		renamed.Name, renamed.Slug = "Power tools", "power-tools"
+		assertErr(t, store.UpdateCategory(ctx, renamed, "tools", fail), errHook)
+		assertErr(t, store.AddAlias(ctx, &CategoryAlias{CategoryID: garden.ID, Alias: "Yard", Slug: "yard"}, fail), errHook)
+		assertErr(t, store.RemoveAlias(ctx, tools.ID, "hand-tools", fail), errHook)
+		_, err = store.DeleteCategory(ctx, tools.ID, &garden.ID, fail)
+		assertErr(t, err, errHook)
+		_, err = store.MergeCategories(ctx, tools.ID, garden.ID, fail)
+		assertErr(t, err, errHook)
+
+		for _, ref := range []string{"bikes", "power-tools", "yard"} {
+			_, _, err := store.ResolveCategory(ctx, ref)
+			assertErr(t, err, gorm.ErrRecordNotFound)
+		}
+		found, redirect, err := store.ResolveCategory(ctx, "tools")
+		if err != nil || found.ID != tools.ID || redirect || found.Name != "Tools" {
+			t.Fatalf("got %+v, redirect %v, %v", found, redirect, err)
+		}
+		if slugs := aliasSlugs(found); !equalStrings(slugs, []string{"hand-tools"}) {
+			t.Errorf("got aliases %v", slugs)
+		}
+		if counts, err := store.CountPosts(ctx); err != nil || counts[tools.ID] != 1 {
+			t.Errorf("got %v, %v", counts, err)
+		}
+	})
+}
+
+func TestResolveCachesMisses(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	repo := NewCategoryRepository(db, &database.QueryTimeouts{Default: time.Second}, NewCategoryCache())
+	var queries int
+	err := db.Callback().Query().After("gorm:query").Register("count_queries", func(*gorm.DB) { queries++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := repo.ResolveCategory(ctx, "Power tools"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("lookup %d: got %v", i, err)
+		}
+	}
+	if queries != 3 {
+		t.Errorf("three lookups of a missing category ran %d queries, want 3", queries)
+	}
+
+	// A new category is found right away.
+	if err := repo.AddCategory(ctx, &Category{Name: "Power Tools", Slug: "power-tools", IsActive: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if category, _, err := repo.ResolveCategory(ctx, "Power tools"); err != nil || category.Slug != "power-tools" {
+		t.Errorf("got %+v, %v", category, err)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
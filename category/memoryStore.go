@@ -0,0 +1,426 @@
+package category
+
+import (
+	"context"
+	"post-service/database"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PostIndex is what MemoryCategoryStore needs of the posts filed under its
+// categories, which the database keeps in a table CategoryRepository queries
+// directly.
+type PostIndex interface {
+	// CountPostsByCategory returns the number of posts per category id,
+	// counting only active posts when activeOnly is set.
+	CountPostsByCategory(activeOnly bool) map[uint]int64
+	// MovePosts files the posts of one category under another and returns
+	// how many it moved.
+	MovePosts(sourceId, targetId uint) int64
+}
+
+// MemoryCategoryStore keeps categories in this process, with the same
+// behaviour as CategoryRepository, for tests. Posts are counted and moved
+// through the PostIndex given to UsePosts; without one there are none. Hooks
+// run with the lock held and no transaction, before the change is kept.
+type MemoryCategoryStore struct {
+	mu                sync.Mutex
+	categories        map[uint]*Category
+	posts             PostIndex
+	lastCategoryId    uint
+	lastAliasId       uint
+	lastTranslationId uint
+}
+
+func NewMemoryCategoryStore() *MemoryCategoryStore {
+	return &MemoryCategoryStore{categories: map[uint]*Category{}}
+}
+
+// UsePosts sets the posts filed under the store's categories.
+func (store *MemoryCategoryStore) UsePosts(posts PostIndex) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.posts = posts
+}
+
+func (store *MemoryCategoryStore) AddCategory(ctx context.Context, category *Category, hook database.Hook) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	slugs := []string{category.Slug}
+	for _, alias := range category.Aliases {
+		slugs = append(slugs, alias.Slug)
+	}
+	if err := store.ensureSlugsFree(0, slugs...); err != nil {
+		return err
+	}
+	if store.nameTaken(0, category.Name) || hasDuplicates(slugs) {
+		return ErrCategoryExists
+	}
+
+	now := time.Now()
+	store.lastCategoryId++
+	category.ID = store.lastCategoryId
+	if category.CreatedAt.IsZero() {
+		category.CreatedAt = now
+	}
+	if category.UpdatedAt.IsZero() {
+		category.UpdatedAt = now
+	}
+	for i := range category.Aliases {
+		store.lastAliasId++
+		category.Aliases[i].ID = store.lastAliasId
+		category.Aliases[i].CategoryID = category.ID
+		if category.Aliases[i].CreatedAt.IsZero() {
+			category.Aliases[i].CreatedAt = now
+		}
+	}
+	store.setTranslations(category)
+	if err := database.RunHook(ctx, nil, hook); err != nil {
+		return err
+	}
+
+	stored := cloneCategory(*category)
+	store.categories[category.ID] = &stored
+	return nil
+}
+
+func (store *MemoryCategoryStore) GetAllCategories(ctx context.Context) ([]Category, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	categories := make([]Category, 0, len(store.categories))
+	for _, category := range store.categories {
+		listed := cloneCategory(*category)
+		listed.Aliases = nil
+		categories = append(categories, listed)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].DisplayOrder != categories[j].DisplayOrder {
+			return categories[i].DisplayOrder < categories[j].DisplayOrder
+		}
+		return categories[i].Name < categories[j].Name
+	})
+	return categories, nil
+}
+
+func (store *MemoryCategoryStore) GetCategoryById(ctx context.Context, categoryId uint) (*Category, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	category, ok := store.categories[categoryId]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	found := cloneCategory(*category)
+	return &found, nil
+}
+
+func (store *MemoryCategoryStore) ResolveCategory(ctx context.Context, ref string) (*Category, bool, error) {
+	slug := Slugify(ref)
+	if slug == "" {
+		return nil, false, gorm.ErrRecordNotFound
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, category := range store.categories {
+		if category.Slug == slug {
+			found := cloneCategory(*category)
+			return &found, false, nil
+		}
+	}
+	for _, category := range store.categories {
+		for _, alias := range category.Aliases {
+			if alias.Slug == slug {
+				found := cloneCategory(*category)
+				return &found, alias.IsRedirect, nil
+			}
+		}
+	}
+	for _, category := range store.categories {
+		if Slugify(category.Name) == slug {
+			found := cloneCategory(*category)
+			return &found, false, nil
+		}
+	}
+	return nil, false, gorm.ErrRecordNotFound
+}
+
+func (store *MemoryCategoryStore) UpdateCategory(ctx context.Context, updatedCategory *Category, previousSlug string, hook database.Hook) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := store.ensureSlugsFree(updatedCategory.ID, updatedCategory.Slug); err != nil {
+		return err
+	}
+	if store.nameTaken(updatedCategory.ID, updatedCategory.Name) {
+		return ErrCategoryExists
+	}
+
+	var aliases []CategoryAlias
+	if existing, ok := store.categories[updatedCategory.ID]; ok {
+		aliases = append(aliases, existing.Aliases...)
+	}
+	// The new slug may be one this category used before.
+	kept := aliases[:0]
+	for _, alias := range aliases {
+		if alias.Slug != updatedCategory.Slug {
+			kept = append(kept, alias)
+		}
+	}
+	aliases = kept
+	if previousSlug != "" && previousSlug != updatedCategory.Slug {
+		if store.aliasTaken(updatedCategory.ID, previousSlug) {
+			return ErrCategoryExists
+		}
+		store.lastAliasId++
+		aliases = append(aliases, CategoryAlias{
+			ID:         store.lastAliasId,
+			CategoryID: updatedCategory.ID,
+			Alias:      previousSlug,
+			Slug:       previousSlug,
+			IsRedirect: true,
+			CreatedAt:  time.Now(),
+		})
+	}
+	store.setTranslations(updatedCategory)
+	updatedCategory.UpdatedAt = time.Now()
+	if err := database.RunHook(ctx, nil, hook); err != nil {
+		return err
+	}
+
+	stored := cloneCategory(*updatedCategory)
+	stored.Aliases = aliases
+	store.categories[updatedCategory.ID] = &stored
+	return nil
+}
+
+func (store *MemoryCategoryStore) AddAlias(ctx context.Context, alias *CategoryAlias, hook database.Hook) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	category, ok := store.categories[alias.CategoryID]
+	if !ok {
+		return ErrCategoryNotFound
+	}
+	if err := store.ensureSlugsFree(0, alias.Slug); err != nil {
+		return err
+	}
+
+	store.lastAliasId++
+	alias.ID = store.lastAliasId
+	if alias.CreatedAt.IsZero() {
+		alias.CreatedAt = time.Now()
+	}
+	if err := database.RunHook(ctx, nil, hook); err != nil {
+		return err
+	}
+	category.Aliases = append(category.Aliases, *alias)
+	return nil
+}
+
+func (store *MemoryCategoryStore) RemoveAlias(ctx context.Context, categoryId uint, slug string, hook database.Hook) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	category, ok := store.categories[categoryId]
+	if !ok {
+		return ErrAliasNotFound
+	}
+	for i, alias := range category.Aliases {
+		if alias.Slug == slug {
+			if err := database.RunHook(ctx, nil, hook); err != nil {
+				return err
+			}
+			category.Aliases = append(category.Aliases[:i:i], category.Aliases[i+1:]...)
+			return nil
+		}
+	}
+	return ErrAliasNotFound
+}
+
+func (store *MemoryCategoryStore) CountPosts(ctx context.Context, categoryIds ...uint) (map[uint]int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	counts := store.countPosts(true)
+	if len(categoryIds) == 0 {
+		return counts, nil
+	}
+	selected := make(map[uint]int64, len(categoryIds))
+	for _, categoryId := range categoryIds {
+		if count, ok := counts[categoryId]; ok {
+			selected[categoryId] = count
+		}
+	}
+	return selected, nil
+}
+
+func (store *MemoryCategoryStore) DeleteCategory(ctx context.Context, categoryId uint, reassignTo *uint, hook database.Hook) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if reassignTo == nil {
+		if _, ok := store.categories[categoryId]; !ok {
+			return 0, ErrCategoryNotFound
+		}
+		if store.countPosts(false)[categoryId] > 0 {
+			return 0, ErrCategoryInUse
+		}
+		if err := database.RunHook(ctx, nil, hook); err != nil {
+			return 0, err
+		}
+		delete(store.categories, categoryId)
+		return 0, nil
+	}
+
+	if err := store.checkMove(categoryId, *reassignTo); err != nil {
+		return 0, err
+	}
+	if err := database.RunHook(ctx, nil, hook); err != nil {
+		return 0, err
+	}
+	return store.moveAndDelete(categoryId, *reassignTo), nil
+}
+
+func (store *MemoryCategoryStore) MergeCategories(ctx context.Context, sourceId, targetId uint, hook database.Hook) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := store.checkMove(sourceId, targetId); err != nil {
+		return 0, err
+	}
+	if err := database.RunHook(ctx, nil, hook); err != nil {
+		return 0, err
+	}
+	source, target := store.categories[sourceId], store.categories[targetId]
+	for _, alias := range source.Aliases {
+		alias.CategoryID = targetId
+		target.Aliases = append(target.Aliases, alias)
+	}
+	store.lastAliasId++
+	target.Aliases = append(target.Aliases, CategoryAlias{
+		ID:         store.lastAliasId,
+		CategoryID: targetId,
+		Alias:      source.Name,
+		Slug:       source.Slug,
+		IsRedirect: true,
+		CreatedAt:  time.Now(),
+	})
+	return store.moveAndDelete(sourceId, targetId), nil
+}
+
+// checkMove fails like lockCategories and moveAndDelete do when the posts of
+// sourceId cannot be moved to targetId.
+func (store *MemoryCategoryStore) checkMove(sourceId, targetId uint) error {
+	if _, ok := store.categories[sourceId]; !ok {
+		return ErrCategoryNotFound
+	}
+	if _, ok := store.categories[targetId]; !ok || sourceId == targetId {
+		return ErrInvalidTargetCategory
+	}
+	return nil
+}
+
+func (store *MemoryCategoryStore) moveAndDelete(sourceId, targetId uint) int64 {
+	var moved int64
+	if store.posts != nil {
+		moved = store.posts.MovePosts(sourceId, targetId)
+	}
+	delete(store.categories, sourceId)
+	return moved
+}
+
+func (store *MemoryCategoryStore) countPosts(activeOnly bool) map[uint]int64 {
+	if store.posts == nil {
+		return map[uint]int64{}
+	}
+	return store.posts.CountPostsByCategory(activeOnly)
+}
+
+// ensureSlugsFree fails with ErrSlugTaken when any of the slugs is already
+// used by a category other than exceptId, or by one of its aliases.
+func (store *MemoryCategoryStore) ensureSlugsFree(exceptId uint, slugs ...string) error {
+	for _, slug := range slugs {
+		if store.slugTaken(exceptId, slug) {
+			return ErrSlugTaken
+		}
+	}
+	return nil
+}
+
+func (store *MemoryCategoryStore) slugTaken(exceptId uint, slug string) bool {
+	for _, category := range store.categories {
+		if category.ID == exceptId {
+			continue
+		}
+		if category.Slug == slug {
+			return true
+		}
+		for _, alias := range category.Aliases {
+			if alias.Slug == slug {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// aliasTaken reports whether a category other than exceptId has an alias
+// with the slug.
+func (store *MemoryCategoryStore) aliasTaken(exceptId uint, slug string) bool {
+	for _, category := range store.categories {
+		if category.ID == exceptId {
+			continue
+		}
+		for _, alias := range category.Aliases {
+			if alias.Slug == slug {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (store *MemoryCategoryStore) nameTaken(exceptId uint, name string) bool {
+	for _, category := range store.categories {
+		if category.ID != exceptId && category.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setTranslations gives the category's translations new ids, as recreating
+// them in the database does.
+func (store *MemoryCategoryStore) setTranslations(category *Category) {
+	for i := range category.Translations {
+		store.lastTranslationId++
+		category.Translations[i].ID = store.lastTranslationId
+		category.Translations[i].CategoryID = category.ID
+	}
+}
+
+func hasDuplicates(slugs []string) bool {
+	seen := make(map[string]bool, len(slugs))
+	for _, slug := range slugs {
+		if seen[slug] {
+			return true
+		}
+		seen[slug] = true
+	}
+	return false
+}
+
+// cloneCategory copies a category with its aliases and translations, so that
+// callers cannot change the stored one.
+func cloneCategory(category Category) Category {
+	category.Aliases = append([]CategoryAlias(nil), category.Aliases...)
+	category.Translations = append([]CategoryTranslation(nil), category.Translations...)
+	return category
+}
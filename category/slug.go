@@ -0,0 +1,35 @@
+package category
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Slugify turns a category name, slug or alias into its lookup key: accents
+// are stripped, letters lowercased and every run of other characters becomes
+// a single dash. "Caméras & Lenses" and "cameras-lenses" share the same key.
+func Slugify(value string) string {
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), value)
+	if err != nil {
+		folded = value
+	}
+
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(folded) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
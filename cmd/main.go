@@ -1,88 +1,251 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"post-service/apperr"
+	"post-service/audit"
 	"post-service/auth"
 	"post-service/category"
+	"post-service/conversation"
+	"post-service/database"
+	"post-service/health"
+	"post-service/idempotency"
+	"post-service/logging"
+	"post-service/moderation"
+	"post-service/money"
+	"post-service/notification"
 	"post-service/post"
+	"post-service/ratelimit"
+	"post-service/telemetry"
+	"post-service/validation"
+	"strings"
 
-	"github.com/go-playground/validator/v10"
+	ut "github.com/go-playground/universal-translator"
 	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/fx"
+	"go.uber.org/fx/fxevent"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/plugin/opentelemetry/tracing"
 )
 
-func NewDB() (*gorm.DB, error) {
+const serverAddr = ":8081"
+
+// NewIPExtractor reads TRUSTED_PROXIES, a comma separated list of the CIDR
+// ranges of the proxies in front of the service, such as "10.0.0.0/8". Client
+// IPs key the rate limits and the audit log, so X-Forwarded-For is only
+// trusted from those ranges; without any, the peer's address is used.
+func NewIPExtractor() (echo.IPExtractor, error) {
+	value := os.Getenv("TRUSTED_PROXIES")
+	if value == "" {
+		return echo.ExtractIPDirect(), nil
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, entry := range strings.Split(value, ",") {
+		_, ipRange, err := net.ParseCIDR(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", entry, err)
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+func NewDB(lc fx.Lifecycle, tp trace.TracerProvider) (*gorm.DB, error) {
 	dsn := "host=localhost user=admin password=sahar223010 dbname=rental_service_db search_path=post-service port=5432 sslmode=disable"
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
+	if err := db.Use(tracing.NewPlugin(tracing.WithTracerProvider(tp), tracing.WithoutMetrics())); err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return sqlDB.Close()
+		},
+	})
 	return db, nil
 }
 
-// func NewLogger() (*zap.Logger, error) {
-// 	logger, err := zap.NewProduction()
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	return logger, nil
-// }
+func RegisterRoutes(e *echo.Echo, tp trace.TracerProvider, uni *ut.UniversalTranslator, ipExtractor echo.IPExtractor, healthHandler *health.HealthHandler, postHandler *post.PostHandler, importHandler *post.ImportHandler, exportHandler *post.ExportHandler, eventHandler *post.EventHandler, categoryHandler *category.CategoryHandler, conversationHandler *conversation.ConversationHandler, moderationHandler *moderation.ModerationHandler, auditHandler *audit.AuditHandler, accessLog *logging.AccessLog, limiter *ratelimit.Limiter, idempotent *idempotency.Middleware) {
+	e.HTTPErrorHandler = apperr.NewHTTPErrorHandler(uni)
+	e.IPExtractor = ipExtractor
+	e.Use(otelecho.Middleware(telemetry.ServiceName,
+		otelecho.WithTracerProvider(tp),
+		otelecho.WithSkipper(func(c echo.Context) bool {
+			return c.Path() == "/healthz" || c.Path() == "/readyz"
+		}),
+	))
+	e.Use(accessLog.Middleware, audit.Middleware)
 
-func NewValidator() *validator.Validate {
-	return validator.New()
-}
+	e.GET("/healthz", healthHandler.Liveness)
+	e.GET("/readyz", healthHandler.Readiness)
+
+	limit := limiter.Middleware
 
-func RegisterRoutes(e *echo.Echo, postHandler *post.PostHandler, categoryHandler *category.CategoryHandler) {
-	e.GET("/posts", postHandler.GetAllPosts)
-	e.GET("/posts/category/:category", postHandler.GetAllPosts)
-	e.GET("/posts/:postId", postHandler.GetPostByID)
+	e.GET("/posts", postHandler.GetAllPosts, limit)
+	e.GET("/posts/category/:category", postHandler.GetAllPosts, limit)
+	e.GET("/posts/events", eventHandler.StreamFeed, limit)
+	e.GET("/posts/:postId", postHandler.GetPostByID, limit)
+	e.GET("/posts/:postId/pricing-rules", postHandler.GetPricingRules, limit)
+	e.GET("/posts/:postId/price-calendar", postHandler.GetPriceCalendar, limit)
+	e.GET("/posts/:postId/events", eventHandler.StreamPost, limit)
 
-	e.GET("/categories", categoryHandler.GetAllCategories)
-	e.GET("/categories/:categoryId", categoryHandler.GetCategoryById)
+	e.GET("/categories", categoryHandler.GetAllCategories, limit)
+	e.GET("/categories/:categoryId", categoryHandler.GetCategoryById, limit)
 
-	e.GET("/my-posts", postHandler.GetPostsByOwnerId, auth.AuthMiddleware)
+	e.GET("/my-posts", postHandler.GetPostsByOwnerId, auth.AuthMiddleware, limit)
+	e.GET("/my-posts/export", exportHandler.ExportMyPosts, auth.AuthMiddleware, limit)
 
 	postGroup := e.Group("/posts")
-	postGroup.Use(auth.AuthMiddleware)
-	postGroup.POST("", postHandler.CreatePost)
+	postGroup.Use(auth.AuthMiddleware, limit)
+	postGroup.POST("", postHandler.CreatePost, idempotent.Handle)
+	postGroup.POST("/bulk", postHandler.BulkUpdatePosts, idempotent.Handle)
 	postGroup.PUT("/:postId", postHandler.UpdatePost)
 	postGroup.DELETE("/:postId", postHandler.DeletePost)
+	postGroup.POST("/:postId/renew", postHandler.RenewPost)
+	postGroup.PUT("/:postId/pricing-rules", postHandler.SetPricingRules)
+	postGroup.POST("/:postId/conversations", conversationHandler.StartConversation)
+	postGroup.POST("/imports", importHandler.CreateImport, idempotent.Handle)
+	postGroup.GET("/imports/:jobId", importHandler.GetImport)
+	postGroup.GET("/imports/:jobId/report", importHandler.GetImportReport)
 
 	categoryGroup := e.Group("/categories")
-	categoryGroup.Use(auth.AuthMiddleware)
+	categoryGroup.Use(auth.AuthMiddleware, limit)
 	categoryGroup.POST("", categoryHandler.CreateCategory)
 	categoryGroup.PUT("/:categoryId", categoryHandler.UpdateCategory)
+	categoryGroup.DELETE("/:categoryId", categoryHandler.DeleteCategory, auth.AdminMiddleware)
+	categoryGroup.POST("/:categoryId/merge", categoryHandler.MergeCategory, auth.AdminMiddleware)
+	categoryGroup.POST("/:categoryId/aliases", categoryHandler.AddAlias)
+	categoryGroup.DELETE("/:categoryId/aliases/:alias", categoryHandler.RemoveAlias)
+
+	conversationGroup := e.Group("/conversations")
+	conversationGroup.Use(auth.AuthMiddleware, limit)
+	conversationGroup.GET("", conversationHandler.ListConversations)
+	conversationGroup.GET("/unread", conversationHandler.CountUnread)
+	conversationGroup.GET("/:conversationId", conversationHandler.GetConversation)
+	conversationGroup.GET("/:conversationId/messages", conversationHandler.GetMessages)
+	conversationGroup.POST("/:conversationId/messages", conversationHandler.SendMessage)
+	conversationGroup.POST("/:conversationId/read", conversationHandler.MarkRead)
+	conversationGroup.POST("/:conversationId/accept", conversationHandler.Accept)
+	conversationGroup.POST("/:conversationId/decline", conversationHandler.Decline)
+	conversationGroup.POST("/:conversationId/block", conversationHandler.Block)
+	conversationGroup.DELETE("/:conversationId/block", conversationHandler.Unblock)
+
+	e.GET("/me/contact", conversationHandler.GetContactCard, auth.AuthMiddleware, limit)
+	e.PUT("/me/contact", conversationHandler.SaveContactCard, auth.AuthMiddleware, limit)
 
+	adminGroup := e.Group("/admin")
+	adminGroup.Use(auth.AuthMiddleware, auth.AdminMiddleware, limit)
+	adminGroup.GET("/posts/export", exportHandler.ExportAllPosts)
+	adminGroup.GET("/posts/duplicates", postHandler.GetDuplicateClusters)
+	adminGroup.GET("/moderation/flags", moderationHandler.ListFlags)
+	adminGroup.GET("/audit", auditHandler.ListEntries)
+	adminGroup.GET("/audit/verify", auditHandler.VerifyChain)
+}
+
+// StartServer binds the listener during fx startup, so a busy port fails the
+// app, and drains in-flight requests when fx stops on SIGINT/SIGTERM.
+func StartServer(lc fx.Lifecycle, e *echo.Echo, healthHandler *health.HealthHandler, broker *post.PostBroker, logger *zap.Logger) {
+	// Event streams never finish on their own; ending them when shutdown
+	// begins lets it drain the remaining requests.
+	e.Server.RegisterOnShutdown(broker.Close)
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			listener, err := net.Listen("tcp", serverAddr)
+			if err != nil {
+				return err
+			}
+			e.Listener = listener
+			go func() {
+				if err := e.Start(serverAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					logger.Fatal("Echo server failed to start", zap.Error(err))
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			healthHandler.MarkShuttingDown()
+			return e.Shutdown(ctx)
+		},
+	})
 }
 
 func main() {
 	e := echo.New()
 
 	app := fx.New(
+		fx.WithLogger(func(logger *zap.Logger) fxevent.Logger {
+			fxLogger := &fxevent.ZapLogger{Logger: logger}
+			fxLogger.UseLogLevel(zapcore.DebugLevel)
+			return fxLogger
+		}),
 		fx.Provide(
+			telemetry.NewTracerProvider,
 			NewDB,
-			//NewLogger,
-			NewValidator,
-			category.NewCategoryRepository,
+			database.NewQueryTimeouts,
+			logging.NewLogger,
+			logging.NewAccessLog,
+			validation.NewValidator,
+			money.NewExchange,
+			audit.NewEntryRepository,
+			audit.NewAuditService,
+			audit.NewAuditHandler,
+			category.NewCategoryCache,
+			fx.Annotate(category.NewCategoryRepository, fx.As(new(category.CategoryStore))),
 			category.NewCategoryService,
 			category.NewCategoryHandler,
-			post.NewPostRepository,
+			notification.NewLogNotifier,
+			ratelimit.NewConfig,
+			ratelimit.NewStore,
+			ratelimit.NewLimiter,
+			idempotency.NewKeyRepository,
+			idempotency.NewMiddleware,
+			moderation.NewContentPolicy,
+			moderation.NewFlagRepository,
+			moderation.NewModerationService,
+			moderation.NewModerationHandler,
+			post.NewScheduleConfig,
+			post.NewPostCap,
+			post.NewPostBroker,
+			fx.Annotate(post.NewPostRepository, fx.As(fx.Self()), fx.As(new(post.PostStore))),
 			post.NewPostService,
 			post.NewPostHandler,
+			post.NewImportRepository,
+			post.NewImportService,
+			post.NewImportHandler,
+			post.NewExportService,
+			post.NewExportHandler,
+			post.NewEventHandler,
+			post.NewScheduler,
+			conversation.NewConversationRepository,
+			conversation.NewConversationService,
+			conversation.NewConversationHandler,
+			health.NewHealthHandler,
+			NewIPExtractor,
 			func() *echo.Echo { return e },
 		),
 		fx.Invoke(
-			func(e *echo.Echo, postHandler *post.PostHandler, categoryHandler *category.CategoryHandler) {
-				RegisterRoutes(e, postHandler, categoryHandler)
-			},
-			func() {
-				if err := e.Start(":8081"); err != nil {
-					log.Fatal("Echo server failed to start", zap.Error(err))
-				}
+			func(e *echo.Echo, tp trace.TracerProvider, uni *ut.UniversalTranslator, ipExtractor echo.IPExtractor, healthHandler *health.HealthHandler, postHandler *post.PostHandler, importHandler *post.ImportHandler, exportHandler *post.ExportHandler, eventHandler *post.EventHandler, categoryHandler *category.CategoryHandler, conversationHandler *conversation.ConversationHandler, moderationHandler *moderation.ModerationHandler, auditHandler *audit.AuditHandler, accessLog *logging.AccessLog, limiter *ratelimit.Limiter, idempotent *idempotency.Middleware) {
+				RegisterRoutes(e, tp, uni, ipExtractor, healthHandler, postHandler, importHandler, exportHandler, eventHandler, categoryHandler, conversationHandler, moderationHandler, auditHandler, accessLog, limiter, idempotent)
 			},
+			StartServer,
+			func(*post.Scheduler) {},
 		),
 	)
 	app.Run()
@@ -0,0 +1,224 @@
+package conversation
+
+import (
+	"net/http"
+	"post-service/apperr"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+type ConversationHandler struct {
+	service  *ConversationService
+	validate *validator.Validate
+}
+
+func NewConversationHandler(service *ConversationService, validate *validator.Validate) *ConversationHandler {
+	return &ConversationHandler{service: service, validate: validate}
+}
+
+type MessageDto struct {
+	Body string `json:"body" validate:"required,max=2000,nohtml"`
+}
+
+type ReadDto struct {
+	// UpToId marks messages up to this id as read; zero marks them all.
+	UpToId uint `json:"upToId"`
+}
+
+type ContactDto struct {
+	Email string `json:"email" validate:"omitempty,email,max=255"`
+	Phone string `json:"phone" validate:"omitempty,e164"`
+}
+
+func (handler *ConversationHandler) StartConversation(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	var dto MessageDto
+	if err := handler.bind(c, &dto); err != nil {
+		return err
+	}
+
+	conversation, err := handler.service.StartConversation(c.Request().Context(), userId, c.Param("postId"), dto.Body)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, conversation)
+}
+
+func (handler *ConversationHandler) ListConversations(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	conversations, err := handler.service.ListConversations(c.Request().Context(), userId, c.QueryParam("page"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, conversations)
+}
+
+func (handler *ConversationHandler) CountUnread(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	unread, err := handler.service.CountUnread(c.Request().Context(), userId)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]int64{"unread": unread})
+}
+
+func (handler *ConversationHandler) GetConversation(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	conversation, err := handler.service.GetConversation(c.Request().Context(), userId, c.Param("conversationId"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, conversation)
+}
+
+func (handler *ConversationHandler) GetMessages(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	page, err := handler.service.GetMessages(c.Request().Context(), userId, c.Param("conversationId"), c.QueryParam("before"), c.QueryParam("limit"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+func (handler *ConversationHandler) SendMessage(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	var dto MessageDto
+	if err := handler.bind(c, &dto); err != nil {
+		return err
+	}
+
+	message, err := handler.service.SendMessage(c.Request().Context(), userId, c.Param("conversationId"), dto.Body)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, message)
+}
+
+func (handler *ConversationHandler) MarkRead(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	var dto ReadDto
+	if err := handler.bind(c, &dto); err != nil {
+		return err
+	}
+
+	unread, err := handler.service.MarkRead(c.Request().Context(), userId, c.Param("conversationId"), dto.UpToId)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]int64{"unread": unread})
+}
+
+func (handler *ConversationHandler) Accept(c echo.Context) error {
+	return handler.respond(c, StatusAccepted)
+}
+
+func (handler *ConversationHandler) Decline(c echo.Context) error {
+	return handler.respond(c, StatusDeclined)
+}
+
+func (handler *ConversationHandler) respond(c echo.Context, status string) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	conversation, err := handler.service.Respond(c.Request().Context(), userId, c.Param("conversationId"), status)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, conversation)
+}
+
+func (handler *ConversationHandler) Block(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	if err := handler.service.Block(c.Request().Context(), userId, c.Param("conversationId")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (handler *ConversationHandler) Unblock(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	if err := handler.service.Unblock(c.Request().Context(), userId, c.Param("conversationId")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (handler *ConversationHandler) SaveContactCard(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	var dto ContactDto
+	if err := handler.bind(c, &dto); err != nil {
+		return err
+	}
+
+	card, err := handler.service.SaveContactCard(c.Request().Context(), userId, dto)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, card)
+}
+
+func (handler *ConversationHandler) GetContactCard(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	card, err := handler.service.GetContactCard(c.Request().Context(), userId)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, card)
+}
+
+func (handler *ConversationHandler) bind(c echo.Context, dto interface{}) error {
+	if err := c.Bind(dto); err != nil {
+		return apperr.BadRequest("invalid_request", "failed to parse request body")
+	}
+	if err := handler.validate.Struct(dto); err != nil {
+		return apperr.FromValidator(err)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package conversation
+
+import (
+	"context"
+	"post-service/database"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusAccepted = "accepted"
+	StatusDeclined = "declined"
+)
+
+// Conversation is the thread between a renter and the owner about one post.
+type Conversation struct {
+	ID            uint
+	PostID        uint
+	OwnerId       uint
+	RenterId      uint
+	Status        string
+	LastMessageAt time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// otherParticipant returns the user userId is talking to.
+func (conversation *Conversation) otherParticipant(userId uint) uint {
+	if userId == conversation.OwnerId {
+		return conversation.RenterId
+	}
+	return conversation.OwnerId
+}
+
+// Message is one message in a conversation. ReadAt is set once the other
+// participant has read it.
+type Message struct {
+	ID             uint
+	ConversationID uint
+	SenderId       uint
+	Body           string
+	ReadAt         *time.Time
+	CreatedAt      time.Time
+}
+
+// ContactCard holds the contact details a user shares with accepted renters.
+type ContactCard struct {
+	UserId    uint `gorm:"primaryKey;autoIncrement:false"`
+	Email     string
+	Phone     string
+	UpdatedAt time.Time
+}
+
+type UserBlock struct {
+	BlockerId uint `gorm:"primaryKey;autoIncrement:false"`
+	BlockedId uint `gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt time.Time
+}
+
+// ConversationSummary is a conversation with the number of messages the
+// listing user has not read.
+type ConversationSummary struct {
+	Conversation `gorm:"embedded"`
+	UnreadCount  int64
+}
+
+type ConversationRepository struct {
+	db       *gorm.DB
+	timeouts *database.QueryTimeouts
+}
+
+func NewConversationRepository(db *gorm.DB, timeouts *database.QueryTimeouts) *ConversationRepository {
+	return &ConversationRepository{db: db, timeouts: timeouts}
+}
+
+// StartConversation adds message to the renter's conversation about the post,
+// creating the conversation first if there is none. A declined conversation
+// takes no more messages and fails with ErrConversationDeclined.
+func (repo *ConversationRepository) StartConversation(ctx context.Context, conversation *Conversation, message *Message) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.StartConversation")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "post_id"}, {Name: "renter_id"}},
+			DoNothing: true,
+		}).Create(conversation)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			err := tx.Where("post_id = ? AND renter_id = ?", conversation.PostID, conversation.RenterId).
+				First(conversation).Error
+			if err != nil {
+				return err
+			}
+			// Checked in the transaction so the message is never stored.
+			if conversation.Status == StatusDeclined {
+				return ErrConversationDeclined
+			}
+		}
+		message.ConversationID = conversation.ID
+		return addMessage(tx, message)
+	})
+}
+
+func (repo *ConversationRepository) GetConversation(ctx context.Context, conversationId uint) (*Conversation, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.GetConversation")
+	defer cancel()
+
+	var conversation Conversation
+	err := repo.db.WithContext(ctx).First(&conversation, conversationId).Error
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// ListConversations returns the conversations userId takes part in, most
+// recently active first.
+func (repo *ConversationRepository) ListConversations(ctx context.Context, userId uint, offset, limit int) ([]ConversationSummary, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.ListConversations")
+	defer cancel()
+
+	var summaries []ConversationSummary
+	err := repo.db.WithContext(ctx).Model(&Conversation{}).
+		Select(`conversations.*, (
+			SELECT COUNT(*) FROM messages
+			WHERE messages.conversation_id = conversations.id AND messages.sender_id <> ? AND messages.read_at IS NULL
+		) AS unread_count`, userId).
+		Where("conversations.owner_id = ? OR conversations.renter_id = ?", userId, userId).
+		Order("conversations.last_message_at DESC, conversations.id DESC").
+		Offset(offset).Limit(limit).
+		Find(&summaries).Error
+	return summaries, err
+}
+
+// CountUnread returns how many messages sent to userId are unread, in total
+// or in one conversation when conversationId is not zero.
+func (repo *ConversationRepository) CountUnread(ctx context.Context, userId, conversationId uint) (int64, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.CountUnread")
+	defer cancel()
+
+	query := repo.db.WithContext(ctx).Model(&Message{}).
+		Joins("JOIN conversations ON conversations.id = messages.conversation_id").
+		Where("conversations.owner_id = ? OR conversations.renter_id = ?", userId, userId).
+		Where("messages.sender_id <> ? AND messages.read_at IS NULL", userId)
+	if conversationId != 0 {
+		query = query.Where("messages.conversation_id = ?", conversationId)
+	}
+
+	var count int64
+	err := query.Count(&count).Error
+	return count, err
+}
+
+func (repo *ConversationRepository) AddMessage(ctx context.Context, message *Message) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.AddMessage")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return addMessage(tx, message)
+	})
+}
+
+func addMessage(tx *gorm.DB, message *Message) error {
+	if err := tx.Create(message).Error; err != nil {
+		return err
+	}
+	return tx.Model(&Conversation{}).Where("id = ?", message.ConversationID).
+		Updates(map[string]interface{}{"last_message_at": message.CreatedAt, "updated_at": message.CreatedAt}).Error
+}
+
+// GetMessages returns up to limit messages older than beforeId, newest first.
+// A zero beforeId starts from the latest message.
+func (repo *ConversationRepository) GetMessages(ctx context.Context, conversationId, beforeId uint, limit int) ([]Message, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.GetMessages")
+	defer cancel()
+
+	query := repo.db.WithContext(ctx).Where("conversation_id = ?", conversationId)
+	if beforeId != 0 {
+		query = query.Where("id < ?", beforeId)
+	}
+
+	var messages []Message
+	err := query.Order("id DESC").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// MarkRead records that readerId has read the messages sent to them in the
+// conversation, up to and including upToId when it is not zero.
+func (repo *ConversationRepository) MarkRead(ctx context.Context, conversationId, readerId, upToId uint, readAt time.Time) (int64, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.MarkRead")
+	defer cancel()
+
+	query := repo.db.WithContext(ctx).Model(&Message{}).
+		Where("conversation_id = ? AND sender_id <> ? AND read_at IS NULL", conversationId, readerId)
+	if upToId != 0 {
+		query = query.Where("id <= ?", upToId)
+	}
+	result := query.Update("read_at", readAt)
+	return result.RowsAffected, result.Error
+}
+
+func (repo *ConversationRepository) UpdateStatus(ctx context.Context, conversationId uint, status string) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.UpdateStatus")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Model(&Conversation{}).Where("id = ?", conversationId).
+		Updates(map[string]interface{}{"status": status, "updated_at": time.Now()}).Error
+}
+
+func (repo *ConversationRepository) Block(ctx context.Context, blockerId, blockedId uint) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.Block")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&UserBlock{BlockerId: blockerId, BlockedId: blockedId}).Error
+}
+
+// Unblock lifts a block, reporting whether there was one.
+func (repo *ConversationRepository) Unblock(ctx context.Context, blockerId, blockedId uint) (bool, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.Unblock")
+	defer cancel()
+
+	result := repo.db.WithContext(ctx).Where("blocker_id = ? AND blocked_id = ?", blockerId, blockedId).Delete(&UserBlock{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetBlocks returns the blocks between two users, in either direction.
+func (repo *ConversationRepository) GetBlocks(ctx context.Context, userId, otherId uint) ([]UserBlock, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.GetBlocks")
+	defer cancel()
+
+	var blocks []UserBlock
+	err := repo.db.WithContext(ctx).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", userId, otherId, otherId, userId).
+		Find(&blocks).Error
+	return blocks, err
+}
+
+func (repo *ConversationRepository) SaveContactCard(ctx context.Context, card *ContactCard) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.SaveContactCard")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"email", "phone", "updated_at"}),
+	}).Create(card).Error
+}
+
+// GetContactCard returns the user's contact card, or nil if they have none.
+func (repo *ConversationRepository) GetContactCard(ctx context.Context, userId uint) (*ContactCard, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "ConversationRepository.GetContactCard")
+	defer cancel()
+
+	var cards []ContactCard
+	err := repo.db.WithContext(ctx).Where("user_id = ?", userId).Limit(1).Find(&cards).Error
+	if err != nil || len(cards) == 0 {
+		return nil, err
+	}
+	return &cards[0], nil
+}
@@ -0,0 +1,72 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"post-service/database"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestRepository(t *testing.T) (*ConversationRepository, *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&Conversation{}, &Message{}, &ContactCard{}, &UserBlock{}); err != nil {
+		t.Fatal(err)
+	}
+	// The unique key StartConversation resolves conflicts on, as in the
+	// conversations migration.
+	if err := db.Exec("CREATE UNIQUE INDEX conversations_post_renter ON conversations (post_id, renter_id)").Error; err != nil {
+		t.Fatal(err)
+	}
+	return NewConversationRepository(db, &database.QueryTimeouts{Default: time.Second}), db
+}
+
+func TestStartConversation(t *testing.T) {
+	ctx := context.Background()
+	repo, db := newTestRepository(t)
+
+	first := &Conversation{PostID: 1, OwnerId: 1, RenterId: 2, Status: StatusPending}
+	if err := repo.StartConversation(ctx, first, &Message{SenderId: 2, Body: "Is it free on Saturday?"}); err != nil {
+		t.Fatal(err)
+	}
+
+	again := &Conversation{PostID: 1, OwnerId: 1, RenterId: 2, Status: StatusPending}
+	if err := repo.StartConversation(ctx, again, &Message{SenderId: 2, Body: "And on Sunday?"}); err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID {
+		t.Errorf("got conversation %d, want %d", again.ID, first.ID)
+	}
+
+	if err := db.Model(first).Update("status", StatusDeclined).Error; err != nil {
+		t.Fatal(err)
+	}
+	declined := &Conversation{PostID: 1, OwnerId: 1, RenterId: 2, Status: StatusPending}
+	err := repo.StartConversation(ctx, declined, &Message{SenderId: 2, Body: "Please?"})
+	if !errors.Is(err, ErrConversationDeclined) {
+		t.Fatalf("got error %v, want ErrConversationDeclined", err)
+	}
+
+	messages, err := repo.GetMessages(ctx, first.ID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Errorf("got %d messages, want 2: the declined conversation took a message", len(messages))
+	}
+}
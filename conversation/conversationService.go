@@ -0,0 +1,401 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"post-service/apperr"
+	"post-service/post"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+var tracer = otel.Tracer("post-service/conversation")
+
+const (
+	conversationPageSize = 20
+	defaultMessageLimit  = 50
+	maxMessageLimit      = 100
+)
+
+// ErrConversationNotFound is also returned to users who are not participants,
+// so that conversation ids reveal nothing.
+var ErrConversationNotFound = apperr.NotFound("conversation_not_found", "conversation not found")
+var ErrInvalidConversationId = apperr.BadRequest("invalid_conversation_id", "conversation id must be a positive integer")
+var ErrOwnPost = apperr.BadRequest("own_post", "you cannot start a conversation about your own post")
+var ErrBlocked = apperr.Forbidden("blocked", "messages between you and this user are blocked")
+var ErrOwnerOnly = apperr.Forbidden("owner_only", "only the post owner can answer a rental request")
+var ErrConversationDeclined = apperr.Conflict("conversation_declined", "the owner declined this rental request")
+var ErrBlockNotFound = apperr.NotFound("block_not_found", "you have not blocked this user")
+var ErrInvalidBefore = apperr.Validation("invalid_before", "before must be a message id",
+	apperr.FieldError{Field: "before", Rule: "number", Message: "before must be a message id"})
+
+type ContactResponse struct {
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"`
+}
+
+type ConversationResponse struct {
+	ID            uint      `json:"id"`
+	PostID        uint      `json:"postId"`
+	OwnerId       uint      `json:"ownerId"`
+	RenterId      uint      `json:"renterId"`
+	Status        string    `json:"status"`
+	UnreadCount   int64     `json:"unreadCount"`
+	LastMessageAt time.Time `json:"lastMessageAt"`
+	// BlockedByMe and BlockedByOther are only filled in on a single
+	// conversation.
+	BlockedByMe    bool `json:"blockedByMe,omitempty"`
+	BlockedByOther bool `json:"blockedByOther,omitempty"`
+	// OwnerContact is shown to the renter once the owner accepts.
+	OwnerContact *ContactResponse `json:"ownerContact,omitempty"`
+}
+
+type ConversationListResponse struct {
+	Conversations []ConversationResponse `json:"conversations"`
+	UnreadTotal   int64                  `json:"unreadTotal"`
+}
+
+type MessageResponse struct {
+	ID        uint       `json:"id"`
+	SenderId  uint       `json:"senderId"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ReadAt    *time.Time `json:"readAt,omitempty"`
+}
+
+type MessagePage struct {
+	Messages []MessageResponse `json:"messages"`
+	// NextBefore is passed as ?before= to fetch older messages.
+	NextBefore *uint `json:"nextBefore,omitempty"`
+}
+
+type ConversationService struct {
+	repo     *ConversationRepository
+	postRepo post.PostStore
+}
+
+func NewConversationService(repo *ConversationRepository, postRepo post.PostStore) *ConversationService {
+	return &ConversationService{repo: repo, postRepo: postRepo}
+}
+
+// StartConversation sends a renter's first message about a post, or adds to
+// the conversation they already have about it.
+func (service *ConversationService) StartConversation(ctx context.Context, renterId uint, postIdStr, body string) (*ConversationResponse, error) {
+	ctx, span := tracer.Start(ctx, "ConversationService.StartConversation")
+	defer span.End()
+
+	postId, err := strconv.ParseUint(postIdStr, 10, 32)
+	if err != nil {
+		return nil, post.ErrInvalidPostId
+	}
+	target, err := service.postRepo.GetPostByID(ctx, uint(postId))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, post.ErrPostNotFound
+		}
+		return nil, err
+	}
+	if !target.VisibleAt(time.Now()) {
+		return nil, post.ErrPostNotFound
+	}
+	if target.OwnerId == renterId {
+		return nil, ErrOwnPost
+	}
+	if err := service.checkNotBlocked(ctx, renterId, target.OwnerId); err != nil {
+		return nil, err
+	}
+
+	conversation := &Conversation{PostID: target.ID, OwnerId: target.OwnerId, RenterId: renterId, Status: StatusPending}
+	message := &Message{SenderId: renterId, Body: body}
+	if err := service.repo.StartConversation(ctx, conversation, message); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("conversation.id", int(conversation.ID)))
+	return service.conversationResponse(ctx, conversation, renterId)
+}
+
+func (service *ConversationService) ListConversations(ctx context.Context, userId uint, pageStr string) (*ConversationListResponse, error) {
+	ctx, span := tracer.Start(ctx, "ConversationService.ListConversations")
+	defer span.End()
+
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	summaries, err := service.repo.ListConversations(ctx, userId, (page-1)*conversationPageSize, conversationPageSize)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := service.repo.CountUnread(ctx, userId, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ConversationListResponse{Conversations: []ConversationResponse{}, UnreadTotal: unread}
+	for _, summary := range summaries {
+		item := toConversationResponse(&summary.Conversation)
+		item.UnreadCount = summary.UnreadCount
+		response.Conversations = append(response.Conversations, item)
+	}
+	return response, nil
+}
+
+func (service *ConversationService) CountUnread(ctx context.Context, userId uint) (int64, error) {
+	ctx, span := tracer.Start(ctx, "ConversationService.CountUnread")
+	defer span.End()
+
+	return service.repo.CountUnread(ctx, userId, 0)
+}
+
+func (service *ConversationService) GetConversation(ctx context.Context, userId uint, conversationIdStr string) (*ConversationResponse, error) {
+	ctx, span := tracer.Start(ctx, "ConversationService.GetConversation")
+	defer span.End()
+
+	conversation, err := service.participantConversation(ctx, userId, conversationIdStr)
+	if err != nil {
+		return nil, err
+	}
+	return service.conversationResponse(ctx, conversation, userId)
+}
+
+// GetMessages pages backwards through a conversation, newest first.
+func (service *ConversationService) GetMessages(ctx context.Context, userId uint, conversationIdStr, beforeStr, limitStr string) (*MessagePage, error) {
+	ctx, span := tracer.Start(ctx, "ConversationService.GetMessages")
+	defer span.End()
+
+	conversation, err := service.participantConversation(ctx, userId, conversationIdStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var before uint64
+	if beforeStr != "" {
+		if before, err = strconv.ParseUint(beforeStr, 10, 32); err != nil {
+			return nil, ErrInvalidBefore
+		}
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 {
+		limit = defaultMessageLimit
+	}
+	if limit > maxMessageLimit {
+		limit = maxMessageLimit
+	}
+
+	messages, err := service.repo.GetMessages(ctx, conversation.ID, uint(before), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &MessagePage{Messages: []MessageResponse{}}
+	for _, message := range messages {
+		page.Messages = append(page.Messages, MessageResponse{
+			ID:        message.ID,
+			SenderId:  message.SenderId,
+			Body:      message.Body,
+			CreatedAt: message.CreatedAt,
+			ReadAt:    message.ReadAt,
+		})
+	}
+	if len(messages) == limit {
+		page.NextBefore = &messages[len(messages)-1].ID
+	}
+	return page, nil
+}
+
+func (service *ConversationService) SendMessage(ctx context.Context, userId uint, conversationIdStr, body string) (*MessageResponse, error) {
+	ctx, span := tracer.Start(ctx, "ConversationService.SendMessage")
+	defer span.End()
+
+	conversation, err := service.participantConversation(ctx, userId, conversationIdStr)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.Status == StatusDeclined && userId == conversation.RenterId {
+		return nil, ErrConversationDeclined
+	}
+	if err := service.checkNotBlocked(ctx, userId, conversation.otherParticipant(userId)); err != nil {
+		return nil, err
+	}
+
+	message := &Message{ConversationID: conversation.ID, SenderId: userId, Body: body}
+	if err := service.repo.AddMessage(ctx, message); err != nil {
+		return nil, err
+	}
+	return &MessageResponse{ID: message.ID, SenderId: message.SenderId, Body: message.Body, CreatedAt: message.CreatedAt}, nil
+}
+
+// MarkRead marks the messages sent to userId as read, up to upToId when it is
+// not zero, and returns how many are still unread.
+func (service *ConversationService) MarkRead(ctx context.Context, userId uint, conversationIdStr string, upToId uint) (int64, error) {
+	ctx, span := tracer.Start(ctx, "ConversationService.MarkRead")
+	defer span.End()
+
+	conversation, err := service.participantConversation(ctx, userId, conversationIdStr)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := service.repo.MarkRead(ctx, conversation.ID, userId, upToId, time.Now()); err != nil {
+		return 0, err
+	}
+	return service.repo.CountUnread(ctx, userId, conversation.ID)
+}
+
+// Respond lets the owner accept or decline a rental request. Accepting shares
+// the owner's contact card with the renter.
+func (service *ConversationService) Respond(ctx context.Context, userId uint, conversationIdStr, status string) (*ConversationResponse, error) {
+	ctx, span := tracer.Start(ctx, "ConversationService.Respond")
+	defer span.End()
+
+	conversation, err := service.participantConversation(ctx, userId, conversationIdStr)
+	if err != nil {
+		return nil, err
+	}
+	if userId != conversation.OwnerId {
+		return nil, ErrOwnerOnly
+	}
+
+	if conversation.Status != status {
+		if err := service.repo.UpdateStatus(ctx, conversation.ID, status); err != nil {
+			return nil, err
+		}
+		conversation.Status = status
+	}
+	return service.conversationResponse(ctx, conversation, userId)
+}
+
+// Block stops the other participant from messaging userId, in this and any
+// other conversation, until userId unblocks them.
+func (service *ConversationService) Block(ctx context.Context, userId uint, conversationIdStr string) error {
+	ctx, span := tracer.Start(ctx, "ConversationService.Block")
+	defer span.End()
+
+	conversation, err := service.participantConversation(ctx, userId, conversationIdStr)
+	if err != nil {
+		return err
+	}
+	return service.repo.Block(ctx, userId, conversation.otherParticipant(userId))
+}
+
+func (service *ConversationService) Unblock(ctx context.Context, userId uint, conversationIdStr string) error {
+	ctx, span := tracer.Start(ctx, "ConversationService.Unblock")
+	defer span.End()
+
+	conversation, err := service.participantConversation(ctx, userId, conversationIdStr)
+	if err != nil {
+		return err
+	}
+	removed, err := service.repo.Unblock(ctx, userId, conversation.otherParticipant(userId))
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrBlockNotFound
+	}
+	return nil
+}
+
+func (service *ConversationService) SaveContactCard(ctx context.Context, userId uint, dto ContactDto) (*ContactResponse, error) {
+	ctx, span := tracer.Start(ctx, "ConversationService.SaveContactCard")
+	defer span.End()
+
+	card := &ContactCard{UserId: userId, Email: dto.Email, Phone: dto.Phone, UpdatedAt: time.Now()}
+	if err := service.repo.SaveContactCard(ctx, card); err != nil {
+		return nil, err
+	}
+	return &ContactResponse{Email: card.Email, Phone: card.Phone}, nil
+}
+
+func (service *ConversationService) GetContactCard(ctx context.Context, userId uint) (*ContactResponse, error) {
+	ctx, span := tracer.Start(ctx, "ConversationService.GetContactCard")
+	defer span.End()
+
+	card, err := service.repo.GetContactCard(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if card == nil {
+		return &ContactResponse{}, nil
+	}
+	return &ContactResponse{Email: card.Email, Phone: card.Phone}, nil
+}
+
+// participantConversation loads a conversation userId takes part in.
+func (service *ConversationService) participantConversation(ctx context.Context, userId uint, conversationIdStr string) (*Conversation, error) {
+	conversationId, err := strconv.ParseUint(conversationIdStr, 10, 32)
+	if err != nil {
+		return nil, ErrInvalidConversationId
+	}
+	conversation, err := service.repo.GetConversation(ctx, uint(conversationId))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConversationNotFound
+		}
+		return nil, err
+	}
+	if userId != conversation.OwnerId && userId != conversation.RenterId {
+		return nil, ErrConversationNotFound
+	}
+	return conversation, nil
+}
+
+func (service *ConversationService) checkNotBlocked(ctx context.Context, userId, otherId uint) error {
+	blocks, err := service.repo.GetBlocks(ctx, userId, otherId)
+	if err != nil {
+		return err
+	}
+	if len(blocks) > 0 {
+		return ErrBlocked
+	}
+	return nil
+}
+
+// conversationResponse describes a conversation as viewerId sees it.
+func (service *ConversationService) conversationResponse(ctx context.Context, conversation *Conversation, viewerId uint) (*ConversationResponse, error) {
+	response := toConversationResponse(conversation)
+
+	unread, err := service.repo.CountUnread(ctx, viewerId, conversation.ID)
+	if err != nil {
+		return nil, err
+	}
+	response.UnreadCount = unread
+
+	blocks, err := service.repo.GetBlocks(ctx, viewerId, conversation.otherParticipant(viewerId))
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		if block.BlockerId == viewerId {
+			response.BlockedByMe = true
+		} else {
+			response.BlockedByOther = true
+		}
+	}
+
+	if conversation.Status == StatusAccepted {
+		card, err := service.repo.GetContactCard(ctx, conversation.OwnerId)
+		if err != nil {
+			return nil, err
+		}
+		if card != nil {
+			response.OwnerContact = &ContactResponse{Email: card.Email, Phone: card.Phone}
+		}
+	}
+	return &response, nil
+}
+
+func toConversationResponse(conversation *Conversation) ConversationResponse {
+	return ConversationResponse{
+		ID:            conversation.ID,
+		PostID:        conversation.PostID,
+		OwnerId:       conversation.OwnerId,
+		RenterId:      conversation.RenterId,
+		Status:        conversation.Status,
+		LastMessageAt: conversation.LastMessageAt,
+	}
+}
@@ -0,0 +1,159 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"post-service/category"
+	"post-service/post"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	ownerId  = 1
+	renterId = 2
+)
+
+// newTestService returns a service over a test database and an in-memory post
+// store holding one active post of ownerId, whose id it returns as well.
+func newTestService(t *testing.T) (*ConversationService, *post.MemoryPostStore, string) {
+	t.Helper()
+	repo, _ := newTestRepository(t)
+	posts := post.NewMemoryPostStore(category.NewMemoryCategoryStore())
+	listing := &post.Post{Title: "Cordless drill", Currency: "USD", PriceMinor: 1250, IsActive: true, OwnerId: ownerId}
+	if err := posts.AddPost(context.Background(), listing, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	return NewConversationService(repo, posts), posts, strconv.Itoa(int(listing.ID))
+}
+
+func TestStartConversationChecksPost(t *testing.T) {
+	ctx := context.Background()
+	service, posts, postId := newTestService(t)
+
+	hidden := &post.Post{Title: "Ladder", Currency: "USD", IsActive: false, OwnerId: ownerId}
+	later := time.Now().Add(time.Hour)
+	scheduled := &post.Post{Title: "Saw", Currency: "USD", IsActive: true, OwnerId: ownerId, PublishAt: &later}
+	for _, listing := range []*post.Post{hidden, scheduled} {
+		if err := posts.AddPost(ctx, listing, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		userId uint
+		postId string
+		want   error
+	}{
+		{renterId, "drill", post.ErrInvalidPostId},
+		{renterId, "999", post.ErrPostNotFound},
+		{renterId, strconv.Itoa(int(hidden.ID)), post.ErrPostNotFound},
+		{renterId, strconv.Itoa(int(scheduled.ID)), post.ErrPostNotFound},
+		{ownerId, postId, ErrOwnPost},
+	}
+	for _, test := range tests {
+		if _, err := service.StartConversation(ctx, test.userId, test.postId, "Is it free?"); !errors.Is(err, test.want) {
+			t.Errorf("user %d on post %s: got %v, want %v", test.userId, test.postId, err, test.want)
+		}
+	}
+
+	conversation, err := service.StartConversation(ctx, renterId, postId, "Is it free on Saturday?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conversation.OwnerId != ownerId || conversation.RenterId != renterId || conversation.Status != StatusPending {
+		t.Errorf("got %+v", conversation)
+	}
+}
+
+func TestConversationFlow(t *testing.T) {
+	ctx := context.Background()
+	service, _, postId := newTestService(t)
+
+	started, err := service.StartConversation(ctx, renterId, postId, "Is it free on Saturday?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(int(started.ID))
+
+	if _, err := service.GetConversation(ctx, 3, id); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("an outsider got %v, want %v", err, ErrConversationNotFound)
+	}
+	if unread, err := service.CountUnread(ctx, ownerId); err != nil || unread != 1 {
+		t.Errorf("got %d unread, %v, want 1", unread, err)
+	}
+	if unread, err := service.MarkRead(ctx, ownerId, id, 0); err != nil || unread != 0 {
+		t.Errorf("got %d unread after reading, %v, want 0", unread, err)
+	}
+
+	if _, err := service.Respond(ctx, renterId, id, StatusAccepted); !errors.Is(err, ErrOwnerOnly) {
+		t.Errorf("the renter answering got %v, want %v", err, ErrOwnerOnly)
+	}
+	if _, err := service.SaveContactCard(ctx, ownerId, ContactDto{Email: "owner@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := service.Respond(ctx, ownerId, id, StatusAccepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted.OwnerContact == nil || accepted.OwnerContact.Email != "owner@example.com" {
+		t.Errorf("got owner contact %+v", accepted.OwnerContact)
+	}
+
+	if _, err := service.Respond(ctx, ownerId, id, StatusDeclined); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.SendMessage(ctx, renterId, id, "Please?"); !errors.Is(err, ErrConversationDeclined) {
+		t.Errorf("the declined renter got %v, want %v", err, ErrConversationDeclined)
+	}
+	if _, err := service.SendMessage(ctx, ownerId, id, "Sorry, it is booked."); err != nil {
+		t.Errorf("the owner got %v", err)
+	}
+
+	page, err := service.GetMessages(ctx, renterId, id, "", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].Body != "Sorry, it is booked." || page.NextBefore == nil {
+		t.Errorf("got %+v", page)
+	}
+	if _, err := service.GetMessages(ctx, renterId, id, "latest", ""); !errors.Is(err, ErrInvalidBefore) {
+		t.Errorf("got %v, want %v", err, ErrInvalidBefore)
+	}
+}
+
+func TestConversationBlocks(t *testing.T) {
+	ctx := context.Background()
+	service, _, postId := newTestService(t)
+
+	started, err := service.StartConversation(ctx, renterId, postId, "Is it free on Saturday?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(int(started.ID))
+
+	if err := service.Unblock(ctx, ownerId, id); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("got %v, want %v", err, ErrBlockNotFound)
+	}
+	if err := service.Block(ctx, ownerId, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.SendMessage(ctx, renterId, id, "Hello?"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("got %v, want %v", err, ErrBlocked)
+	}
+	if _, err := service.StartConversation(ctx, renterId, postId, "Hello?"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("got %v, want %v", err, ErrBlocked)
+	}
+	if conversation, err := service.GetConversation(ctx, renterId, id); err != nil || !conversation.BlockedByOther || conversation.BlockedByMe {
+		t.Errorf("the renter got %+v, %v", conversation, err)
+	}
+
+	if err := service.Unblock(ctx, ownerId, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.SendMessage(ctx, renterId, id, "Hello?"); err != nil {
+		t.Errorf("got %v after unblocking", err)
+	}
+}
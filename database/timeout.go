@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const defaultQueryTimeout = 5 * time.Second
+
+// QueryTimeouts holds the deadline applied to each repository operation.
+// Operations are keyed as "<Repository>.<Method>", e.g. "PostRepository.GetAllPosts".
+type QueryTimeouts struct {
+	Default    time.Duration
+	Operations map[string]time.Duration
+}
+
+// NewQueryTimeouts reads DB_QUERY_TIMEOUT (the default, e.g. "5s") and
+// DB_QUERY_TIMEOUTS, a comma separated list of per-operation overrides such as
+// "PostRepository.GetAllPosts=2s,CategoryRepository.GetAllCategories=500ms".
+func NewQueryTimeouts() (*QueryTimeouts, error) {
+	timeouts := &QueryTimeouts{Default: defaultQueryTimeout, Operations: map[string]time.Duration{}}
+
+	if value := os.Getenv("DB_QUERY_TIMEOUT"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DB_QUERY_TIMEOUT: %w", err)
+		}
+		timeouts.Default = d
+	}
+
+	if value := os.Getenv("DB_QUERY_TIMEOUTS"); value != "" {
+		for _, entry := range strings.Split(value, ",") {
+			op, durationStr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid DB_QUERY_TIMEOUTS entry %q", entry)
+			}
+			d, err := time.ParseDuration(durationStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DB_QUERY_TIMEOUTS entry %q: %w", entry, err)
+			}
+			timeouts.Operations[op] = d
+		}
+	}
+
+	return timeouts, nil
+}
+
+// Context derives a context bounded by the timeout configured for op.
+// A zero or negative timeout leaves ctx without an extra deadline.
+func (t *QueryTimeouts) Context(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	timeout := t.Default
+	if d, ok := t.Operations[op]; ok {
+		timeout = d
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// IsTimeout reports whether err was caused by a query running past its deadline.
+// pgconn reports a canceled query as a timeout too, so cancellation is ruled
+// out first.
+func IsTimeout(err error) bool {
+	if IsCanceled(err) {
+		return false
+	}
+	return errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err)
+}
+
+// IsCanceled reports whether err was caused by the request's context being
+// canceled, which happens when the client disconnects.
+func IsCanceled(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type txKey struct{}
+
+// Hook runs inside the transaction of a change, before it commits. What it
+// writes through Tx commits or rolls back with the change, and an error from
+// it rolls the change back.
+type Hook func(ctx context.Context) error
+
+// RunHook runs hook, if any, with ctx carrying tx.
+func RunHook(ctx context.Context, tx *gorm.DB, hook Hook) error {
+	if hook == nil {
+		return nil
+	}
+	return hook(WithTx(ctx, tx))
+}
+
+// WithTx returns a context carrying tx.
+func WithTx(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// Tx returns the transaction carried by ctx, or db outside of one.
+func Tx(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok && tx != nil {
+		return tx
+	}
+	return db
+}
@@ -0,0 +1,80 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"post-service/logging"
+	"post-service/migrations"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const readinessTimeout = 2 * time.Second
+
+type HealthHandler struct {
+	db           *gorm.DB
+	shuttingDown atomic.Bool
+}
+
+func NewHealthHandler(db *gorm.DB) *HealthHandler {
+	return &HealthHandler{db: db}
+}
+
+// MarkShuttingDown makes the readiness probe fail so the orchestrator stops
+// routing new traffic while in-flight requests drain.
+func (handler *HealthHandler) MarkShuttingDown() {
+	handler.shuttingDown.Store(true)
+}
+
+func (handler *HealthHandler) Liveness(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (handler *HealthHandler) Readiness(c echo.Context) error {
+	if handler.shuttingDown.Load() {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"status": "shutting down"})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), readinessTimeout)
+	defer cancel()
+
+	sqlDB, err := handler.db.DB()
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to get database handle", zap.Error(err))
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"status": "database unavailable"})
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		logging.FromContext(ctx).Error("database ping failed", zap.Error(err))
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"status": "database unavailable"})
+	}
+
+	expected, err := migrations.LatestVersion()
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to read embedded migrations", zap.Error(err))
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"status": "migrations unavailable"})
+	}
+
+	var current struct {
+		Version uint
+		Dirty   bool
+	}
+	err = handler.db.WithContext(ctx).Raw("SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&current).Error
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to read migration version", zap.Error(err))
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"status": "migrations unavailable"})
+	}
+	if current.Dirty || current.Version < expected {
+		return c.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+			"status":          "migrations pending",
+			"currentVersion":  current.Version,
+			"expectedVersion": expected,
+			"dirty":           current.Dirty,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "ready"})
+}
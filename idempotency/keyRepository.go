@@ -0,0 +1,108 @@
+package idempotency
+
+import (
+	"context"
+	"post-service/database"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	StatusInProgress = "in_progress"
+	StatusComplete   = "complete"
+)
+
+// Key is one Idempotency-Key a user sent, with the response to replay once
+// the first request completes.
+type Key struct {
+	UserId         uint   `gorm:"primaryKey;autoIncrement:false"`
+	Key            string `gorm:"primaryKey"`
+	RequestHash    string
+	Status         string
+	ResponseStatus int
+	ContentType    string
+	Location       string
+	ResponseBody   []byte
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+}
+
+func (Key) TableName() string {
+	return "idempotency_keys"
+}
+
+type KeyRepository struct {
+	db       *gorm.DB
+	timeouts *database.QueryTimeouts
+}
+
+func NewKeyRepository(db *gorm.DB, timeouts *database.QueryTimeouts) *KeyRepository {
+	return &KeyRepository{db: db, timeouts: timeouts}
+}
+
+// Claim stores key as in progress, unless the user already holds it. Then the
+// stored key is returned instead and nothing changes, except that an expired
+// key, or one left in progress since before staleBefore, is replaced.
+func (repo *KeyRepository) Claim(ctx context.Context, key *Key, staleBefore time.Time) (*Key, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "KeyRepository.Claim")
+	defer cancel()
+
+	var existing *Key
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND key = ?", key.UserId, key.Key).
+			Where("expires_at <= ? OR (status = ? AND created_at <= ?)", key.CreatedAt, StatusInProgress, staleBefore).
+			Delete(&Key{}).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(key)
+		if result.Error != nil || result.RowsAffected == 1 {
+			return result.Error
+		}
+
+		existing = &Key{}
+		return tx.Where("user_id = ? AND key = ?", key.UserId, key.Key).First(existing).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// Complete stores the response to replay for a claimed key.
+func (repo *KeyRepository) Complete(ctx context.Context, key *Key) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "KeyRepository.Complete")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Model(&Key{}).
+		Where("user_id = ? AND key = ? AND request_hash = ?", key.UserId, key.Key, key.RequestHash).
+		Updates(map[string]interface{}{
+			"status":          StatusComplete,
+			"response_status": key.ResponseStatus,
+			"content_type":    key.ContentType,
+			"location":        key.Location,
+			"response_body":   key.ResponseBody,
+		}).Error
+}
+
+// Release forgets a claimed key whose request should be retried for real.
+func (repo *KeyRepository) Release(ctx context.Context, key *Key) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "KeyRepository.Release")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).
+		Where("user_id = ? AND key = ? AND request_hash = ? AND status = ?", key.UserId, key.Key, key.RequestHash, StatusInProgress).
+		Delete(&Key{}).Error
+}
+
+// DeleteExpired removes keys that expired before now.
+func (repo *KeyRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "KeyRepository.DeleteExpired")
+	defer cancel()
+
+	result := repo.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&Key{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,130 @@
+package idempotency
+
+import (
+	"context"
+	"post-service/database"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestRepository(t *testing.T) *KeyRepository {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&Key{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewKeyRepository(db, &database.QueryTimeouts{Default: time.Second})
+}
+
+func newKey(userId uint, key, hash string, now time.Time) *Key {
+	return &Key{
+		UserId:      userId,
+		Key:         key,
+		RequestHash: hash,
+		Status:      StatusInProgress,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+}
+
+func TestKeyRepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	stale := now.Add(-staleAfter)
+
+	t.Run("claim and complete", func(t *testing.T) {
+		repo := newTestRepository(t)
+		if existing, err := repo.Claim(ctx, newKey(1, "a", "hash", now), stale); err != nil || existing != nil {
+			t.Fatalf("first claim: got %+v, %v", existing, err)
+		}
+		existing, err := repo.Claim(ctx, newKey(1, "a", "hash", now), stale)
+		if err != nil || existing == nil || existing.Status != StatusInProgress {
+			t.Fatalf("second claim: got %+v, %v", existing, err)
+		}
+		if existing, err := repo.Claim(ctx, newKey(2, "a", "hash", now), stale); err != nil || existing != nil {
+			t.Errorf("another user's claim: got %+v, %v", existing, err)
+		}
+
+		key := newKey(1, "a", "hash", now)
+		key.ResponseStatus = 201
+		key.ContentType = "application/json"
+		key.ResponseBody = []byte(`{"id":1}`)
+		if err := repo.Complete(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		existing, err = repo.Claim(ctx, newKey(1, "a", "other", now), stale)
+		if err != nil || existing == nil {
+			t.Fatalf("claim after complete: got %+v, %v", existing, err)
+		}
+		if existing.Status != StatusComplete || existing.RequestHash != "hash" || existing.ResponseStatus != 201 || string(existing.ResponseBody) != `{"id":1}` {
+			t.Errorf("got %+v", existing)
+		}
+	})
+
+	t.Run("release", func(t *testing.T) {
+		repo := newTestRepository(t)
+		if _, err := repo.Claim(ctx, newKey(1, "a", "hash", now), stale); err != nil {
+			t.Fatal(err)
+		}
+		// Only the request holding the key may release it.
+		if err := repo.Release(ctx, newKey(1, "a", "other", now)); err != nil {
+			t.Fatal(err)
+		}
+		if existing, err := repo.Claim(ctx, newKey(1, "a", "hash", now), stale); err != nil || existing == nil {
+			t.Fatalf("released by another request: got %+v, %v", existing, err)
+		}
+		if err := repo.Release(ctx, newKey(1, "a", "hash", now)); err != nil {
+			t.Fatal(err)
+		}
+		if existing, err := repo.Claim(ctx, newKey(1, "a", "hash", now), stale); err != nil || existing != nil {
+			t.Errorf("claim after release: got %+v, %v", existing, err)
+		}
+	})
+
+	t.Run("stale and expired keys", func(t *testing.T) {
+		repo := newTestRepository(t)
+		if _, err := repo.Claim(ctx, newKey(1, "stale", "hash", now.Add(-2*staleAfter)), stale); err != nil {
+			t.Fatal(err)
+		}
+		if existing, err := repo.Claim(ctx, newKey(1, "stale", "hash", now), stale); err != nil || existing != nil {
+			t.Errorf("claim of a stale key: got %+v, %v", existing, err)
+		}
+
+		expired := newKey(1, "expired", "hash", now.Add(-2*time.Hour))
+		if _, err := repo.Claim(ctx, expired, stale); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Complete(ctx, expired); err != nil {
+			t.Fatal(err)
+		}
+		if existing, err := repo.Claim(ctx, newKey(1, "expired", "other", now), stale); err != nil || existing != nil {
+			t.Errorf("claim of an expired key: got %+v, %v", existing, err)
+		}
+	})
+
+	t.Run("delete expired", func(t *testing.T) {
+		repo := newTestRepository(t)
+		for _, key := range []*Key{newKey(1, "old", "hash", now.Add(-2*time.Hour)), newKey(1, "new", "hash", now)} {
+			if _, err := repo.Claim(ctx, key, stale); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if deleted, err := repo.DeleteExpired(ctx, now); err != nil || deleted != 1 {
+			t.Errorf("got %d, %v", deleted, err)
+		}
+	})
+}
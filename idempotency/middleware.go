@@ -0,0 +1,215 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"post-service/apperr"
+	"post-service/logging"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderReplayed marks a response replayed from an earlier request.
+	HeaderReplayed = "Idempotent-Replayed"
+
+	defaultKeyTTL = 24 * time.Hour
+	// staleAfter is how long a request may hold its key in progress before a
+	// retry assumes it died and runs again.
+	staleAfter = 5 * time.Minute
+	// sweepInterval is how often expired keys are deleted.
+	sweepInterval = time.Hour
+	maxKeyLength  = 255
+	// maxBodySize bounds the body read to hash a request. It is the size of
+	// the largest request an idempotent route takes, an import file.
+	maxBodySize = 10 << 20
+)
+
+var ErrInvalidKey = apperr.BadRequest("invalid_idempotency_key", "Idempotency-Key must be 1 to 255 printable ASCII characters")
+var ErrKeyInUse = apperr.Conflict("idempotency_key_in_use", "a request with this Idempotency-Key is still being processed")
+var ErrKeyReused = apperr.Unprocessable("idempotency_key_reused", "this Idempotency-Key was already used for a different request")
+var ErrBodyTooLarge = apperr.New(apperr.KindTooLarge, "request_too_large", fmt.Sprintf("requests with an Idempotency-Key must be at most %d bytes", maxBodySize))
+
+// Middleware makes POST endpoints safe to retry. A request carrying an
+// Idempotency-Key runs once per user and key; retries within the key's TTL
+// get the stored response back.
+type Middleware struct {
+	repo *KeyRepository
+	ttl  time.Duration
+
+	lastSweep atomic.Int64
+}
+
+// NewMiddleware reads IDEMPOTENCY_KEY_TTL, how long keys are kept, as a Go
+// duration. It defaults to 24h.
+func NewMiddleware(repo *KeyRepository) (*Middleware, error) {
+	ttl := defaultKeyTTL
+	if value := os.Getenv("IDEMPOTENCY_KEY_TTL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IDEMPOTENCY_KEY_TTL: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid IDEMPOTENCY_KEY_TTL: must be positive")
+		}
+		ttl = d
+	}
+	return &Middleware{repo: repo, ttl: ttl}, nil
+}
+
+// Handle must run after auth.AuthMiddleware. Requests without the header
+// pass through. Only responses below 500, other than 429, are stored, so
+// failures that may succeed later can be retried with the same key.
+func (middleware *Middleware) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		keyValue := c.Request().Header.Get(HeaderIdempotencyKey)
+		if keyValue == "" {
+			return next(c)
+		}
+		if !validKey(keyValue) {
+			return ErrInvalidKey
+		}
+		userId, ok := c.Get("userId").(uint)
+		if !ok {
+			return apperr.Unauthorized("unauthorized", "you are not logged in")
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, maxBodySize))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return ErrBodyTooLarge
+			}
+			return apperr.BadRequest("invalid_request", "failed to read request body")
+		}
+		c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request().Context()
+		now := time.Now()
+		middleware.sweep(ctx, now)
+
+		key := &Key{
+			UserId:      userId,
+			Key:         keyValue,
+			RequestHash: requestHash(c.Request(), body),
+			Status:      StatusInProgress,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(middleware.ttl),
+		}
+		existing, err := middleware.repo.Claim(ctx, key, now.Add(-staleAfter))
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return replay(c, key, existing)
+		}
+
+		return middleware.record(c, key, next)
+	}
+}
+
+// record runs the request and stores its response under key.
+func (middleware *Middleware) record(c echo.Context, key *Key, next echo.HandlerFunc) error {
+	res := c.Response()
+	var captured bytes.Buffer
+	writer := res.Writer
+	res.Writer = &teeWriter{ResponseWriter: writer, buffer: &captured}
+	defer func() { res.Writer = writer }()
+
+	if err := next(c); err != nil {
+		// Render the error here so that the problem document is captured.
+		c.Error(err)
+	}
+
+	// Keep the outcome even if the client went away; that is when it retries.
+	ctx := context.WithoutCancel(c.Request().Context())
+	if res.Status >= http.StatusInternalServerError || res.Status == http.StatusTooManyRequests {
+		if err := middleware.repo.Release(ctx, key); err != nil {
+			logging.FromContext(ctx).Error("releasing idempotency key failed", zap.Uint("userId", key.UserId), zap.Error(err))
+		}
+		return nil
+	}
+
+	key.ResponseStatus = res.Status
+	key.ContentType = res.Header().Get(echo.HeaderContentType)
+	key.Location = res.Header().Get(echo.HeaderLocation)
+	key.ResponseBody = captured.Bytes()
+	if err := middleware.repo.Complete(ctx, key); err != nil {
+		logging.FromContext(ctx).Error("storing idempotent response failed", zap.Uint("userId", key.UserId), zap.Error(err))
+	}
+	return nil
+}
+
+// replay answers a retry from the stored key.
+func replay(c echo.Context, key, existing *Key) error {
+	if existing.RequestHash != key.RequestHash {
+		return ErrKeyReused
+	}
+	if existing.Status != StatusComplete {
+		return ErrKeyInUse
+	}
+
+	header := c.Response().Header()
+	header.Set(HeaderReplayed, "true")
+	if existing.ContentType != "" {
+		header.Set(echo.HeaderContentType, existing.ContentType)
+	}
+	if existing.Location != "" {
+		header.Set(echo.HeaderLocation, existing.Location)
+	}
+	c.Response().WriteHeader(existing.ResponseStatus)
+	_, err := c.Response().Write(existing.ResponseBody)
+	return err
+}
+
+// sweep deletes expired keys at most once per sweepInterval.
+func (middleware *Middleware) sweep(ctx context.Context, now time.Time) {
+	last := middleware.lastSweep.Load()
+	if now.UnixNano()-last < int64(sweepInterval) || !middleware.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	if _, err := middleware.repo.DeleteExpired(ctx, now); err != nil {
+		logging.FromContext(ctx).Error("deleting expired idempotency keys failed", zap.Error(err))
+	}
+}
+
+// requestHash identifies a request by method, path, query and body.
+func requestHash(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", req.Method, req.URL.RequestURI())
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func validKey(key string) bool {
+	if len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+type teeWriter struct {
+	http.ResponseWriter
+	buffer *bytes.Buffer
+}
+
+func (w *teeWriter) Write(b []byte) (int, error) {
+	w.buffer.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,159 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"post-service/apperr"
+	"post-service/validation"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type middlewareTest struct {
+	e     *echo.Echo
+	repo  *KeyRepository
+	calls int
+}
+
+// newMiddlewareTest serves POST /things, which counts its calls and answers
+// with the count, and POST /broken, which fails with a 500.
+func newMiddlewareTest(t *testing.T) *middlewareTest {
+	t.Helper()
+	_, uni, err := validation.NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := &middlewareTest{repo: newTestRepository(t)}
+	middleware, err := NewMiddleware(test.repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if userId, err := strconv.ParseUint(c.Request().Header.Get("X-User-Id"), 10, 64); err == nil {
+				c.Set("userId", uint(userId))
+			}
+			return next(c)
+		}
+	}
+	test.e = echo.New()
+	test.e.HTTPErrorHandler = apperr.NewHTTPErrorHandler(uni)
+	test.e.POST("/things", func(c echo.Context) error {
+		test.calls++
+		body, _ := io.ReadAll(c.Request().Body)
+		return c.JSON(http.StatusCreated, map[string]interface{}{"call": test.calls, "body": string(body)})
+	}, login, middleware.Handle)
+	test.e.POST("/broken", func(c echo.Context) error {
+		test.calls++
+		return apperr.New(apperr.KindUnavailable, "unavailable", "try again later")
+	}, login, middleware.Handle)
+	return test
+}
+
+func (test *middlewareTest) post(t *testing.T, target string, userId uint, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("X-User-Id", strconv.FormatUint(uint64(userId), 10))
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	rec := httptest.NewRecorder()
+	test.e.ServeHTTP(rec, req)
+	return rec
+}
+
+func problemCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var problem apperr.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return problem.Code
+}
+
+func TestMiddlewareReplays(t *testing.T) {
+	test := newMiddlewareTest(t)
+
+	first := test.post(t, "/things", 1, "key-1", `{"name":"drill"}`)
+	if first.Code != http.StatusCreated || first.Header().Get(HeaderReplayed) != "" {
+		t.Fatalf("got %d %q", first.Code, first.Body.String())
+	}
+	retry := test.post(t, "/things", 1, "key-1", `{"name":"drill"}`)
+	if retry.Code != http.StatusCreated || retry.Header().Get(HeaderReplayed) != "true" || retry.Body.String() != first.Body.String() {
+		t.Errorf("got %d %q, want a replay of %q", retry.Code, retry.Body.String(), first.Body.String())
+	}
+	if retry.Header().Get(echo.HeaderContentType) != first.Header().Get(echo.HeaderContentType) {
+		t.Errorf("got content type %q", retry.Header().Get(echo.HeaderContentType))
+	}
+	if test.calls != 1 {
+		t.Errorf("handler ran %d times", test.calls)
+	}
+
+	// Keys belong to one user, and requests without a key always run.
+	if rec := test.post(t, "/things", 2, "key-1", `{"name":"drill"}`); rec.Code != http.StatusCreated || rec.Header().Get(HeaderReplayed) != "" {
+		t.Errorf("another user: got %d %q", rec.Code, rec.Body.String())
+	}
+	test.post(t, "/things", 1, "", `{"name":"drill"}`)
+	test.post(t, "/things", 1, "", `{"name":"drill"}`)
+	if test.calls != 4 {
+		t.Errorf("handler ran %d times, want 4", test.calls)
+	}
+}
+
+func TestMiddlewareRejects(t *testing.T) {
+	test := newMiddlewareTest(t)
+	test.post(t, "/things", 1, "key-1", `{"name":"drill"}`)
+
+	// The same key for a different request is refused.
+	rec := test.post(t, "/things", 1, "key-1", `{"name":"ladder"}`)
+	if rec.Code != http.StatusUnprocessableEntity || problemCode(t, rec) != ErrKeyReused.Code {
+		t.Errorf("reused key: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// A retry while the first request still holds the key is refused.
+	req := httptest.NewRequest(http.MethodPost, "/things", nil)
+	body := []byte(`{"name":"drill"}`)
+	now := time.Now()
+	key := newKey(1, "key-2", requestHash(req, body), now)
+	if _, err := test.repo.Claim(context.Background(), key, now.Add(-staleAfter)); err != nil {
+		t.Fatal(err)
+	}
+	rec = test.post(t, "/things", 1, "key-2", string(body))
+	if rec.Code != http.StatusConflict || problemCode(t, rec) != ErrKeyInUse.Code {
+		t.Errorf("key in use: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = test.post(t, "/things", 1, strings.Repeat("k", maxKeyLength+1), `{}`)
+	if rec.Code != http.StatusBadRequest || problemCode(t, rec) != ErrInvalidKey.Code {
+		t.Errorf("invalid key: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := test.post(t, "/things", 1, "key\n", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("control character: got %d", rec.Code)
+	}
+	if test.calls != 1 {
+		t.Errorf("handler ran %d times", test.calls)
+	}
+}
+
+func TestMiddlewareReleasesFailures(t *testing.T) {
+	test := newMiddlewareTest(t)
+
+	for i := 1; i <= 2; i++ {
+		rec := test.post(t, "/broken", 1, "key-1", `{}`)
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get(HeaderReplayed) != "" {
+			t.Errorf("attempt %d: got %d %q", i, rec.Code, rec.Body.String())
+		}
+		if test.calls != i {
+			t.Errorf("attempt %d: handler ran %d times", i, test.calls)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+type scopeKey struct{}
+
+// scope is the logging state of one request. The user is only known once
+// auth has run, after the scope was created, so it is filled in later.
+type scope struct {
+	logger    *zap.Logger
+	requestId string
+	userId    atomic.Uint64
+}
+
+func withScope(ctx context.Context, s *scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, s)
+}
+
+func scopeFrom(ctx context.Context) *scope {
+	s, _ := ctx.Value(scopeKey{}).(*scope)
+	return s
+}
+
+// FromContext returns the logger of the request ctx belongs to, which adds
+// the request ID, route and, once logged in, the user ID to every entry.
+// Outside a request it returns the global logger.
+func FromContext(ctx context.Context) *zap.Logger {
+	s := scopeFrom(ctx)
+	if s == nil {
+		return zap.L()
+	}
+	if userId := s.userId.Load(); userId != 0 {
+		return s.logger.With(zap.Uint64("userId", userId))
+	}
+	return s.logger
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "" outside one.
+func RequestID(ctx context.Context) string {
+	if s := scopeFrom(ctx); s != nil {
+		return s.requestId
+	}
+	return ""
+}
+
+// SetUserID records the logged-in user for the request ctx belongs to.
+func SetUserID(ctx context.Context, userId uint) {
+	if s := scopeFrom(ctx); s != nil {
+		s.userId.Store(uint64(userId))
+	}
+}
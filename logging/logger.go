@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewLogger builds the service logger and installs it as zap's global
+// logger, so code still calling zap.L() logs through it too. LOG_LEVEL sets
+// the minimum level (default info) and LOG_FORMAT=console switches from JSON
+// to human-readable output. Repeated messages are sampled: after the first
+// 100 with the same level and message in a second, only every 100th is kept.
+func NewLogger(lc fx.Lifecycle) (*zap.Logger, error) {
+	config := zap.NewProductionConfig()
+	config.EncoderConfig.TimeKey = "time"
+	config.EncoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+	config.Sampling = &zap.SamplingConfig{Initial: 100, Thereafter: 100}
+
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		level, err := zap.ParseAtomicLevel(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+		}
+		config.Level = level
+	}
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "json":
+	case "console":
+		config.Encoding = "console"
+		config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q: must be json or console", format)
+	}
+
+	logger, err := config.Build()
+	if err != nil {
+		return nil, err
+	}
+	restore := zap.ReplaceGlobals(logger)
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			restore()
+			// Syncing stderr fails on some platforms; there is nothing to do
+			// about it on the way out.
+			_ = logger.Sync()
+			return nil
+		},
+	})
+	return logger, nil
+}
+
+// durationField reports d in milliseconds, which log queries handle better
+// than zap's default nanoseconds.
+func durationField(key string, d time.Duration) zap.Field {
+	return zap.Float64(key, float64(d.Microseconds())/1000)
+}
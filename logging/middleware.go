@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const maxRequestIdLength = 100
+
+// AccessLog logs one entry per request.
+type AccessLog struct {
+	logger *zap.Logger
+}
+
+func NewAccessLog(logger *zap.Logger) *AccessLog {
+	return &AccessLog{logger: logger}
+}
+
+// Middleware gives each request an ID, taken from a well-formed X-Request-ID
+// header or generated, and echoes it in the response. It sets up the logger
+// FromContext returns and, once the request is done, logs its method, route,
+// status, latency and size. Headers and bodies are never logged, so neither
+// are credentials. Health probes are only logged when they fail.
+//
+// It renders errors itself so that the logged status is the one sent, and
+// should come right after tracing so that everything else sees the ID.
+func (accessLog *AccessLog) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		req := c.Request()
+
+		requestId := req.Header.Get(echo.HeaderXRequestID)
+		if !validRequestId(requestId) {
+			requestId = newRequestId()
+		}
+		c.Response().Header().Set(echo.HeaderXRequestID, requestId)
+
+		fields := []zap.Field{zap.String("requestId", requestId), zap.String("route", c.Path())}
+		if spanContext := trace.SpanContextFromContext(req.Context()); spanContext.HasTraceID() {
+			fields = append(fields, zap.String("traceId", spanContext.TraceID().String()))
+		}
+		s := &scope{logger: accessLog.logger.With(fields...), requestId: requestId}
+		c.SetRequest(req.WithContext(withScope(req.Context(), s)))
+
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+
+		res := c.Response()
+		if isProbe(c.Path()) && res.Status < http.StatusInternalServerError {
+			return nil
+		}
+		level := zapcore.InfoLevel
+		if res.Status >= http.StatusInternalServerError {
+			level = zapcore.ErrorLevel
+		}
+		if entry := FromContext(c.Request().Context()).Check(level, "request"); entry != nil {
+			entry.Write(
+				zap.String("method", req.Method),
+				zap.String("path", req.URL.Path),
+				zap.Int("status", res.Status),
+				durationField("latencyMs", time.Since(start)),
+				zap.Int64("bytesOut", res.Size),
+				zap.String("ip", c.RealIP()),
+			)
+		}
+		return nil
+	}
+}
+
+func isProbe(route string) bool {
+	return route == "/healthz" || route == "/readyz"
+}
+
+// validRequestId accepts IDs short enough to index and made only of
+// characters that cannot forge log lines.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		b := id[i]
+		if !(b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '-' || b == '_' || b == '.' || b == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	// crypto/rand does not fail on supported platforms.
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
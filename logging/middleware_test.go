@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newTestServer() (*echo.Echo, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	e := echo.New()
+	e.Use(NewAccessLog(zap.New(core)).Middleware)
+	e.GET("/posts", func(c echo.Context) error {
+		FromContext(c.Request().Context()).Info("listing posts")
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/healthz", func(c echo.Context) error {
+		if c.QueryParam("fail") != "" {
+			return echo.NewHTTPError(http.StatusServiceUnavailable)
+		}
+		return c.NoContent(http.StatusOK)
+	})
+	return e, logs
+}
+
+func serve(e *echo.Echo, path, requestId string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if requestId != "" {
+		req.Header.Set(echo.HeaderXRequestID, requestId)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAccessLogRequestId(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestId string
+		kept      bool
+	}{
+		{"well-formed", "req-42:retry.1", true},
+		{"missing", "", false},
+		{"forged line", "abc\nlevel=error", false},
+		{"too long", strings.Repeat("a", maxRequestIdLength+1), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, logs := newTestServer()
+			rec := serve(e, "/posts", test.requestId)
+
+			echoed := rec.Header().Get(echo.HeaderXRequestID)
+			if test.kept && echoed != test.requestId {
+				t.Errorf("got request ID %q, want %q", echoed, test.requestId)
+			}
+			if !test.kept && (echoed == test.requestId || len(echoed) != 32) {
+				t.Errorf("got request ID %q, want a generated one", echoed)
+			}
+
+			entries := logs.All()
+			if len(entries) != 2 || entries[0].Message != "listing posts" || entries[1].Message != "request" {
+				t.Fatalf("got entries %+v", entries)
+			}
+			for _, entry := range entries {
+				if got := entry.ContextMap()["requestId"]; got != echoed {
+					t.Errorf("%s: got request ID %v, want %q", entry.Message, got, echoed)
+				}
+			}
+			if fields := entries[1].ContextMap(); fields["status"] != int64(http.StatusOK) || fields["route"] != "/posts" {
+				t.Errorf("got fields %v", fields)
+			}
+		})
+	}
+}
+
+func TestAccessLogSkipsProbes(t *testing.T) {
+	e, logs := newTestServer()
+
+	if rec := serve(e, "/healthz", ""); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("healthy probe was logged: %+v", logs.All())
+	}
+
+	if rec := serve(e, "/healthz?fail=1", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	entries := logs.All()
+	if len(entries) != 1 || entries[0].Level != zapcore.ErrorLevel {
+		t.Errorf("failed probe: got entries %+v", entries)
+	}
+}
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS posts_category_id_idx;
+
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS posts_category_id_fkey;
+ALTER TABLE posts
+    ADD CONSTRAINT posts_category_id_fkey
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE SET NULL;
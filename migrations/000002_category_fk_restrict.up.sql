@@ -0,0 +1,8 @@
+-- category_id is NOT NULL, so ON DELETE SET NULL could never succeed.
+-- Deleting a category now requires its posts to be reassigned first.
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS posts_category_id_fkey;
+ALTER TABLE posts
+    ADD CONSTRAINT posts_category_id_fkey
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE RESTRICT;
+
+CREATE INDEX IF NOT EXISTS posts_category_id_idx ON posts (category_id);
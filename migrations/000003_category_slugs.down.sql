@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS category_aliases;
+
+ALTER TABLE categories DROP CONSTRAINT IF EXISTS categories_slug_key;
+ALTER TABLE categories DROP COLUMN IF EXISTS slug;
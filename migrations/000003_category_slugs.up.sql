@@ -0,0 +1,18 @@
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+ALTER TABLE categories ADD COLUMN slug VARCHAR(120);
+UPDATE categories
+SET slug = trim(BOTH '-' FROM lower(regexp_replace(unaccent(name), '[^[:alnum:]]+', '-', 'g')));
+ALTER TABLE categories ALTER COLUMN slug SET NOT NULL;
+ALTER TABLE categories ADD CONSTRAINT categories_slug_key UNIQUE (slug);
+
+CREATE TABLE category_aliases (
+    id SERIAL PRIMARY KEY,
+    category_id INTEGER NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+    alias VARCHAR(100) NOT NULL,
+    slug VARCHAR(120) NOT NULL UNIQUE,
+    is_redirect BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX category_aliases_category_id_idx ON category_aliases (category_id);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS category_translations;
+
+ALTER TABLE categories
+    DROP COLUMN IF EXISTS description,
+    DROP COLUMN IF EXISTS icon,
+    DROP COLUMN IF EXISTS display_order,
+    DROP COLUMN IF EXISTS is_active;
@@ -0,0 +1,14 @@
+ALTER TABLE categories
+    ADD COLUMN description TEXT NOT NULL DEFAULT '',
+    ADD COLUMN icon VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN display_order INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN is_active BOOLEAN NOT NULL DEFAULT TRUE;
+
+CREATE TABLE category_translations (
+    id SERIAL PRIMARY KEY,
+    category_id INTEGER NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+    locale VARCHAR(10) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    UNIQUE (category_id, locale)
+);
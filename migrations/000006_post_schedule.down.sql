@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS posts_status_expires_at_idx;
+DROP INDEX IF EXISTS posts_status_publish_at_idx;
+
+ALTER TABLE posts
+    DROP COLUMN IF EXISTS expiry_notified_at,
+    DROP COLUMN IF EXISTS expires_at,
+    DROP COLUMN IF EXISTS publish_at,
+    DROP COLUMN IF EXISTS status;
@@ -0,0 +1,8 @@
+ALTER TABLE posts
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'live',
+    ADD COLUMN publish_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN expires_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN expiry_notified_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX posts_status_publish_at_idx ON posts (status, publish_at);
+CREATE INDEX posts_status_expires_at_idx ON posts (status, expires_at);
@@ -0,0 +1,15 @@
+CREATE TABLE pricing_rules (
+    id SERIAL PRIMARY KEY,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('date_range', 'weekday', 'duration')),
+    start_date DATE,
+    end_date DATE,
+    weekdays SMALLINT NOT NULL DEFAULT 0,
+    min_days INTEGER NOT NULL DEFAULT 0,
+    adjustment_type VARCHAR(10) NOT NULL CHECK (adjustment_type IN ('percent', 'absolute')),
+    adjustment NUMERIC(12, 2) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX pricing_rules_post_id_idx ON pricing_rules (post_id);
@@ -0,0 +1,16 @@
+ALTER TABLE pricing_rules
+    ALTER COLUMN adjustment TYPE NUMERIC(12, 2) USING adjustment / 100.0;
+
+ALTER TABLE posts ADD COLUMN price_per_day NUMERIC(10);
+
+UPDATE posts SET price_per_day = price_minor / POWER(10, CASE
+    WHEN currency IN ('JPY', 'KRW') THEN 0
+    WHEN currency IN ('BHD', 'IQD', 'KWD', 'OMR') THEN 3
+    ELSE 2
+END);
+
+ALTER TABLE posts
+    ALTER COLUMN price_per_day SET NOT NULL,
+    DROP CONSTRAINT IF EXISTS posts_price_minor_positive,
+    DROP COLUMN price_minor,
+    DROP COLUMN currency;
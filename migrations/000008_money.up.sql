@@ -0,0 +1,17 @@
+-- Prices were whole units with no scale. They become minor units of a
+-- currency per post; existing posts are assumed to be in USD (2 digits).
+ALTER TABLE posts
+    ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+    ADD COLUMN price_minor BIGINT;
+
+UPDATE posts SET price_minor = price_per_day * 100;
+
+ALTER TABLE posts
+    ALTER COLUMN price_minor SET NOT NULL,
+    ALTER COLUMN currency DROP DEFAULT,
+    DROP COLUMN price_per_day,
+    ADD CONSTRAINT posts_price_minor_positive CHECK (price_minor > 0);
+
+-- Percent adjustments become basis points and absolute ones minor units.
+ALTER TABLE pricing_rules
+    ALTER COLUMN adjustment TYPE BIGINT USING ROUND(adjustment * 100);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS user_blocks;
+DROP TABLE IF EXISTS contact_cards;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversations;
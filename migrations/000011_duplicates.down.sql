@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS moderation_flags;
+DROP TABLE IF EXISTS post_fingerprint_bands;
+DROP TABLE IF EXISTS post_fingerprints;
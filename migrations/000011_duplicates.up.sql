@@ -0,0 +1,28 @@
+CREATE TABLE post_fingerprints (
+    post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+    signature BYTEA NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE post_fingerprint_bands (
+    post_id INTEGER NOT NULL REFERENCES post_fingerprints(post_id) ON DELETE CASCADE,
+    band SMALLINT NOT NULL,
+    hash BIGINT NOT NULL,
+    PRIMARY KEY (post_id, band)
+);
+
+CREATE INDEX post_fingerprint_bands_hash_idx ON post_fingerprint_bands (band, hash);
+
+CREATE TABLE moderation_flags (
+    id SERIAL PRIMARY KEY,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    reason VARCHAR(50) NOT NULL,
+    related_post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+    score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A post is flagged once per reason and related post.
+CREATE UNIQUE INDEX moderation_flags_unique_idx ON moderation_flags (post_id, reason, COALESCE(related_post_id, 0));
+CREATE INDEX moderation_flags_status_idx ON moderation_flags (status, id);
@@ -0,0 +1,30 @@
+CREATE TABLE audit_entries (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INTEGER NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    target_type VARCHAR(20) NOT NULL,
+    target_id INTEGER NOT NULL,
+    before_data JSON,
+    after_data JSON,
+    request_id VARCHAR(100) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL UNIQUE
+);
+
+CREATE INDEX audit_entries_actor_idx ON audit_entries (actor_id, created_at);
+CREATE INDEX audit_entries_target_idx ON audit_entries (target_type, target_id, created_at);
+CREATE INDEX audit_entries_created_at_idx ON audit_entries (created_at);
+
+-- Entries are never changed or removed; the hash chain shows it if they are.
+CREATE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_entries is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_entries_no_update BEFORE UPDATE OR DELETE ON audit_entries
+    FOR EACH ROW EXECUTE FUNCTION audit_entries_append_only();
+CREATE TRIGGER audit_entries_no_truncate BEFORE TRUNCATE ON audit_entries
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_entries_append_only();
@@ -0,0 +1,163 @@
+package moderation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	RuleEmail = "email"
+	RulePhone = "phone"
+	RuleURL   = "url"
+	RuleLinks = "link_limit"
+
+	// minPhoneDigits keeps prices, dates and years from reading as phone
+	// numbers.
+	minPhoneDigits = 9
+)
+
+var (
+	emailPattern = regexp.MustCompile(`(?i)[a-z0-9._%+\-]+@[a-z0-9\-]+(?:\.[a-z0-9\-]+)*\.[a-z]{2,}`)
+	phonePattern = regexp.MustCompile(`\+?\d[\d ().\-]{6,}\d`)
+	urlPattern   = regexp.MustCompile(`(?i)(?:https?://|www\.)[^\s<>"']+|\b[a-z0-9][a-z0-9\-]*(?:\.[a-z0-9\-]+)*\.(?:com|net|org|info|biz|io|co|me|ru|xyz|link|site|online|app)\b(?:/[^\s<>"']*)?`)
+)
+
+// PatternCheck matches a regular expression. accept may reject or trim a
+// match, given the text and the match's offsets.
+type PatternCheck struct {
+	rule    string
+	action  Action
+	reason  string
+	pattern *regexp.Regexp
+	accept  func(text string, start, end int) (int, int, bool)
+}
+
+func NewPatternCheck(rule string, action Action, reason string, pattern *regexp.Regexp) *PatternCheck {
+	return &PatternCheck{rule: rule, action: action, reason: reason, pattern: pattern}
+}
+
+// NewEmailCheck matches email addresses.
+func NewEmailCheck(action Action) *PatternCheck {
+	return NewPatternCheck(RuleEmail, action, "contains an email address", emailPattern)
+}
+
+// NewPhoneCheck matches runs of at least minPhoneDigits digits, allowing the
+// separators phone numbers are written with.
+func NewPhoneCheck(action Action) *PatternCheck {
+	check := NewPatternCheck(RulePhone, action, "contains a phone number", phonePattern)
+	check.accept = func(text string, start, end int) (int, int, bool) {
+		digits := 0
+		for _, r := range text[start:end] {
+			if r >= '0' && r <= '9' {
+				digits++
+			}
+		}
+		return start, end, digits >= minPhoneDigits
+	}
+	return check
+}
+
+// NewURLCheck matches links, with or without a scheme.
+func NewURLCheck(action Action) *PatternCheck {
+	check := NewPatternCheck(RuleURL, action, "contains a link", urlPattern)
+	check.accept = acceptURL
+	return check
+}
+
+// acceptURL leaves out the domains of email addresses and trims punctuation
+// that ends the sentence rather than the link.
+func acceptURL(text string, start, end int) (int, int, bool) {
+	if start > 0 && (text[start-1] == '@' || text[start-1] == '.') {
+		return 0, 0, false
+	}
+	end = start + len(strings.TrimRight(text[start:end], ".,;:!?)"))
+	return start, end, end > start
+}
+
+func (check *PatternCheck) Find(text string) []Finding {
+	var findings []Finding
+	for _, match := range check.pattern.FindAllStringIndex(text, -1) {
+		start, end, ok := match[0], match[1], true
+		if check.accept != nil {
+			start, end, ok = check.accept(text, start, end)
+		}
+		if ok {
+			findings = append(findings, Finding{Rule: check.rule, Action: check.action, Reason: check.reason, Start: start, End: end})
+		}
+	}
+	return findings
+}
+
+// LinkLimit matches the links in a text beyond the first Max.
+type LinkLimit struct {
+	Max    int
+	action Action
+	links  *PatternCheck
+}
+
+func NewLinkLimit(maxLinks int, action Action) *LinkLimit {
+	return &LinkLimit{Max: maxLinks, action: action, links: NewURLCheck(action)}
+}
+
+func (limit *LinkLimit) Find(text string) []Finding {
+	links := limit.links.Find(text)
+	if len(links) <= limit.Max {
+		return nil
+	}
+	reason := fmt.Sprintf("contains more than %d links", limit.Max)
+	findings := make([]Finding, 0, len(links)-limit.Max)
+	for _, link := range links[limit.Max:] {
+		findings = append(findings, Finding{Rule: RuleLinks, Action: limit.action, Reason: reason, Start: link.Start, End: link.End})
+	}
+	return findings
+}
+
+// WordList matches whole words and phrases from a list, ignoring case.
+type WordList struct {
+	name    string
+	action  Action
+	reason  string
+	pattern *regexp.Regexp
+}
+
+// NewWordList builds a check named after the list. An empty reason gets a
+// generic one.
+func NewWordList(name string, action Action, reason string, words []string) *WordList {
+	if reason == "" {
+		reason = "contains language that is not allowed"
+	}
+	list := &WordList{name: name, action: action, reason: reason}
+
+	var quoted []string
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return list
+	}
+	// Longest first, so that a word is not cut short by its prefix.
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	list.pattern = regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}])(` + strings.Join(quoted, "|") + `)`)
+	return list
+}
+
+func (list *WordList) Find(text string) []Finding {
+	if list.pattern == nil {
+		return nil
+	}
+	var findings []Finding
+	for _, match := range list.pattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[2], match[3]
+		if next, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && (unicode.IsLetter(next) || unicode.IsDigit(next)) {
+			continue
+		}
+		findings = append(findings, Finding{Rule: list.name, Action: list.action, Reason: list.reason, Start: start, End: end})
+	}
+	return findings
+}
@@ -0,0 +1,58 @@
+package moderation
+
+import (
+	"context"
+	"post-service/database"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	StatusOpen     = "open"
+	StatusResolved = "resolved"
+
+	// ReasonDuplicate flags a post nearly identical to another owner's post.
+	ReasonDuplicate = "cross_owner_duplicate"
+)
+
+// Flag puts a post in the moderation queue for a person to review.
+type Flag struct {
+	ID     uint
+	PostID uint
+	Reason string
+	// RelatedPostID is the other post involved, e.g. the one duplicated.
+	RelatedPostID *uint
+	// Score is how strongly the check matched, between 0 and 1.
+	Score     float64
+	Status    string
+	CreatedAt time.Time
+}
+
+func (Flag) TableName() string {
+	return "moderation_flags"
+}
+
+type FlagRepository struct {
+	db       *gorm.DB
+	timeouts *database.QueryTimeouts
+}
+
+func NewFlagRepository(db *gorm.DB, timeouts *database.QueryTimeouts) *FlagRepository {
+	return &FlagRepository{db: db, timeouts: timeouts}
+}
+
+// ListFlags returns flags with the given status, or all flags when status is
+// empty, oldest first.
+func (repo *FlagRepository) ListFlags(ctx context.Context, status string, offset, limit int) ([]Flag, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "FlagRepository.ListFlags")
+	defer cancel()
+
+	query := repo.db.WithContext(ctx).Model(&Flag{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var flags []Flag
+	err := query.Order("id").Offset(offset).Limit(limit).Find(&flags).Error
+	return flags, err
+}
@@ -0,0 +1,23 @@
+package moderation
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ModerationHandler struct {
+	service *ModerationService
+}
+
+func NewModerationHandler(service *ModerationService) *ModerationHandler {
+	return &ModerationHandler{service: service}
+}
+
+func (handler *ModerationHandler) ListFlags(c echo.Context) error {
+	flags, err := handler.service.ListFlags(c.Request().Context(), c.QueryParam("status"), c.QueryParam("page"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, flags)
+}
@@ -0,0 +1,71 @@
+package moderation
+
+import (
+	"context"
+	"post-service/apperr"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("post-service/moderation")
+
+const flagPageSize = 50
+
+var ErrInvalidStatus = apperr.Validation("invalid_status", "status must be open or resolved",
+	apperr.FieldError{Field: "status", Rule: "oneof", Message: "status must be open or resolved"})
+
+type FlagResponse struct {
+	ID            uint      `json:"id"`
+	PostID        uint      `json:"postId"`
+	Reason        string    `json:"reason"`
+	RelatedPostID *uint     `json:"relatedPostId,omitempty"`
+	Score         float64   `json:"score"`
+	Status        string    `json:"status"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+type ModerationService struct {
+	repo *FlagRepository
+}
+
+func NewModerationService(repo *FlagRepository) *ModerationService {
+	return &ModerationService{repo: repo}
+}
+
+// ListFlags pages through the queue, open flags unless status says otherwise.
+func (service *ModerationService) ListFlags(ctx context.Context, status, pageStr string) ([]FlagResponse, error) {
+	ctx, span := tracer.Start(ctx, "ModerationService.ListFlags")
+	defer span.End()
+
+	switch status {
+	case "":
+		status = StatusOpen
+	case StatusOpen, StatusResolved:
+	default:
+		return nil, ErrInvalidStatus
+	}
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	flags, err := service.repo.ListFlags(ctx, status, (page-1)*flagPageSize, flagPageSize)
+	if err != nil {
+		return nil, err
+	}
+	responses := []FlagResponse{}
+	for _, flag := range flags {
+		responses = append(responses, FlagResponse{
+			ID:            flag.ID,
+			PostID:        flag.PostID,
+			Reason:        flag.Reason,
+			RelatedPostID: flag.RelatedPostID,
+			Score:         flag.Score,
+			Status:        flag.Status,
+			CreatedAt:     flag.CreatedAt,
+		})
+	}
+	return responses, nil
+}
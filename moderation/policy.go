@@ -0,0 +1,163 @@
+package moderation
+
+import (
+	"fmt"
+	"post-service/apperr"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Action is what happens to content a check matches.
+type Action string
+
+const (
+	// ActionFlag keeps the content and queues the post for review.
+	ActionFlag Action = "flag"
+	// ActionMask replaces the matched text with asterisks.
+	ActionMask Action = "mask"
+	// ActionBlock rejects the change.
+	ActionBlock Action = "block"
+)
+
+func (action Action) valid() bool {
+	return action == ActionFlag || action == ActionMask || action == ActionBlock
+}
+
+// Finding is one match of a check in a text.
+type Finding struct {
+	Rule   string
+	Action Action
+	// Reason tells the owner what was found, e.g. "contains a phone number".
+	Reason string
+	// Start and End are the byte offsets of the match.
+	Start, End int
+}
+
+// Check looks for one kind of unwanted content. New kinds are added to a
+// Policy by implementing it.
+type Check interface {
+	Find(text string) []Finding
+}
+
+// Field is a piece of post text for a Policy to review. Masking rewrites
+// Text in place.
+type Field struct {
+	Name string
+	Text *string
+}
+
+// Violation is a rule that matched a field, once however often it matched.
+type Violation struct {
+	Field  string `json:"field"`
+	Rule   string `json:"rule"`
+	Action Action `json:"action"`
+	Reason string `json:"reason"`
+}
+
+// Verdict is the outcome of reviewing post text against a Policy.
+type Verdict struct {
+	Blocked []Violation
+	Masked  []Violation
+	Flagged []Violation
+}
+
+// Err returns the validation error listing every blocking violation, or nil
+// if the text may be saved.
+func (verdict *Verdict) Err() error {
+	if len(verdict.Blocked) == 0 {
+		return nil
+	}
+	fields := make([]apperr.FieldError, 0, len(verdict.Blocked))
+	for _, violation := range verdict.Blocked {
+		fields = append(fields, apperr.FieldError{Field: violation.Field, Rule: violation.Rule, Message: violation.Reason})
+	}
+	return apperr.Validation("content_policy", "the post breaks the content policy", fields...)
+}
+
+// Flags returns a moderation flag for each rule that flagged the text.
+func (verdict *Verdict) Flags() []Flag {
+	var flags []Flag
+	seen := map[string]bool{}
+	for _, violation := range verdict.Flagged {
+		if seen[violation.Rule] {
+			continue
+		}
+		seen[violation.Rule] = true
+		flags = append(flags, Flag{Reason: ContentReason(violation.Rule), Score: 1, Status: StatusOpen})
+	}
+	return flags
+}
+
+// Policy runs every check over post text.
+type Policy struct {
+	checks []Check
+}
+
+func NewPolicy(checks ...Check) *Policy {
+	return &Policy{checks: checks}
+}
+
+// Review runs the checks over each field and masks what they say to mask.
+// Nothing is masked if the verdict blocks the change.
+func (policy *Policy) Review(fields ...Field) *Verdict {
+	verdict := &Verdict{}
+	masks := make([][]Finding, len(fields))
+	for i, field := range fields {
+		seen := map[string]bool{}
+		for _, check := range policy.checks {
+			for _, finding := range check.Find(*field.Text) {
+				if finding.Action == ActionMask {
+					masks[i] = append(masks[i], finding)
+				}
+				if seen[finding.Rule] {
+					continue
+				}
+				seen[finding.Rule] = true
+				violation := Violation{Field: field.Name, Rule: finding.Rule, Action: finding.Action, Reason: finding.Reason}
+				switch finding.Action {
+				case ActionBlock:
+					verdict.Blocked = append(verdict.Blocked, violation)
+				case ActionMask:
+					verdict.Masked = append(verdict.Masked, violation)
+				default:
+					verdict.Flagged = append(verdict.Flagged, violation)
+				}
+			}
+		}
+	}
+
+	if len(verdict.Blocked) == 0 {
+		for i, field := range fields {
+			*field.Text = mask(*field.Text, masks[i])
+		}
+	}
+	return verdict
+}
+
+// mask replaces the text of each finding with one asterisk per rune.
+func mask(text string, findings []Finding) string {
+	if len(findings) == 0 {
+		return text
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Start < findings[j].Start })
+
+	var b strings.Builder
+	last := 0
+	for _, finding := range findings {
+		start := max(finding.Start, last)
+		if finding.End <= start {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(text[start:finding.End])))
+		last = finding.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// ContentReason is the flag reason for content matched by rule.
+func ContentReason(rule string) string {
+	return fmt.Sprintf("content_%s", rule)
+}
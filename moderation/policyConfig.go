@@ -0,0 +1,148 @@
+package moderation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// actionOff turns a built-in detector off in CONTENT_POLICY_FILE.
+const actionOff = "off"
+
+type wordListFile struct {
+	Name   string   `json:"name"`
+	Action Action   `json:"action"`
+	Reason string   `json:"reason"`
+	Words  []string `json:"words"`
+	// File holds more words, one per line.
+	File string `json:"file"`
+}
+
+type linksFile struct {
+	Max    int    `json:"max"`
+	Action Action `json:"action"`
+}
+
+type policyFile struct {
+	WordLists []wordListFile    `json:"wordLists"`
+	Detectors map[string]Action `json:"detectors"`
+	Links     *linksFile        `json:"links"`
+}
+
+// NewContentPolicy builds the policy that post text is reviewed against. By
+// default emails and phone numbers are masked, links are flagged and more
+// than two links block the post. CONTENT_POLICY_FILE changes that with a
+// JSON file such as
+//
+//	{"wordLists": [{"name": "profanity", "action": "block", "file": "/etc/post-service/profanity.txt"}],
+//	 "detectors": {"email": "block", "phone": "mask", "url": "off"},
+//	 "links": {"max": 3, "action": "flag"}}
+//
+// where actions are block, mask, flag or, for detectors, off.
+func NewContentPolicy() (*Policy, error) {
+	file := policyFile{
+		Detectors: map[string]Action{RuleEmail: ActionMask, RulePhone: ActionMask, RuleURL: ActionFlag},
+		Links:     &linksFile{Max: 2, Action: ActionBlock},
+	}
+
+	if path := os.Getenv("CONTENT_POLICY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading CONTENT_POLICY_FILE: %w", err)
+		}
+		if err := file.load(data); err != nil {
+			return nil, fmt.Errorf("invalid CONTENT_POLICY_FILE %s: %w", path, err)
+		}
+	}
+
+	checks, err := file.checks()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONTENT_POLICY_FILE: %w", err)
+	}
+	return NewPolicy(checks...), nil
+}
+
+// load applies a policy file over the defaults. Detectors it leaves out
+// keep their default action.
+func (file *policyFile) load(data []byte) error {
+	var loaded policyFile
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return err
+	}
+	file.WordLists = loaded.WordLists
+	for rule, action := range loaded.Detectors {
+		file.Detectors[rule] = action
+	}
+	if loaded.Links != nil {
+		file.Links = loaded.Links
+	}
+	return nil
+}
+
+func (file *policyFile) checks() ([]Check, error) {
+	var checks []Check
+	for _, entry := range file.WordLists {
+		list, err := entry.wordList()
+		if err != nil {
+			return nil, fmt.Errorf("word list %q: %w", entry.Name, err)
+		}
+		checks = append(checks, list)
+	}
+
+	detectors := map[string]func(Action) *PatternCheck{
+		RuleEmail: NewEmailCheck,
+		RulePhone: NewPhoneCheck,
+		RuleURL:   NewURLCheck,
+	}
+	for _, rule := range []string{RuleEmail, RulePhone, RuleURL} {
+		action := file.Detectors[rule]
+		if action == actionOff {
+			continue
+		}
+		if !action.valid() {
+			return nil, fmt.Errorf("detector %q: unknown action %q", rule, action)
+		}
+		checks = append(checks, detectors[rule](action))
+	}
+	for rule := range file.Detectors {
+		if _, ok := detectors[rule]; !ok {
+			return nil, fmt.Errorf("unknown detector %q", rule)
+		}
+	}
+
+	if file.Links != nil && file.Links.Action != actionOff {
+		if file.Links.Max < 0 {
+			return nil, fmt.Errorf("links: max must not be negative")
+		}
+		if !file.Links.Action.valid() {
+			return nil, fmt.Errorf("links: unknown action %q", file.Links.Action)
+		}
+		checks = append(checks, NewLinkLimit(file.Links.Max, file.Links.Action))
+	}
+	return checks, nil
+}
+
+// wordList builds the list. Names are kept short to fit the flag reasons
+// made from them.
+func (entry wordListFile) wordList() (*WordList, error) {
+	if entry.Name == "" || len(entry.Name) > 40 {
+		return nil, fmt.Errorf("name must be 1 to 40 characters")
+	}
+	if !entry.Action.valid() {
+		return nil, fmt.Errorf("unknown action %q", entry.Action)
+	}
+	words := entry.Words
+	if entry.File != "" {
+		data, err := os.ReadFile(entry.File)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				words = append(words, line)
+			}
+		}
+	}
+	return NewWordList(entry.Name, entry.Action, entry.Reason, words), nil
+}
@@ -0,0 +1,121 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// review runs policy over a description and describes the verdict as
+// blocked/masked/flagged rule lists and the resulting text.
+func review(policy *Policy, text string) string {
+	verdict := policy.Review(Field{Name: "description", Text: &text})
+	return strings.Join([]string{rules(verdict.Blocked), rules(verdict.Masked), rules(verdict.Flagged), text}, " | ")
+}
+
+func writePolicyFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestContentPolicyDefaults(t *testing.T) {
+	t.Setenv("CONTENT_POLICY_FILE", "")
+	policy, err := NewContentPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Mail a@b.com", " | description:email |  | Mail *******"},
+		{"Call 0912 345 6789", " | description:phone |  | Call *************"},
+		{"See tools.com and drills.net", " |  | description:url | See tools.com and drills.net"},
+		{"a.com b.com c.com", "description:link_limit |  | description:url | a.com b.com c.com"},
+	}
+	for _, test := range tests {
+		if got := review(policy, test.text); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestContentPolicyFile(t *testing.T) {
+	words := writePolicyFile(t, "words.txt", "# payment scams\nwire transfer\n\n  gift card  \n")
+	config := `{
+		"wordLists": [
+			{"name": "payment", "action": "block", "reason": "asks for an unsafe payment", "file": "` + words + `"},
+			{"name": "hype", "action": "flag", "words": ["best deal"]}
+		],
+		"detectors": {"email": "block", "url": "off"},
+		"links": {"max": 1, "action": "flag"}
+	}`
+	t.Setenv("CONTENT_POLICY_FILE", writePolicyFile(t, "policy.json", config))
+	policy, err := NewContentPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Pay by gift card", "description:payment |  |  | Pay by gift card"},
+		{"Best deal in town", " |  | description:hype | Best deal in town"},
+		{"Mail a@b.com", "description:email |  |  | Mail a@b.com"},
+		// Left out of the file, phones keep their default.
+		{"Call 0912 345 6789", " | description:phone |  | Call *************"},
+		// URLs are off, but the link limit still counts links.
+		{"tools.com", " |  |  | tools.com"},
+		{"tools.com drills.net", " |  | description:link_limit | tools.com drills.net"},
+	}
+	for _, test := range tests {
+		if got := review(policy, test.text); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestContentPolicyFileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"malformed", `{"detectors": [}`, "invalid CONTENT_POLICY_FILE"},
+		{"unknown detector", `{"detectors": {"fax": "block"}}`, `unknown detector "fax"`},
+		{"unknown detector action", `{"detectors": {"email": "delete"}}`, `unknown action "delete"`},
+		{"unnamed word list", `{"wordLists": [{"action": "block", "words": ["x"]}]}`, "name must be 1 to 40 characters"},
+		{"word list action", `{"wordLists": [{"name": "x", "action": "off", "words": ["x"]}]}`, `unknown action "off"`},
+		{"missing word file", `{"wordLists": [{"name": "x", "action": "block", "file": "/nonexistent/words.txt"}]}`, "no such file"},
+		{"negative link limit", `{"links": {"max": -1, "action": "block"}}`, "max must not be negative"},
+		{"link action", `{"links": {"max": 1, "action": "warn"}}`, `unknown action "warn"`},
+	}
+	for _, test := range tests {
+		t.Setenv("CONTENT_POLICY_FILE", writePolicyFile(t, "policy.json", test.config))
+		if _, err := NewContentPolicy(); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error containing %q", test.name, err, test.want)
+		}
+	}
+
+	t.Setenv("CONTENT_POLICY_FILE", filepath.Join(t.TempDir(), "missing.json"))
+	if _, err := NewContentPolicy(); err == nil {
+		t.Error("a missing policy file loaded")
+	}
+
+	// Links can be turned off entirely.
+	t.Setenv("CONTENT_POLICY_FILE", writePolicyFile(t, "policy.json", `{"links": {"action": "off"}}`))
+	policy, err := NewContentPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := review(policy, "a.com b.com c.com"), " |  | description:url | a.com b.com c.com"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
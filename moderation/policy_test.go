@@ -0,0 +1,145 @@
+package moderation
+
+import (
+	"post-service/apperr"
+	"strings"
+	"testing"
+)
+
+// rules lists the rules of violations as field:rule.
+func rules(violations []Violation) string {
+	var named []string
+	for _, violation := range violations {
+		named = append(named, violation.Field+":"+violation.Rule)
+	}
+	return strings.Join(named, ",")
+}
+
+func TestChecks(t *testing.T) {
+	tests := []struct {
+		check Check
+		text  string
+		want  []string
+	}{
+		{NewEmailCheck(ActionMask), "Mail owner.name+rent@example.co.uk today", []string{"owner.name+rent@example.co.uk"}},
+		{NewEmailCheck(ActionMask), "Reply here, not by @mail", nil},
+		{NewPhoneCheck(ActionMask), "Call +1 (555) 123-4567 or 0912 345 6789", []string{"+1 (555) 123-4567", "0912 345 6789"}},
+		{NewPhoneCheck(ActionMask), "Since 2019, 12.50 per day, 10-20 days", nil},
+		{NewURLCheck(ActionFlag), "See https://example.com/drill, or www.tools.net.", []string{"https://example.com/drill", "www.tools.net"}},
+		{NewURLCheck(ActionFlag), "Visit rent-tools.io/drill!", []string{"rent-tools.io/drill"}},
+		{NewURLCheck(ActionFlag), "Mail me at owner@example.com", nil},
+		{NewLinkLimit(1, ActionBlock), "a.com b.com c.com", []string{"b.com", "c.com"}},
+		{NewLinkLimit(2, ActionBlock), "a.com b.com", nil},
+		{NewWordList("banned", ActionBlock, "", []string{"scam", "wire transfer"}), "No SCAM, pay by Wire Transfer.", []string{"SCAM", "Wire Transfer"}},
+		{NewWordList("banned", ActionBlock, "", []string{"scam"}), "Not scammy, scam2 or ascam", nil},
+		{NewWordList("banned", ActionBlock, "", []string{"خطر"}), "این ابزار خطر دارد", []string{"خطر"}},
+		{NewWordList("empty", ActionBlock, "", []string{" ", ""}), "anything", nil},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, finding := range test.check.Find(test.text) {
+			got = append(got, test.text[finding.Start:finding.End])
+		}
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%T in %q: got %q, want %q", test.check, test.text, got, test.want)
+		}
+	}
+}
+
+func TestPolicyReview(t *testing.T) {
+	policy := NewPolicy(
+		NewWordList("banned", ActionBlock, "mentions a banned payment method", []string{"wire transfer"}),
+		NewEmailCheck(ActionMask),
+		NewPhoneCheck(ActionMask),
+		NewURLCheck(ActionFlag),
+		NewLinkLimit(2, ActionBlock),
+	)
+
+	tests := []struct {
+		name                     string
+		title, description       string
+		blocked, masked, flagged string
+		wantDescription          string
+	}{
+		{
+			name: "clean", title: "Cordless drill", description: "Two batteries, 12.50 a day",
+			wantDescription: "Two batteries, 12.50 a day",
+		},
+		{
+			name: "masked", title: "Drill", description: "Mail a@b.com or b@c.com, call 555 123 4567",
+			masked:          "description:email,description:phone",
+			wantDescription: "Mail ******* or *******, call ************",
+		},
+		{
+			name: "flagged", title: "Drill", description: "Manual at tools.com",
+			flagged:         "description:url",
+			wantDescription: "Manual at tools.com",
+		},
+		{
+			name: "blocked leaves the text alone", title: "Wire transfer only", description: "Mail a@b.com",
+			blocked: "title:banned", masked: "description:email",
+			wantDescription: "Mail a@b.com",
+		},
+		{
+			name: "too many links", title: "Drill", description: "a.com b.com c.com",
+			blocked: "description:link_limit", flagged: "description:url",
+			wantDescription: "a.com b.com c.com",
+		},
+	}
+	for _, test := range tests {
+		title, description := test.title, test.description
+		verdict := policy.Review(Field{Name: "title", Text: &title}, Field{Name: "description", Text: &description})
+		if got := rules(verdict.Blocked); got != test.blocked {
+			t.Errorf("%s: got blocked %q, want %q", test.name, got, test.blocked)
+		}
+		if got := rules(verdict.Masked); got != test.masked {
+			t.Errorf("%s: got masked %q, want %q", test.name, got, test.masked)
+		}
+		if got := rules(verdict.Flagged); got != test.flagged {
+			t.Errorf("%s: got flagged %q, want %q", test.name, got, test.flagged)
+		}
+		if description != test.wantDescription {
+			t.Errorf("%s: got description %q, want %q", test.name, description, test.wantDescription)
+		}
+		if (verdict.Err() != nil) != (test.blocked != "") {
+			t.Errorf("%s: got error %v", test.name, verdict.Err())
+		}
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	verdict := &Verdict{
+		Blocked: []Violation{{Field: "title", Rule: "banned", Action: ActionBlock, Reason: "mentions a banned payment method"}},
+		Flagged: []Violation{
+			{Field: "title", Rule: RuleURL, Action: ActionFlag},
+			{Field: "description", Rule: RuleURL, Action: ActionFlag},
+			{Field: "description", Rule: "spam", Action: ActionFlag},
+		},
+	}
+	appErr, ok := apperr.As(verdict.Err())
+	if !ok || appErr.Code != "content_policy" || len(appErr.Fields) != 1 || appErr.Fields[0].Field != "title" || appErr.Fields[0].Rule != "banned" {
+		t.Errorf("got %+v", verdict.Err())
+	}
+
+	flags := verdict.Flags()
+	if len(flags) != 2 || flags[0].Reason != "content_url" || flags[1].Reason != "content_spam" || flags[0].Status != StatusOpen {
+		t.Errorf("got flags %+v", flags)
+	}
+
+	if err := (&Verdict{Flagged: verdict.Flagged}).Err(); err != nil {
+		t.Errorf("a verdict without blocks returned %v", err)
+	}
+}
+
+func TestMask(t *testing.T) {
+	text := "call 0912 or mail ü@x.io"
+	findings := []Finding{
+		{Start: 18, End: 25},
+		{Start: 5, End: 9},
+		// Overlaps the finding before it.
+		{Start: 7, End: 11},
+	}
+	if got, want := mask(text, findings), "call ******r mail ******"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
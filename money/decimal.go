@@ -0,0 +1,190 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidDecimal = errors.New("invalid decimal number")
+var ErrTooPrecise = errors.New("too many decimal places")
+var ErrOutOfRange = errors.New("number out of range")
+
+// maxDigits keeps every coefficient, and every coefficient rescaled to a
+// currency's minor units, inside an int64.
+const maxDigits = 15
+
+// Decimal is an exact base-10 number: coef × 10^-scale. Prices are parsed into
+// a Decimal so that "19.99" is never rounded through a float.
+type Decimal struct {
+	coef  int64
+	scale int
+}
+
+func NewDecimal(coef int64, scale int) Decimal {
+	return Decimal{coef: coef, scale: scale}
+}
+
+// ParseDecimal parses a plain decimal such as "-12.50". Exponents, thousand
+// separators and more than 15 significant digits are rejected.
+func ParseDecimal(value string) (Decimal, error) {
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	if negative || strings.HasPrefix(value, "+") {
+		value = value[1:]
+	}
+
+	whole, fraction, hasPoint := strings.Cut(value, ".")
+	if whole == "" && fraction == "" || hasPoint && fraction == "" {
+		return Decimal{}, ErrInvalidDecimal
+	}
+	digits := strings.TrimLeft(whole+fraction, "0")
+	if len(digits) > maxDigits {
+		return Decimal{}, ErrOutOfRange
+	}
+	for _, r := range whole + fraction {
+		if r < '0' || r > '9' {
+			return Decimal{}, ErrInvalidDecimal
+		}
+	}
+
+	var coef int64
+	if digits != "" {
+		var err error
+		if coef, err = strconv.ParseInt(digits, 10, 64); err != nil {
+			return Decimal{}, ErrOutOfRange
+		}
+	}
+	if negative {
+		coef = -coef
+	}
+	return Decimal{coef: coef, scale: len(fraction)}.trim(), nil
+}
+
+// trim drops trailing zeros after the point.
+func (d Decimal) trim() Decimal {
+	for d.scale > 0 && d.coef%10 == 0 {
+		d.coef /= 10
+		d.scale--
+	}
+	return d
+}
+
+func (d Decimal) IsZero() bool { return d.coef == 0 }
+
+func (d Decimal) Sign() int {
+	switch {
+	case d.coef > 0:
+		return 1
+	case d.coef < 0:
+		return -1
+	}
+	return 0
+}
+
+// Scaled returns d × 10^exponent, failing if that is not a whole number.
+func (d Decimal) Scaled(exponent int) (int64, error) {
+	d = d.trim()
+	if d.scale > exponent {
+		return 0, ErrTooPrecise
+	}
+	value := d.coef
+	for i := d.scale; i < exponent; i++ {
+		if value > math.MaxInt64/10 || value < math.MinInt64/10 {
+			return 0, ErrOutOfRange
+		}
+		value *= 10
+	}
+	return value, nil
+}
+
+func (d Decimal) Rat() *big.Rat {
+	denominator := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.scale)), nil)
+	return new(big.Rat).SetFrac(big.NewInt(d.coef), denominator)
+}
+
+// Float64 approximates d. It is meant for validation rules, not arithmetic.
+func (d Decimal) Float64() float64 {
+	f, _ := d.Rat().Float64()
+	return f
+}
+
+func (d Decimal) String() string {
+	digits := strconv.FormatInt(d.coef, 10)
+	sign := ""
+	if d.coef < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	if d.scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= d.scale {
+		digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+	}
+	point := len(digits) - d.scale
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// MarshalJSON writes d as a JSON number with its exact digits.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding one.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*d = Decimal{}
+		return nil
+	}
+	value := string(data)
+	if strings.HasPrefix(value, `"`) {
+		if err := json.Unmarshal(data, &value); err != nil {
+			return ErrInvalidDecimal
+		}
+	}
+	parsed, err := ParseDecimal(value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// roundRat rounds r to the nearest integer, halves away from zero, or to the
+// integer above or below it when up or down is set.
+func roundRat(r *big.Rat, mode roundMode) (int64, bool) {
+	quotient, remainder := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if remainder.Sign() != 0 {
+		switch mode {
+		case roundUp:
+			if r.Sign() > 0 {
+				quotient.Add(quotient, big.NewInt(1))
+			}
+		case roundDown:
+			if r.Sign() < 0 {
+				quotient.Sub(quotient, big.NewInt(1))
+			}
+		default:
+			doubled := new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2))
+			if doubled.Cmp(r.Denom()) >= 0 {
+				quotient.Add(quotient, big.NewInt(int64(r.Sign())))
+			}
+		}
+	}
+	if !quotient.IsInt64() {
+		return 0, false
+	}
+	return quotient.Int64(), true
+}
+
+type roundMode int
+
+const (
+	roundNearest roundMode = iota
+	roundUp
+	roundDown
+)
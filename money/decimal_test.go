@@ -0,0 +1,143 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		err   error
+	}{
+		{"12.50", "12.5", nil},
+		{"19.99", "19.99", nil},
+		{"-12.5", "-12.5", nil},
+		{"+3", "3", nil},
+		{" 7 ", "7", nil},
+		{".5", "0.5", nil},
+		{"0.00", "0", nil},
+		{"000123.4500", "123.45", nil},
+		{"999999999999999", "999999999999999", nil},
+		{"0.000000000000001", "0.000000000000001", nil},
+		{"", "", ErrInvalidDecimal},
+		{"-", "", ErrInvalidDecimal},
+		{".", "", ErrInvalidDecimal},
+		{"12.", "", ErrInvalidDecimal},
+		{"-+5", "", ErrInvalidDecimal},
+		{"--5", "", ErrInvalidDecimal},
+		{"1e3", "", ErrInvalidDecimal},
+		{"1,000", "", ErrInvalidDecimal},
+		{"12.5.0", "", ErrInvalidDecimal},
+		{"NaN", "", ErrInvalidDecimal},
+		{"1000000000000000", "", ErrOutOfRange},
+		{"0.0000000000000001", "0.0000000000000001", nil},
+	}
+	for _, test := range tests {
+		d, err := ParseDecimal(test.value)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%q: got error %v, want %v", test.value, err, test.err)
+			continue
+		}
+		if err == nil && d.String() != test.want {
+			t.Errorf("%q: got %s, want %s", test.value, d, test.want)
+		}
+	}
+}
+
+func TestDecimalScaled(t *testing.T) {
+	tests := []struct {
+		value    string
+		exponent int
+		want     int64
+		err      error
+	}{
+		{"12.5", 2, 1250, nil},
+		{"12.5", 1, 125, nil},
+		{"-0.01", 2, -1, nil},
+		{"12.50", 0, 0, ErrTooPrecise},
+		{"1500", 0, 1500, nil},
+		{"0.125", 2, 0, ErrTooPrecise},
+		{"0.125", 3, 125, nil},
+		{"999999999999999", 3, 999999999999999000, nil},
+		{"999999999999999", 5, 0, ErrOutOfRange},
+		{"-999999999999999", 5, 0, ErrOutOfRange},
+	}
+	for _, test := range tests {
+		d, err := ParseDecimal(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.Scaled(test.exponent)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Errorf("%s scaled by %d: got %d, %v, want %d, %v", test.value, test.exponent, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestDecimalString(t *testing.T) {
+	tests := []struct {
+		d    Decimal
+		want string
+	}{
+		{NewDecimal(1250, 2), "12.50"},
+		{NewDecimal(5, 3), "0.005"},
+		{NewDecimal(-5, 3), "-0.005"},
+		{NewDecimal(-1250, 2), "-12.50"},
+		{NewDecimal(0, 2), "0.00"},
+		{NewDecimal(42, 0), "42"},
+	}
+	for _, test := range tests {
+		if got := test.d.String(); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Price Decimal `json:"price"`
+	}{NewDecimal(1250, 2)})
+	if err != nil || string(data) != `{"price":12.50}` {
+		t.Errorf("got %s, %v", data, err)
+	}
+
+	tests := []struct {
+		data string
+		want string
+		ok   bool
+	}{
+		{`12.5`, "12.5", true},
+		{`"12.5"`, "12.5", true},
+		{`"-0.01"`, "-0.01", true},
+		{`null`, "0", true},
+		{`"12.5`, "", false},
+		{`12.5"`, "", false},
+		{`""12""`, "", false},
+		{`"\"12\""`, "", false},
+		{`""`, "", false},
+		{`"abc"`, "", false},
+		{`1e2`, "", false},
+		{`true`, "", false},
+	}
+	for _, test := range tests {
+		d := NewDecimal(99, 0)
+		err := d.UnmarshalJSON([]byte(test.data))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.data, err)
+			continue
+		}
+		if test.ok && d.String() != test.want {
+			t.Errorf("%s: got %s, want %s", test.data, d, test.want)
+		}
+	}
+
+	var dto struct {
+		Price *Decimal `json:"price"`
+	}
+	if err := json.Unmarshal([]byte(`{"price": "19.99"}`), &dto); err != nil || dto.Price == nil || dto.Price.String() != "19.99" {
+		t.Errorf("got %v, %v", dto.Price, err)
+	}
+}
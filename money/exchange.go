@@ -0,0 +1,145 @@
+package money
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+)
+
+const defaultCurrency = "USD"
+
+// Exchange converts between currencies with rates quoted against one base
+// currency.
+type Exchange struct {
+	// DefaultCurrency is used for posts created without a currency and for
+	// price filters that do not name one.
+	DefaultCurrency string
+
+	base  string
+	rates map[string]*big.Rat
+}
+
+type ratesFile struct {
+	Base  string                 `json:"base"`
+	Rates map[string]json.Number `json:"rates"`
+}
+
+// NewExchange reads DEFAULT_CURRENCY (USD unless set) and the exchange rates
+// in EXCHANGE_RATES_FILE, a JSON file such as
+//
+//	{"base": "USD", "rates": {"EUR": "0.92", "IRR": "42105.50"}}
+//
+// where each rate is the price of one base unit. Without a file, amounts only
+// convert to their own currency.
+func NewExchange() (*Exchange, error) {
+	exchange := &Exchange{DefaultCurrency: defaultCurrency, rates: map[string]*big.Rat{}}
+
+	if value := os.Getenv("DEFAULT_CURRENCY"); value != "" {
+		currency, err := NormalizeCurrency(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEFAULT_CURRENCY %q: %w", value, err)
+		}
+		exchange.DefaultCurrency = currency
+	}
+
+	path := os.Getenv("EXCHANGE_RATES_FILE")
+	if path == "" {
+		return exchange, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading EXCHANGE_RATES_FILE: %w", err)
+	}
+	if err := exchange.load(data); err != nil {
+		return nil, fmt.Errorf("invalid EXCHANGE_RATES_FILE %s: %w", path, err)
+	}
+	return exchange, nil
+}
+
+func (exchange *Exchange) load(data []byte) error {
+	var file ratesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	base, err := NormalizeCurrency(file.Base)
+	if err != nil {
+		return fmt.Errorf("base %q: %w", file.Base, err)
+	}
+
+	rates := map[string]*big.Rat{base: big.NewRat(1, 1)}
+	for code, value := range file.Rates {
+		currency, err := NormalizeCurrency(code)
+		if err != nil {
+			return fmt.Errorf("rate %q: %w", code, err)
+		}
+		rate, ok := new(big.Rat).SetString(value.String())
+		if !ok || rate.Sign() <= 0 {
+			return fmt.Errorf("rate %q must be a positive number", code)
+		}
+		rates[currency] = rate
+	}
+	exchange.base, exchange.rates = base, rates
+	return nil
+}
+
+// Currencies lists the currencies amounts in from can be converted to.
+func (exchange *Exchange) Currencies(from string) []string {
+	if _, ok := exchange.rates[from]; !ok {
+		return []string{from}
+	}
+	codes := make([]string, 0, len(exchange.rates))
+	for code := range exchange.rates {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// convert returns amount, in whole units of from, in whole units of to.
+func (exchange *Exchange) convert(amount *big.Rat, from, to string) (*big.Rat, bool) {
+	if from == to {
+		return amount, true
+	}
+	fromRate, fromOk := exchange.rates[from]
+	toRate, toOk := exchange.rates[to]
+	if !fromOk || !toOk {
+		return nil, false
+	}
+	converted := new(big.Rat).Quo(amount, fromRate)
+	return converted.Mul(converted, toRate), true
+}
+
+// Convert returns m in currency to, rounded to the nearest minor unit.
+func (exchange *Exchange) Convert(m Money, to string) (Money, bool) {
+	converted, ok := exchange.convert(m.Amount().Rat(), m.Currency, to)
+	if !ok {
+		return Money{}, false
+	}
+	minor, ok := roundRat(toMinor(converted, to), roundNearest)
+	if !ok {
+		return Money{}, false
+	}
+	return Money{Minor: minor, Currency: to}, true
+}
+
+// Bound converts a price bound given in whole units of from to minor units of
+// to. Lower bounds round up and upper bounds round down, so comparing minor
+// units against the result selects exactly the prices within the bound.
+func (exchange *Exchange) Bound(amount Decimal, from, to string, lower bool) (int64, bool) {
+	converted, ok := exchange.convert(amount.Rat(), from, to)
+	if !ok {
+		return 0, false
+	}
+	mode := roundDown
+	if lower {
+		mode = roundUp
+	}
+	return roundRat(toMinor(converted, to), mode)
+}
+
+func toMinor(amount *big.Rat, currency string) *big.Rat {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(Exponent(currency))), nil)
+	return new(big.Rat).Mul(amount, new(big.Rat).SetInt(scale))
+}
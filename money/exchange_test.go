@@ -0,0 +1,94 @@
+package money
+
+import (
+	"math/big"
+	"testing"
+)
+
+func newTestExchange(t *testing.T) *Exchange {
+	t.Helper()
+	exchange := &Exchange{DefaultCurrency: "USD"}
+	err := exchange.load([]byte(`{"base": "usd", "rates": {"EUR": "0.5", "JPY": "150", "KWD": "0.3"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exchange
+}
+
+func TestExchangeLoad(t *testing.T) {
+	for _, data := range []string{
+		`{"base": "XXX", "rates": {}}`,
+		`{"base": "USD", "rates": {"XXX": "1"}}`,
+		`{"base": "USD", "rates": {"EUR": "0"}}`,
+		`{"base": "USD", "rates": {"EUR": "-1"}}`,
+		`{"base": "USD", "rates": {"EUR": "one"}}`,
+	} {
+		if err := (&Exchange{}).load([]byte(data)); err == nil {
+			t.Errorf("%s: no error", data)
+		}
+	}
+}
+
+func TestExchangeConvert(t *testing.T) {
+	exchange := newTestExchange(t)
+	tests := []struct {
+		from Money
+		to   string
+		want int64
+		ok   bool
+	}{
+		{Money{Minor: 1250, Currency: "USD"}, "USD", 1250, true},
+		{Money{Minor: 1250, Currency: "USD"}, "EUR", 625, true},
+		{Money{Minor: 1250, Currency: "EUR"}, "USD", 2500, true},
+		// 12.50 USD is 1875 yen; JPY has no minor unit.
+		{Money{Minor: 1250, Currency: "USD"}, "JPY", 1875, true},
+		// 0.01 USD is 0.005 EUR, which rounds half away from zero.
+		{Money{Minor: 1, Currency: "USD"}, "EUR", 1, true},
+		{Money{Minor: -1, Currency: "USD"}, "EUR", -1, true},
+		// 1 yen is 0.002 KWD, to the nearest fils.
+		{Money{Minor: 1, Currency: "JPY"}, "KWD", 2, true},
+		{Money{Minor: 1250, Currency: "USD"}, "GBP", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := exchange.Convert(test.from, test.to)
+		if ok != test.ok || ok && (got.Minor != test.want || got.Currency != test.to) {
+			t.Errorf("%s to %s: got %v, %t, want %d", test.from, test.to, got, ok, test.want)
+		}
+	}
+
+	// Without rates, amounts only convert to their own currency.
+	if _, ok := (&Exchange{}).Convert(Money{Minor: 1, Currency: "USD"}, "EUR"); ok {
+		t.Error("converted without rates")
+	}
+}
+
+func TestExchangeBound(t *testing.T) {
+	exchange := newTestExchange(t)
+	tests := []struct {
+		amount Decimal
+		from   string
+		to     string
+		lower  bool
+		want   int64
+	}{
+		// 10.01 EUR is 20.02 USD exactly.
+		{NewDecimal(1001, 2), "EUR", "USD", true, 2002},
+		{NewDecimal(1001, 2), "EUR", "USD", false, 2002},
+		// 0.03 USD is 0.015 EUR: a lower bound rounds up, an upper one down.
+		{NewDecimal(3, 2), "USD", "EUR", true, 2},
+		{NewDecimal(3, 2), "USD", "EUR", false, 1},
+		{NewDecimal(-3, 2), "USD", "EUR", true, -1},
+		{NewDecimal(-3, 2), "USD", "EUR", false, -2},
+	}
+	for _, test := range tests {
+		got, ok := exchange.Bound(test.amount, test.from, test.to, test.lower)
+		if !ok || got != test.want {
+			t.Errorf("%s %s to %s (lower %t): got %d, %t, want %d", test.amount, test.from, test.to, test.lower, got, ok, test.want)
+		}
+	}
+
+	huge := new(big.Rat).SetInt(new(big.Int).Lsh(big.NewInt(1), 70))
+	if _, ok := roundRat(huge, roundNearest); ok {
+		t.Error("rounded a value beyond int64")
+	}
+}
@@ -0,0 +1,71 @@
+package money
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+// exponents holds the ISO 4217 minor unit digits of the supported currencies.
+var exponents = map[string]int{
+	"AED": 2, "AUD": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2,
+	"CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "INR": 2, "IQD": 3,
+	"IRR": 2, "JPY": 0, "KRW": 0, "KWD": 3, "MXN": 2, "NOK": 2, "NZD": 2,
+	"OMR": 3, "PLN": 2, "RUB": 2, "SAR": 2, "SEK": 2, "SGD": 2, "TRY": 2,
+	"USD": 2, "ZAR": 2,
+}
+
+// NormalizeCurrency upper-cases code and checks that it is supported.
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if _, ok := exponents[code]; !ok {
+		return "", ErrUnsupportedCurrency
+	}
+	return code, nil
+}
+
+// Exponent returns the number of minor unit digits of currency.
+func Exponent(currency string) int {
+	return exponents[currency]
+}
+
+// Currencies lists the supported currency codes in order.
+func Currencies() []string {
+	codes := make([]string, 0, len(exponents))
+	for code := range exponents {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Money is an amount in the minor units of its currency, e.g. 1250 USD cents.
+type Money struct {
+	Minor    int64  `json:"-"`
+	Currency string `json:"currency"`
+}
+
+// FromDecimal converts amount in whole units of currency, failing with
+// ErrTooPrecise when it has more decimals than the currency's minor unit.
+func FromDecimal(amount Decimal, currency string) (Money, error) {
+	currency, err := NormalizeCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	minor, err := amount.Scaled(Exponent(currency))
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// Amount returns m in whole units of its currency, keeping all minor digits.
+func (m Money) Amount() Decimal {
+	return NewDecimal(m.Minor, Exponent(m.Currency))
+}
+
+func (m Money) String() string {
+	return m.Amount().String() + " " + m.Currency
+}
@@ -0,0 +1,44 @@
+package notification
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+const KindPostExpiring = "post_expiring"
+
+// Notification is a message for one user.
+type Notification struct {
+	UserId  uint
+	Kind    string
+	Subject string
+	Body    string
+	PostId  uint
+}
+
+// Notifier delivers notifications to users.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// LogNotifier writes notifications to the log. It stands in until a delivery
+// channel such as email is wired up.
+type LogNotifier struct {
+	logger *zap.Logger
+}
+
+func NewLogNotifier(logger *zap.Logger) Notifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (notifier *LogNotifier) Notify(ctx context.Context, notification Notification) error {
+	notifier.logger.Info("notification",
+		zap.Uint("userId", notification.UserId),
+		zap.String("kind", notification.Kind),
+		zap.String("subject", notification.Subject),
+		zap.String("body", notification.Body),
+		zap.Uint("postId", notification.PostId),
+	)
+	return nil
+}
@@ -0,0 +1,60 @@
+package post
+
+import "time"
+
+// Audit log actions on posts. Bulk runs record AuditPostBulk followed by the
+// bulk action, e.g. "post.bulk_delete".
+const (
+	AuditPostCreate       = "post.create"
+	AuditPostUpdate       = "post.update"
+	AuditPostRenew        = "post.renew"
+	AuditPostDelete       = "post.delete"
+	AuditPostPricingRules = "post.pricing_rules"
+	AuditPostBulk         = "post.bulk_"
+)
+
+// postSnapshot is the state of a post kept in the audit log. Pricing rules
+// are logged on their own by AuditPostPricingRules.
+type postSnapshot struct {
+	ID          uint       `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	PriceMinor  int64      `json:"priceMinor"`
+	Currency    string     `json:"currency"`
+	Address     string     `json:"address"`
+	CategoryID  uint       `json:"categoryId"`
+	IsActive    bool       `json:"isActive"`
+	OwnerId     uint       `json:"ownerId"`
+	Status      string     `json:"status"`
+	PublishAt   *time.Time `json:"publishAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+}
+
+func snapshotPost(post *Post) *postSnapshot {
+	if post == nil {
+		return nil
+	}
+	return &postSnapshot{
+		ID:          post.ID,
+		Title:       post.Title,
+		Description: post.Description,
+		PriceMinor:  post.PriceMinor,
+		Currency:    post.Currency,
+		Address:     post.Address,
+		CategoryID:  post.CategoryID,
+		IsActive:    post.IsActive,
+		OwnerId:     post.OwnerId,
+		Status:      post.Status,
+		PublishAt:   copyTime(post.PublishAt),
+		ExpiresAt:   copyTime(post.ExpiresAt),
+	}
+}
+
+// copyTime keeps a snapshot from seeing later changes made through t.
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
@@ -0,0 +1,294 @@
+package post
+
+import (
+	"context"
+	"errors"
+	"post-service/apperr"
+	"post-service/audit"
+	"post-service/category"
+	"post-service/money"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+const (
+	BulkPause        = "pause"
+	BulkResume       = "resume"
+	BulkReprice      = "reprice"
+	BulkRecategorize = "recategorize"
+	BulkDelete       = "delete"
+
+	// MaxBulkPosts caps how many posts one bulk request may touch.
+	MaxBulkPosts = 500
+)
+
+const (
+	BulkItemUpdated    = "updated"
+	BulkItemDeleted    = "deleted"
+	BulkItemUnchanged  = "unchanged"
+	BulkItemFailed     = "failed"
+	BulkItemRolledBack = "rolled_back"
+)
+
+var ErrBulkSelection = apperr.Validation("invalid_bulk_selection", "select posts with either ids or filter",
+	apperr.FieldError{Field: "ids", Rule: "excluded_with", Message: "select posts with either ids or filter"})
+var ErrBulkPriceChange = apperr.Validation("invalid_price_change", "price change must set exactly one of percent or amount",
+	apperr.FieldError{Field: "price", Rule: "exactly_one", Message: "price change must set exactly one of percent or amount"})
+var ErrTooManyBulkPosts = apperr.Validation("too_many_posts", "the filter matches more posts than a bulk request may change",
+	apperr.FieldError{Field: "filter", Rule: "max", Message: "the filter matches more posts than a bulk request may change"})
+var ErrBulkPercentTooPrecise = apperr.Validation("invalid_price_change", "percent may have at most 2 decimal places",
+	apperr.FieldError{Field: "percent", Rule: "decimals", Message: "percent may have at most 2 decimal places"})
+var ErrNonPositivePrice = apperr.Validation("non_positive_price", "price per day must stay greater than zero",
+	apperr.FieldError{Field: "pricePerDay", Rule: "gt", Message: "price per day must stay greater than zero"})
+
+// errBulkRolledBack aborts the bulk transaction after an item failed.
+var errBulkRolledBack = errors.New("bulk operation rolled back")
+
+type BulkItemResult struct {
+	ID          uint           `json:"id"`
+	Status      string         `json:"status"`
+	Code        string         `json:"code,omitempty"`
+	Message     string         `json:"message,omitempty"`
+	PricePerDay *money.Decimal `json:"pricePerDay,omitempty"`
+	Currency    string         `json:"currency,omitempty"`
+}
+
+// BulkResult reports what happened to each selected post. Applied is false
+// when any item failed, in which case none of the changes were kept.
+type BulkResult struct {
+	Action  string           `json:"action"`
+	Applied bool             `json:"applied"`
+	Results []BulkItemResult `json:"results"`
+}
+
+// BulkUpdatePosts applies one action to a selection of the caller's posts in a
+// single transaction. Each post gets the same ownership check as UpdatePost,
+// and one failed post rolls back the whole run.
+func (service *PostService) BulkUpdatePosts(ctx context.Context, userId uint, dto BulkPostsDto) (*BulkResult, error) {
+	ctx, span := tracer.Start(ctx, "PostService.BulkUpdatePosts")
+	defer span.End()
+	span.SetAttributes(attribute.String("bulk.action", dto.Action))
+
+	if (len(dto.Ids) > 0) == (dto.Filter != nil) {
+		return nil, ErrBulkSelection
+	}
+	if dto.Action == BulkReprice {
+		if (dto.Price.Percent == nil) == (dto.Price.Amount == nil) {
+			return nil, ErrBulkPriceChange
+		}
+		if dto.Price.Percent != nil {
+			if _, err := dto.Price.Percent.Scaled(2); err != nil {
+				return nil, ErrBulkPercentTooPrecise
+			}
+		}
+	}
+
+	var target *category.Category
+	if dto.Action == BulkRecategorize {
+		cat, _, err := service.catRepo.ResolveCategory(ctx, dto.Category)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrUnknownCategory
+			}
+			return nil, err
+		}
+		target = cat
+	}
+
+	filter := PostFilter{OwnerId: &userId}
+	if dto.Filter != nil {
+		selected, err := service.postFilter(ctx, dto.Filter.Category, dto.Filter.Title, dto.Filter.priceQuery(), true)
+		if err != nil {
+			return nil, err
+		}
+		filter = *selected
+		filter.OwnerId = &userId
+	}
+	ids := uniqueIds(dto.Ids)
+
+	result := &BulkResult{Action: dto.Action}
+	previous := map[uint]*postKey{}
+	before := map[uint]*postSnapshot{}
+	var applied *PostChanges
+	err := service.repo.BulkUpdate(ctx, ids, filter, MaxBulkPosts+1, func(posts []Post) (*PostChanges, error) {
+		if len(ids) == 0 {
+			if len(posts) > MaxBulkPosts {
+				return nil, ErrTooManyBulkPosts
+			}
+			for _, post := range posts {
+				ids = append(ids, post.ID)
+			}
+		}
+
+		found := make(map[uint]*Post, len(posts))
+		for i := range posts {
+			found[posts[i].ID] = &posts[i]
+		}
+
+		changes := &PostChanges{}
+		failed := false
+		now := time.Now()
+		for _, id := range ids {
+			previous[id] = listedKey(found[id], now)
+			before[id] = snapshotPost(found[id])
+			item, err := applyBulkAction(dto, userId, found[id], target, now, changes)
+			item.ID = id
+			if err != nil {
+				failed = true
+				item.Status = BulkItemFailed
+				if appErr, ok := apperr.As(err); ok {
+					item.Code = appErr.Code
+					item.Message = appErr.Message
+				}
+			}
+			result.Results = append(result.Results, item)
+		}
+		if failed {
+			return nil, errBulkRolledBack
+		}
+		applied = changes
+		return changes, nil
+	}, func(ctx context.Context) error {
+		return service.auditBulk(ctx, userId, dto.Action, applied, before)
+	})
+	switch {
+	case err == nil:
+		result.Applied = true
+		service.announceBulk(ctx, applied, previous)
+	case errors.Is(err, errBulkRolledBack):
+		for i := range result.Results {
+			if result.Results[i].Status != BulkItemFailed {
+				result.Results[i].Status = BulkItemRolledBack
+			}
+		}
+	default:
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("bulk.posts", len(result.Results)), attribute.Bool("bulk.applied", result.Applied))
+
+	if result.Results == nil {
+		result.Results = []BulkItemResult{}
+	}
+	return result, nil
+}
+
+// auditBulk records an audit entry for each post a bulk run changes.
+func (service *PostService) auditBulk(ctx context.Context, userId uint, action string, changes *PostChanges, before map[uint]*postSnapshot) error {
+	for _, post := range changes.Save {
+		if err := service.auditLog.Record(ctx, userId, AuditPostBulk+action, audit.TargetPost, post.ID, before[post.ID], snapshotPost(post)); err != nil {
+			return err
+		}
+	}
+	for _, id := range changes.Delete {
+		if err := service.auditLog.Record(ctx, userId, AuditPostBulk+action, audit.TargetPost, id, before[id], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// announceBulk publishes the changes of a committed bulk run. Bulk-loaded
+// posts lack their category, so the saved ones are reloaded.
+func (service *PostService) announceBulk(ctx context.Context, changes *PostChanges, previous map[uint]*postKey) {
+	for _, id := range changes.Delete {
+		service.events.publishRemoved(id, previous[id])
+	}
+	if len(changes.Save) == 0 {
+		return
+	}
+
+	ids := make([]uint, 0, len(changes.Save))
+	for _, post := range changes.Save {
+		ids = append(ids, post.ID)
+	}
+	posts, err := service.repo.GetPostsByIds(ctx, ids)
+	if err != nil {
+		// The changes are committed; clients that miss them catch up on
+		// their next reload.
+		return
+	}
+	now := time.Now()
+	for i := range posts {
+		service.events.publishPost(PostEventUpdated, previous[posts[i].ID], &posts[i], now)
+	}
+}
+
+// applyBulkAction checks one post and records its change in changes.
+func applyBulkAction(dto BulkPostsDto, userId uint, post *Post, target *category.Category, now time.Time, changes *PostChanges) (BulkItemResult, error) {
+	if post == nil {
+		return BulkItemResult{}, ErrPostNotFound
+	}
+	if post.OwnerId != userId {
+		return BulkItemResult{}, ErrForbidden
+	}
+
+	switch dto.Action {
+	case BulkDelete:
+		changes.Delete = append(changes.Delete, post.ID)
+		return BulkItemResult{Status: BulkItemDeleted}, nil
+	case BulkPause, BulkResume:
+		active := dto.Action == BulkResume
+		if post.IsActive == active {
+			return BulkItemResult{Status: BulkItemUnchanged}, nil
+		}
+		post.IsActive = active
+	case BulkReprice:
+		change := PricingRule{AdjustmentType: AdjustPercent}
+		if dto.Price.Percent != nil {
+			change.Adjustment, _ = dto.Price.Percent.Scaled(2)
+		} else {
+			amount, err := dto.Price.Amount.Scaled(money.Exponent(post.Currency))
+			switch {
+			case errors.Is(err, money.ErrTooPrecise):
+				return BulkItemResult{}, ErrPriceTooPrecise
+			case err != nil:
+				return BulkItemResult{}, ErrPriceOutOfRange
+			}
+			change = PricingRule{AdjustmentType: AdjustAbsolute, Adjustment: amount}
+		}
+		if !change.adjustFits(post.PriceMinor) {
+			return BulkItemResult{}, ErrPriceOutOfRange
+		}
+		price := change.adjust(post.PriceMinor)
+		if price <= 0 {
+			return BulkItemResult{}, ErrNonPositivePrice
+		}
+		if err := checkRulePrices(price, post.PricingRules); err != nil {
+			return BulkItemResult{}, err
+		}
+		if price == post.PriceMinor {
+			return BulkItemResult{Status: BulkItemUnchanged}, nil
+		}
+		post.PriceMinor = price
+		post.UpdatedAt = now
+		changes.Save = append(changes.Save, post)
+		amount := post.Price().Amount()
+		return BulkItemResult{Status: BulkItemUpdated, PricePerDay: &amount, Currency: post.Currency}, nil
+	case BulkRecategorize:
+		if post.CategoryID == target.ID {
+			return BulkItemResult{Status: BulkItemUnchanged}, nil
+		}
+		if !target.IsActive {
+			return BulkItemResult{}, ErrInactiveCategory
+		}
+		post.CategoryID = target.ID
+	}
+
+	post.UpdatedAt = now
+	changes.Save = append(changes.Save, post)
+	return BulkItemResult{Status: BulkItemUpdated}, nil
+}
+
+func uniqueIds(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	var unique []uint
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,57 @@
+package post
+
+import (
+	"context"
+	"fmt"
+	"post-service/moderation"
+)
+
+const (
+	WarningContentMasked  = "content_masked"
+	WarningContentFlagged = "content_flagged"
+)
+
+// postReview is what the checks on a post's text found.
+type postReview struct {
+	fingerprint Fingerprint
+	warnings    []PostWarning
+	flags       []moderation.Flag
+}
+
+// reviewText runs the content policy over the title and description, masking
+// them in place, and then looks for duplicates of the result. A blocking
+// violation is returned as a validation error listing the reasons.
+func (service *PostService) reviewText(ctx context.Context, ownerId, postId uint, title, description *string, address string) (*postReview, error) {
+	verdict := service.policy.Review(
+		moderation.Field{Name: "title", Text: title},
+		moderation.Field{Name: "description", Text: description},
+	)
+	if err := verdict.Err(); err != nil {
+		return nil, err
+	}
+
+	fingerprint := fingerprintOf(*title, *description, address)
+	duplicates, err := service.checkDuplicates(ctx, ownerId, postId, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	review := &postReview{fingerprint: fingerprint, flags: verdict.Flags()}
+	for _, violation := range verdict.Masked {
+		review.warnings = append(review.warnings, PostWarning{
+			Code:    WarningContentMasked,
+			Message: fmt.Sprintf("the %s %s, which was hidden", violation.Field, violation.Reason),
+			Field:   violation.Field,
+		})
+	}
+	for _, violation := range verdict.Flagged {
+		review.warnings = append(review.warnings, PostWarning{
+			Code:    WarningContentFlagged,
+			Message: fmt.Sprintf("the %s %s and will be reviewed", violation.Field, violation.Reason),
+			Field:   violation.Field,
+		})
+	}
+	review.warnings = append(review.warnings, duplicates.warnings...)
+	review.flags = append(review.flags, duplicates.flags...)
+	return review, nil
+}
@@ -0,0 +1,230 @@
+package post
+
+import (
+	"context"
+	"fmt"
+	"post-service/apperr"
+	"post-service/logging"
+	"post-service/moderation"
+	"sort"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Similarity at or above which a post is treated as a copy of one of the
+	// owner's other posts and rejected.
+	duplicateRejectSimilarity = 0.9
+	// Similarity at or above which the owner is warned about a likely copy of
+	// their own post.
+	duplicateWarnSimilarity = 0.7
+	// Similarity at or above which a copy of another owner's post goes to the
+	// moderation queue.
+	duplicateFlagSimilarity = 0.8
+
+	// similarCandidates caps how many posts one check compares against.
+	similarCandidates = 200
+	// clusterPairLimit caps the candidate pairs read to build clusters.
+	clusterPairLimit = 10000
+	clusterPageSize  = 20
+)
+
+const WarningPossibleDuplicate = "possible_duplicate"
+
+// PostWarning tells the owner about a problem that did not stop the change.
+type PostWarning struct {
+	Code       string  `json:"code"`
+	Message    string  `json:"message"`
+	Field      string  `json:"field,omitempty"`
+	PostID     uint    `json:"postId,omitempty"`
+	Similarity float64 `json:"similarity,omitempty"`
+}
+
+// duplicateCheck is the outcome of comparing a post with similar posts.
+type duplicateCheck struct {
+	warnings []PostWarning
+	flags    []moderation.Flag
+}
+
+// checkDuplicates compares a post's fingerprint with similar posts. A near
+// copy of another of the owner's posts is rejected and a likely one warned
+// about; a copy of someone else's post is flagged for moderation.
+func (service *PostService) checkDuplicates(ctx context.Context, ownerId, postId uint, fingerprint Fingerprint) (*duplicateCheck, error) {
+	similar, err := service.repo.FindSimilarPosts(ctx, fingerprint, postId, similarCandidates)
+	if err != nil {
+		return nil, err
+	}
+
+	check := &duplicateCheck{}
+	for _, other := range similar {
+		otherFingerprint, ok := parseFingerprint(other.Signature)
+		if !ok {
+			continue
+		}
+		similarity := fingerprint.Similarity(otherFingerprint)
+		switch {
+		case other.OwnerId == ownerId && similarity >= duplicateRejectSimilarity:
+			return nil, apperr.Conflict("duplicate_post", fmt.Sprintf("this post is nearly identical to your post %d", other.PostID))
+		case other.OwnerId == ownerId && similarity >= duplicateWarnSimilarity:
+			check.warnings = append(check.warnings, PostWarning{
+				Code:       WarningPossibleDuplicate,
+				Message:    fmt.Sprintf("this post looks a lot like your post %d", other.PostID),
+				PostID:     other.PostID,
+				Similarity: similarity,
+			})
+		case other.OwnerId != ownerId && similarity >= duplicateFlagSimilarity:
+			related := other.PostID
+			check.flags = append(check.flags, moderation.Flag{
+				Reason:        moderation.ReasonDuplicate,
+				RelatedPostID: &related,
+				Score:         similarity,
+				Status:        moderation.StatusOpen,
+			})
+		}
+	}
+	return check, nil
+}
+
+type DuplicateClusterPost struct {
+	ID      uint   `json:"id"`
+	Title   string `json:"title"`
+	OwnerId uint   `json:"ownerId"`
+}
+
+// DuplicateCluster is a group of posts linked by pairwise similarity.
+type DuplicateCluster struct {
+	Posts      []DuplicateClusterPost `json:"posts"`
+	CrossOwner bool                   `json:"crossOwner"`
+	// Similarity is that of the most similar pair in the cluster.
+	Similarity float64 `json:"similarity"`
+}
+
+// DuplicateClusters is a page of clusters. Truncated is set when there were
+// more candidate pairs than clusterPairLimit; clusters built from the rest may
+// then lack posts or be missing.
+type DuplicateClusters struct {
+	Clusters  []DuplicateCluster `json:"clusters"`
+	Truncated bool               `json:"truncated"`
+}
+
+// GetDuplicateClusters groups posts whose similarity reaches the warning
+// threshold, largest clusters first.
+func (service *PostService) GetDuplicateClusters(ctx context.Context, pageStr string) (*DuplicateClusters, error) {
+	ctx, span := tracer.Start(ctx, "PostService.GetDuplicateClusters")
+	defer span.End()
+
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	// One pair more than used tells whether there were more.
+	pairs, fingerprinted, err := service.repo.CandidatePairs(ctx, clusterPairLimit+1)
+	if err != nil {
+		return nil, err
+	}
+	truncated := len(pairs) > clusterPairLimit
+	if truncated {
+		pairs = pairs[:clusterPairLimit]
+		logging.FromContext(ctx).Warn("duplicate clusters built from truncated candidate pairs", zap.Int("limit", clusterPairLimit))
+	}
+	fingerprints := make(map[uint]Fingerprint, len(fingerprinted))
+	for _, post := range fingerprinted {
+		if fingerprint, ok := parseFingerprint(post.Signature); ok {
+			fingerprints[post.PostID] = fingerprint
+		}
+	}
+
+	// Union the posts of every similar pair into clusters.
+	parent := map[uint]uint{}
+	var find func(id uint) uint
+	find = func(id uint) uint {
+		if parent[id] == id {
+			return id
+		}
+		parent[id] = find(parent[id])
+		return parent[id]
+	}
+	best := map[uint]float64{}
+	for _, pair := range pairs {
+		first, ok1 := fingerprints[pair[0]]
+		second, ok2 := fingerprints[pair[1]]
+		if !ok1 || !ok2 {
+			continue
+		}
+		similarity := first.Similarity(second)
+		if similarity < duplicateWarnSimilarity {
+			continue
+		}
+		for _, id := range pair {
+			if _, ok := parent[id]; !ok {
+				parent[id] = id
+			}
+		}
+		a, b := find(pair[0]), find(pair[1])
+		if a != b {
+			parent[b] = a
+		}
+		root := find(a)
+		best[root] = max(best[root], best[b], similarity)
+	}
+
+	members := map[uint][]uint{}
+	for id := range parent {
+		root := find(id)
+		members[root] = append(members[root], id)
+	}
+	roots := make([]uint, 0, len(members))
+	for root := range members {
+		roots = append(roots, root)
+		sort.Slice(members[root], func(i, j int) bool { return members[root][i] < members[root][j] })
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		a, b := members[roots[i]], members[roots[j]]
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a[0] < b[0]
+	})
+
+	start := (page - 1) * clusterPageSize
+	if start > len(roots) {
+		start = len(roots)
+	}
+	roots = roots[start:min(start+clusterPageSize, len(roots))]
+
+	var ids []uint
+	for _, root := range roots {
+		ids = append(ids, members[root]...)
+	}
+	posts := map[uint]*Post{}
+	if len(ids) > 0 {
+		loaded, err := service.repo.GetPostsByIds(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for i := range loaded {
+			posts[loaded[i].ID] = &loaded[i]
+		}
+	}
+
+	clusters := []DuplicateCluster{}
+	for _, root := range roots {
+		cluster := DuplicateCluster{Similarity: best[root]}
+		for _, id := range members[root] {
+			post, ok := posts[id]
+			if !ok {
+				continue
+			}
+			cluster.Posts = append(cluster.Posts, DuplicateClusterPost{ID: post.ID, Title: post.Title, OwnerId: post.OwnerId})
+			if post.OwnerId != cluster.Posts[0].OwnerId {
+				cluster.CrossOwner = true
+			}
+		}
+		if len(cluster.Posts) > 1 {
+			clusters = append(clusters, cluster)
+		}
+	}
+	return &DuplicateClusters{Clusters: clusters, Truncated: truncated}, nil
+}
@@ -0,0 +1,104 @@
+package post
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// eventHeartbeat is how often an idle stream sends a comment, which keeps
+// proxies from closing it and notices clients that went away.
+const eventHeartbeat = 15 * time.Second
+
+// eventRetry is the reconnection delay suggested to clients, in milliseconds.
+const eventRetry = 3000
+
+// EventHandler streams post changes as Server-Sent Events.
+type EventHandler struct {
+	service *PostService
+	broker  *PostBroker
+}
+
+func NewEventHandler(service *PostService, broker *PostBroker) *EventHandler {
+	return &EventHandler{service: service, broker: broker}
+}
+
+// StreamPost streams the changes to one listed post.
+func (handler *EventHandler) StreamPost(c echo.Context) error {
+	post, err := handler.service.visiblePost(c.Request().Context(), c.Param("postId"))
+	if err != nil {
+		return err
+	}
+	return handler.stream(c, singlePostMatcher(post.ID))
+}
+
+// StreamFeed streams the changes to posts listed under the category and
+// title filters of GET /posts.
+func (handler *EventHandler) StreamFeed(c echo.Context) error {
+	filter, err := handler.service.postFilter(c.Request().Context(), c.QueryParam("category"), c.QueryParam("title"), PriceQuery{}, true)
+	if err != nil {
+		return err
+	}
+	return handler.stream(c, feedMatcher(*filter))
+}
+
+// stream writes events until the client disconnects. A client resumes with
+// the Last-Event-ID header, which browsers send on reconnect, or with the
+// lastEventId query parameter.
+func (handler *EventHandler) stream(c echo.Context, match postMatcher) error {
+	lastEventId := c.Request().Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = c.QueryParam("lastEventId")
+	}
+	sub, missed := handler.broker.subscribe(match, lastEventId)
+	defer handler.broker.unsubscribe(sub)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(res, "retry: %d\n\n", eventRetry); err != nil {
+		return nil
+	}
+	for _, event := range missed {
+		if err := writeEvent(res, event); err != nil {
+			return nil
+		}
+	}
+	res.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	ctx := c.Request().Context()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			err = writeEvent(res, event)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(res, ": keepalive\n\n")
+		}
+		if err != nil {
+			return nil
+		}
+		res.Flush()
+	}
+}
+
+func writeEvent(res *echo.Response, event PostEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,235 @@
+package post
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types streamed to clients watching posts.
+const (
+	// PostEventUpdated carries the post as it is now listed. A post that just
+	// became visible, through creation, publishing or reactivation, is
+	// announced as updated too.
+	PostEventUpdated = "updated"
+	// PostEventDeactivated means the post is no longer listed where the client
+	// is watching: it was paused, deleted, expired or moved out of the feed.
+	PostEventDeactivated = "deactivated"
+	// PostEventCalendarChanged means the post's pricing rules, and with them
+	// its price calendar, changed.
+	PostEventCalendarChanged = "calendar_changed"
+	// PostEventReset tells a resuming client that events were missed and it
+	// has to reload what it shows.
+	PostEventReset = "reset"
+)
+
+const (
+	// eventHistorySize is how many recent events are kept for clients that
+	// resume with Last-Event-ID.
+	eventHistorySize = 1000
+	// subscriberBuffer is how many events may queue for one client before it
+	// is dropped and has to resume.
+	subscriberBuffer = 64
+)
+
+// PostEvent is one change to a publicly listed post.
+type PostEvent struct {
+	ID     uint64        `json:"-"`
+	Type   string        `json:"-"`
+	PostID uint          `json:"postId,omitempty"`
+	Post   *PostResponse `json:"post,omitempty"`
+	At     time.Time     `json:"at"`
+
+	// previous and current describe where the post was listed before and
+	// after the change, or are nil when it was not listed.
+	previous *postKey
+	current  *postKey
+}
+
+// postKey holds the fields feeds are filtered on.
+type postKey struct {
+	id         uint
+	categoryId uint
+	title      string
+}
+
+// listedKey returns the key of post if it is publicly listed at now.
+func listedKey(post *Post, now time.Time) *postKey {
+	if post == nil || !post.VisibleAt(now) {
+		return nil
+	}
+	return &postKey{id: post.ID, categoryId: post.CategoryID, title: post.Title}
+}
+
+// postMatcher decides whether a subscription is interested in a post.
+type postMatcher func(key postKey) bool
+
+func singlePostMatcher(postId uint) postMatcher {
+	return func(key postKey) bool {
+		return key.id == postId
+	}
+}
+
+// feedMatcher matches the posts GetAllPosts would list for filter, ignoring
+// price bounds.
+func feedMatcher(filter PostFilter) postMatcher {
+	title := strings.ToLower(filter.Title)
+	return func(key postKey) bool {
+		if filter.CategoryId != nil && *filter.CategoryId > 0 && key.categoryId != *filter.CategoryId {
+			return false
+		}
+		return strings.Contains(strings.ToLower(key.title), title)
+	}
+}
+
+// forMatcher returns the event as a subscription matching match sees it. A
+// post that left the subscription's view is reported as deactivated.
+func (event PostEvent) forMatcher(match postMatcher) (PostEvent, bool) {
+	if event.current != nil && match(*event.current) {
+		return event, true
+	}
+	if event.previous != nil && match(*event.previous) {
+		return PostEvent{ID: event.ID, Type: PostEventDeactivated, PostID: event.PostID, At: event.At}, true
+	}
+	return PostEvent{}, false
+}
+
+// PostSubscription receives the events one client asked for.
+type PostSubscription struct {
+	events chan PostEvent
+	match  postMatcher
+}
+
+// Events is closed when the subscription ends, either because the client fell
+// too far behind or because the broker was closed.
+func (sub *PostSubscription) Events() <-chan PostEvent {
+	return sub.events
+}
+
+// PostBroker fans post changes made by this instance out to every subscribed
+// client and keeps recent events so that reconnecting clients can resume.
+type PostBroker struct {
+	mu          sync.Mutex
+	nextId      uint64
+	history     []PostEvent
+	subscribers map[*PostSubscription]struct{}
+	closed      bool
+}
+
+func NewPostBroker() *PostBroker {
+	return &PostBroker{
+		// Starting from the clock keeps ids increasing across restarts, so an
+		// id from an earlier run is recognized as a gap.
+		nextId:      uint64(time.Now().UnixMicro()),
+		subscribers: map[*PostSubscription]struct{}{},
+	}
+}
+
+// publishPost announces a change to post. previous is where the post was
+// listed before the change.
+func (broker *PostBroker) publishPost(eventType string, previous *postKey, post *Post, now time.Time) {
+	event := PostEvent{Type: eventType, PostID: post.ID, previous: previous, current: listedKey(post, now)}
+	if event.current != nil {
+		response := toPostResponse(*post)
+		event.Post = &response
+	}
+	broker.publish(event)
+}
+
+// publishRemoved announces that a post listed at previous was deleted.
+func (broker *PostBroker) publishRemoved(postId uint, previous *postKey) {
+	broker.publish(PostEvent{Type: PostEventDeactivated, PostID: postId, previous: previous})
+}
+
+func (broker *PostBroker) publish(event PostEvent) {
+	if event.previous == nil && event.current == nil {
+		return
+	}
+	if event.current == nil {
+		event.Type = PostEventDeactivated
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	event.ID = broker.nextId
+	event.At = time.Now()
+	broker.nextId++
+	if len(broker.history) == eventHistorySize {
+		copy(broker.history, broker.history[1:])
+		broker.history = broker.history[:eventHistorySize-1]
+	}
+	broker.history = append(broker.history, event)
+
+	for sub := range broker.subscribers {
+		delivered, ok := event.forMatcher(sub.match)
+		if !ok {
+			continue
+		}
+		select {
+		case sub.events <- delivered:
+		default:
+			// The client is not keeping up. Dropping it ends its stream, and
+			// it resumes from the history when it reconnects.
+			delete(broker.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// subscribe registers a subscription and returns the events it missed since
+// lastEventId, or a reset event when they are no longer all known. An empty
+// lastEventId starts from now.
+func (broker *PostBroker) subscribe(match postMatcher, lastEventId string) (*PostSubscription, []PostEvent) {
+	sub := &PostSubscription{events: make(chan PostEvent, subscriberBuffer), match: match}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if broker.closed {
+		close(sub.events)
+		return sub, nil
+	}
+	broker.subscribers[sub] = struct{}{}
+
+	if lastEventId == "" {
+		return sub, nil
+	}
+	last, err := strconv.ParseUint(lastEventId, 10, 64)
+	oldest := broker.nextId - uint64(len(broker.history))
+	if err != nil || last >= broker.nextId || last+1 < oldest {
+		return sub, []PostEvent{{ID: broker.nextId - 1, Type: PostEventReset, At: time.Now()}}
+	}
+
+	var missed []PostEvent
+	for _, event := range broker.history[last+1-oldest:] {
+		if delivered, ok := event.forMatcher(match); ok {
+			missed = append(missed, delivered)
+		}
+	}
+	return sub, missed
+}
+
+func (broker *PostBroker) unsubscribe(sub *PostSubscription) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if _, ok := broker.subscribers[sub]; ok {
+		delete(broker.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Close ends every subscription and refuses new ones, so that open streams
+// do not hold up a graceful shutdown.
+func (broker *PostBroker) Close() {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	broker.closed = true
+	for sub := range broker.subscribers {
+		delete(broker.subscribers, sub)
+		close(sub.events)
+	}
+}
@@ -0,0 +1,60 @@
+package post
+
+import (
+	"fmt"
+	"net/http"
+	"post-service/apperr"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ExportHandler struct {
+	exportService *ExportService
+}
+
+func NewExportHandler(exportService *ExportService) *ExportHandler {
+	return &ExportHandler{exportService: exportService}
+}
+
+// ExportMyPosts streams the caller's posts, inactive ones included.
+func (handler *ExportHandler) ExportMyPosts(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+	return handler.export(c, &userId, "my-posts")
+}
+
+// ExportAllPosts streams the whole catalog for admins.
+func (handler *ExportHandler) ExportAllPosts(c echo.Context) error {
+	return handler.export(c, nil, "posts")
+}
+
+func (handler *ExportHandler) export(c echo.Context, ownerId *uint, name string) error {
+	format := strings.ToLower(c.QueryParam("format"))
+	if format == "" {
+		format = ExportCSV
+	}
+	if format == "ndjson" {
+		format = ExportJSONL
+	}
+	contentType, extension, err := ExportContentType(format)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	filter, err := handler.exportService.ExportFilter(ctx, c.QueryParam("category"), c.QueryParam("title"), priceQuery(c), ownerId)
+	if err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format("20060102"), extension)
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Response().WriteHeader(http.StatusOK)
+
+	return handler.exportService.Export(ctx, c.Response(), format, *filter)
+}
@@ -0,0 +1,237 @@
+package post
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"post-service/apperr"
+	"post-service/money"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+const (
+	ExportCSV        = "csv"
+	ExportJSONL      = "jsonl"
+	ExportNDJSONGzip = "ndjson.gz"
+
+	exportBatchSize = 500
+)
+
+var ErrUnsupportedExportFormat = apperr.BadRequest("unsupported_export_format", "export format must be csv, jsonl or ndjson.gz")
+
+// exportCSVColumns starts with the import columns so an owner's export can be
+// imported again as it is.
+var exportCSVColumns = append(append([]string{}, csvColumns...),
+	"currency", "id", "categorySlug", "isActive", "ownerId", "createdAt", "updatedAt", "status", "publishAt", "expiresAt")
+
+type ExportedPost struct {
+	ID           uint          `json:"id"`
+	Title        string        `json:"title"`
+	Description  string        `json:"description"`
+	PricePerDay  money.Decimal `json:"pricePerDay"`
+	Currency     string        `json:"currency"`
+	Address      string        `json:"address"`
+	Category     string        `json:"category"`
+	CategorySlug string        `json:"categorySlug"`
+	IsActive     bool          `json:"isActive"`
+	OwnerId      uint          `json:"ownerId"`
+	CreatedAt    time.Time     `json:"createdAt"`
+	UpdatedAt    time.Time     `json:"updatedAt"`
+	Status       string        `json:"status"`
+	PublishAt    *time.Time    `json:"publishAt"`
+	ExpiresAt    *time.Time    `json:"expiresAt"`
+}
+
+type ExportService struct {
+	service *PostService
+	repo    *PostRepository
+	logger  *zap.Logger
+}
+
+func NewExportService(service *PostService, repo *PostRepository, logger *zap.Logger) *ExportService {
+	return &ExportService{service: service, repo: repo, logger: logger}
+}
+
+// ExportFilter validates the listing parameters of an export. It runs before
+// anything is written, so bad parameters still get a problem response. Old
+// category slugs are followed rather than redirected.
+func (exportService *ExportService) ExportFilter(ctx context.Context, categoryRef, title string, prices PriceQuery, ownerId *uint) (*PostFilter, error) {
+	filter, err := exportService.service.postFilter(ctx, categoryRef, title, prices, true)
+	if err != nil {
+		return nil, err
+	}
+	filter.OwnerId = ownerId
+	return filter, nil
+}
+
+// Export streams every post matching filter to w in the given format. Posts
+// are read in batches of exportBatchSize by id, and w is flushed after each
+// batch, so memory use does not grow with the size of the export.
+func (exportService *ExportService) Export(ctx context.Context, w io.Writer, format string, filter PostFilter) error {
+	ctx, span := tracer.Start(ctx, "ExportService.Export")
+	defer span.End()
+	span.SetAttributes(attribute.String("export.format", format))
+
+	encoder, err := newExportEncoder(w, format)
+	if err != nil {
+		return err
+	}
+
+	var afterId uint
+	var exported int
+	for {
+		posts, err := exportService.repo.GetPostsAfter(ctx, filter, afterId, exportBatchSize)
+		if err != nil {
+			exportService.logger.Error("post export failed", zap.Int("exported", exported), zap.Error(err))
+			return err
+		}
+		for _, post := range posts {
+			if err := encoder.Encode(toExportedPost(post)); err != nil {
+				return err
+			}
+		}
+		exported += len(posts)
+		if err := encoder.Flush(); err != nil {
+			return err
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		if len(posts) < exportBatchSize {
+			break
+		}
+		afterId = posts[len(posts)-1].ID
+	}
+	span.SetAttributes(attribute.Int("export.posts", exported))
+
+	return encoder.Close()
+}
+
+// ExportContentType returns the media type and file extension of an export
+// format.
+func ExportContentType(format string) (string, string, error) {
+	switch format {
+	case ExportCSV:
+		return "text/csv; charset=utf-8", "csv", nil
+	case ExportJSONL:
+		return "application/x-ndjson", "jsonl", nil
+	case ExportNDJSONGzip:
+		return "application/gzip", "ndjson.gz", nil
+	}
+	return "", "", ErrUnsupportedExportFormat
+}
+
+func toExportedPost(post Post) ExportedPost {
+	return ExportedPost{
+		ID:           post.ID,
+		Title:        post.Title,
+		Description:  post.Description,
+		PricePerDay:  post.Price().Amount(),
+		Currency:     post.Currency,
+		Address:      post.Address,
+		Category:     post.Category.Name,
+		CategorySlug: post.Category.Slug,
+		IsActive:     post.IsActive,
+		OwnerId:      post.OwnerId,
+		CreatedAt:    post.CreatedAt,
+		UpdatedAt:    post.UpdatedAt,
+		Status:       post.Status,
+		PublishAt:    post.PublishAt,
+		ExpiresAt:    post.ExpiresAt,
+	}
+}
+
+type exportEncoder interface {
+	Encode(post ExportedPost) error
+	// Flush pushes buffered rows to the underlying writer.
+	Flush() error
+	// Close writes anything the format needs at the end of the stream.
+	Close() error
+}
+
+func newExportEncoder(w io.Writer, format string) (exportEncoder, error) {
+	switch format {
+	case ExportCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(exportCSVColumns); err != nil {
+			return nil, err
+		}
+		return &csvExportEncoder{writer: writer}, nil
+	case ExportJSONL:
+		return &jsonExportEncoder{encoder: json.NewEncoder(w)}, nil
+	case ExportNDJSONGzip:
+		gz := gzip.NewWriter(w)
+		return &jsonExportEncoder{encoder: json.NewEncoder(gz), gz: gz}, nil
+	}
+	return nil, ErrUnsupportedExportFormat
+}
+
+type csvExportEncoder struct {
+	writer *csv.Writer
+}
+
+func (e *csvExportEncoder) Encode(post ExportedPost) error {
+	return e.writer.Write([]string{
+		post.Title,
+		post.Description,
+		post.PricePerDay.String(),
+		post.Address,
+		post.Category,
+		post.Currency,
+		strconv.FormatUint(uint64(post.ID), 10),
+		post.CategorySlug,
+		strconv.FormatBool(post.IsActive),
+		strconv.FormatUint(uint64(post.OwnerId), 10),
+		post.CreatedAt.Format(time.RFC3339),
+		post.UpdatedAt.Format(time.RFC3339),
+		post.Status,
+		formatOptionalTime(post.PublishAt),
+		formatOptionalTime(post.ExpiresAt),
+	})
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (e *csvExportEncoder) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+func (e *csvExportEncoder) Close() error {
+	return e.Flush()
+}
+
+type jsonExportEncoder struct {
+	encoder *json.Encoder
+	gz      *gzip.Writer
+}
+
+func (e *jsonExportEncoder) Encode(post ExportedPost) error {
+	return e.encoder.Encode(post)
+}
+
+func (e *jsonExportEncoder) Flush() error {
+	if e.gz == nil {
+		return nil
+	}
+	return e.gz.Flush()
+}
+
+func (e *jsonExportEncoder) Close() error {
+	if e.gz == nil {
+		return nil
+	}
+	return e.gz.Close()
+}
@@ -0,0 +1,143 @@
+package post
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// shingleSize is the length in runes of the overlapping pieces of text
+	// that are compared. Short enough that a changed word only changes the
+	// shingles around it.
+	shingleSize = 5
+	// minHashSize is the number of hash functions in a fingerprint.
+	minHashSize = 64
+	// lshBands splits a fingerprint into bands of minHashSize/lshBands
+	// values. Posts sharing a band are compared; with 16 bands of 4, pairs
+	// above about 50% similarity are almost always found.
+	lshBands    = 16
+	lshBandRows = minHashSize / lshBands
+)
+
+// minHashSeeds picks the hash functions. Changing them invalidates every
+// stored fingerprint.
+var minHashSeeds = func() [minHashSize]uint64 {
+	var seeds [minHashSize]uint64
+	state := uint64(0x5eed0f90571a9e5)
+	for i := range seeds {
+		state += 0x9e3779b97f4a7c15
+		seeds[i] = splitmix64(state)
+	}
+	return seeds
+}()
+
+// Fingerprint is a MinHash signature of a post's text. The share of equal
+// values in two fingerprints estimates the Jaccard similarity of the texts'
+// shingles.
+type Fingerprint [minHashSize]uint32
+
+// fingerprintOf fingerprints the normalized title, description and address.
+func fingerprintOf(title, description, address string) Fingerprint {
+	var fingerprint Fingerprint
+	for i := range fingerprint {
+		fingerprint[i] = ^uint32(0)
+	}
+
+	text := []rune(normalizeText(title) + " " + normalizeText(description) + " " + normalizeText(address))
+	count := len(text) - shingleSize + 1
+	if count < 1 {
+		count = 1
+	}
+	for start := 0; start < count; start++ {
+		end := start + shingleSize
+		if end > len(text) {
+			end = len(text)
+		}
+		hasher := fnv.New64a()
+		hasher.Write([]byte(string(text[start:end])))
+		shingle := hasher.Sum64()
+		for i, seed := range minHashSeeds {
+			if value := uint32(splitmix64(shingle ^ seed)); value < fingerprint[i] {
+				fingerprint[i] = value
+			}
+		}
+	}
+	return fingerprint
+}
+
+// normalizeText lowercases s, strips accents and turns everything that is
+// not a letter or digit into single spaces.
+func normalizeText(s string) string {
+	var b strings.Builder
+	space := true
+	for _, r := range norm.NFKD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(unicode.ToLower(r))
+			space = false
+		case !space:
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// Similarity estimates how much of the text two fingerprints share, from 0
+// to 1.
+func (fingerprint Fingerprint) Similarity(other Fingerprint) float64 {
+	equal := 0
+	for i := range fingerprint {
+		if fingerprint[i] == other[i] {
+			equal++
+		}
+	}
+	return float64(equal) / minHashSize
+}
+
+// bands hashes each band of the fingerprint for candidate lookup.
+func (fingerprint Fingerprint) bands() [lshBands]int64 {
+	var bands [lshBands]int64
+	for band := range bands {
+		hasher := fnv.New64a()
+		for _, value := range fingerprint[band*lshBandRows : (band+1)*lshBandRows] {
+			binary.Write(hasher, binary.BigEndian, value)
+		}
+		bands[band] = int64(hasher.Sum64())
+	}
+	return bands
+}
+
+func (fingerprint Fingerprint) bytes() []byte {
+	b := make([]byte, 4*minHashSize)
+	for i, value := range fingerprint {
+		binary.BigEndian.PutUint32(b[4*i:], value)
+	}
+	return b
+}
+
+// parseFingerprint reads a fingerprint stored by bytes. It reports false for
+// anything else.
+func parseFingerprint(b []byte) (Fingerprint, bool) {
+	var fingerprint Fingerprint
+	if len(b) != 4*minHashSize {
+		return fingerprint, false
+	}
+	for i := range fingerprint {
+		fingerprint[i] = binary.BigEndian.Uint32(b[4*i:])
+	}
+	return fingerprint, true
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,162 @@
+package post
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"post-service/apperr"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ImportHandler struct {
+	importService *ImportService
+}
+
+func NewImportHandler(importService *ImportService) *ImportHandler {
+	return &ImportHandler{importService: importService}
+}
+
+// CreateImport accepts a CSV or JSON Lines file, either as the multipart field
+// "file" or as the raw request body, and queues it for import. With
+// ?dryRun=true rows are only validated.
+func (handler *ImportHandler) CreateImport(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	dryRun := c.QueryParam("dryRun") == "true"
+	body, format, err := importSource(c)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	limited := http.MaxBytesReader(c.Response(), body, MaxImportSize)
+	job, err := handler.importService.StartImport(c.Request().Context(), userId, format, dryRun, limited, c.Request().Header.Get("Accept-Language"))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return ErrImportTooLarge
+		}
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/posts/imports/%d", job.ID))
+	return c.JSON(http.StatusAccepted, job)
+}
+
+func (handler *ImportHandler) GetImport(c echo.Context) error {
+	job, err := handler.ownedJob(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// GetImportReport downloads the row-level errors of an import as CSV, or as
+// JSON with ?format=json.
+func (handler *ImportHandler) GetImportReport(c echo.Context) error {
+	job, err := handler.ownedJob(c)
+	if err != nil {
+		return err
+	}
+
+	if c.QueryParam("format") == "json" {
+		errs := job.Errors
+		if errs == nil {
+			errs = []RowError{}
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"job": job, "errors": errs})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"import-%d-report.csv\"", job.ID))
+	c.Response().WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(c.Response())
+	if err := writer.Write([]string{"row", "field", "rule", "message"}); err != nil {
+		return err
+	}
+	for _, rowError := range job.Errors {
+		if err := writer.Write([]string{strconv.Itoa(rowError.Row), rowError.Field, rowError.Rule, rowError.Message}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (handler *ImportHandler) ownedJob(c echo.Context) (*ImportJob, error) {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return nil, apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	jobId, err := strconv.ParseUint(c.Param("jobId"), 10, 32)
+	if err != nil {
+		return nil, ErrImportNotFound
+	}
+
+	return handler.importService.GetImport(c.Request().Context(), userId, uint(jobId))
+}
+
+// importSource returns the uploaded file and its format, taken from ?format=,
+// the file's content type or its extension, in that order.
+func importSource(c echo.Context) (io.ReadCloser, string, error) {
+	format := strings.ToLower(c.QueryParam("format"))
+
+	contentType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if contentType != echo.MIMEMultipartForm {
+		if format == "" {
+			format = formatFromContentType(contentType)
+		}
+		return c.Request().Body, normalizeFormat(format), nil
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return nil, "", apperr.BadRequest("missing_file", "multipart upload must contain a \"file\" field")
+	}
+	if fileHeader.Size > MaxImportSize {
+		return nil, "", ErrImportTooLarge
+	}
+	if format == "" {
+		format = formatFromContentType(fileHeader.Header.Get(echo.HeaderContentType))
+	}
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(path.Ext(fileHeader.Filename)), ".")
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	return file, normalizeFormat(format), nil
+}
+
+func formatFromContentType(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return FormatCSV
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines", "application/jsonlines":
+		return FormatJSONL
+	}
+	return ""
+}
+
+func normalizeFormat(format string) string {
+	switch format {
+	case "ndjson", "jsonlines":
+		return FormatJSONL
+	}
+	return format
+}
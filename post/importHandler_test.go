@@ -0,0 +1,78 @@
+package post
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"post-service/database"
+	"post-service/idempotency"
+	"post-service/validation"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func TestCreateImportIdempotent(t *testing.T) {
+	test := newHandlerTest(t)
+	validate, uni, err := validation.NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newTestDB(t)
+	timeouts := &database.QueryTimeouts{Default: time.Second}
+	// The lifecycle is never started, so queued jobs stay pending.
+	importService := NewImportService(fxtest.NewLifecycle(t), test.service, NewImportRepository(db, timeouts), validate, uni, zap.NewNop())
+	idempotent, err := idempotency.NewMiddleware(idempotency.NewKeyRepository(db, timeouts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewImportHandler(importService)
+	test.e.POST("/posts/imports", handler.CreateImport, testLogin, idempotent.Handle)
+
+	upload := func(key, body string) (int, ImportJob) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/posts/imports?dryRun=true", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, "text/csv")
+		req.Header.Set("X-User-Id", "1")
+		req.Header.Set(idempotency.HeaderIdempotencyKey, key)
+		rec := httptest.NewRecorder()
+		test.e.ServeHTTP(rec, req)
+
+		var job ImportJob
+		if rec.Code == http.StatusAccepted {
+			if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+				t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+			}
+			if location := rec.Header().Get(echo.HeaderLocation); location != fmt.Sprintf("/posts/imports/%d", job.ID) {
+				t.Errorf("got Location %q for job %d", location, job.ID)
+			}
+		}
+		return rec.Code, job
+	}
+
+	file := "title,description,pricePerDay,address,category\nDrill,Two batteries,12.50,1 Main Street,tools\n"
+	code, first := upload("import-1", file)
+	if code != http.StatusAccepted || first.ID == 0 || first.TotalRows != 1 {
+		t.Fatalf("got %d with %+v", code, first)
+	}
+	code, retry := upload("import-1", file)
+	if code != http.StatusAccepted || retry.ID != first.ID {
+		t.Errorf("retry: got %d with job %d, want job %d", code, retry.ID, first.ID)
+	}
+	if code, _ := upload("import-1", file+"Ladder,Aluminium,8,1 Main Street,tools\n"); code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key: got %d", code)
+	}
+
+	var jobs int64
+	if err := db.Model(&ImportJob{}).Count(&jobs).Error; err != nil || jobs != 1 {
+		t.Errorf("got %d jobs, %v", jobs, err)
+	}
+	if code, other := upload("import-2", file); code != http.StatusAccepted || other.ID == first.ID {
+		t.Errorf("new key: got %d with job %d", code, other.ID)
+	}
+}
@@ -0,0 +1,88 @@
+package post
+
+import (
+	"context"
+	"post-service/database"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+type ImportJob struct {
+	ID            uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	OwnerId       uint       `json:"ownerId"`
+	Format        string     `json:"format"`
+	DryRun        bool       `json:"dryRun"`
+	Status        string     `json:"status"`
+	TotalRows     int        `json:"totalRows"`
+	ProcessedRows int        `json:"processedRows"`
+	ImportedRows  int        `json:"importedRows"`
+	FailedRows    int        `json:"failedRows"`
+	Message       string     `json:"message,omitempty"`
+	Errors        []RowError `gorm:"serializer:json" json:"-"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+	FinishedAt    *time.Time `json:"finishedAt,omitempty"`
+}
+
+// RowError explains why one row of an import was rejected. Row is the line
+// of the uploaded file the row starts on.
+type RowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+type ImportRepository struct {
+	db       *gorm.DB
+	timeouts *database.QueryTimeouts
+}
+
+func NewImportRepository(db *gorm.DB, timeouts *database.QueryTimeouts) *ImportRepository {
+	return &ImportRepository{db: db, timeouts: timeouts}
+}
+
+func (repo *ImportRepository) AddJob(ctx context.Context, job *ImportJob) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "ImportRepository.AddJob")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Create(job).Error
+}
+
+func (repo *ImportRepository) UpdateJob(ctx context.Context, job *ImportJob) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "ImportRepository.UpdateJob")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Save(job).Error
+}
+
+func (repo *ImportRepository) GetJob(ctx context.Context, jobId uint) (*ImportJob, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "ImportRepository.GetJob")
+	defer cancel()
+
+	var job ImportJob
+	if err := repo.db.WithContext(ctx).First(&job, jobId).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// FailUnfinishedJobs marks jobs left pending or running by a previous process
+// as failed; their uploaded rows only lived in that process's memory.
+func (repo *ImportRepository) FailUnfinishedJobs(ctx context.Context, message string) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "ImportRepository.FailUnfinishedJobs")
+	defer cancel()
+
+	now := time.Now()
+	return repo.db.WithContext(ctx).Model(&ImportJob{}).
+		Where("status IN ?", []string{ImportPending, ImportRunning}).
+		Updates(map[string]interface{}{"status": ImportFailed, "message": message, "finished_at": now, "updated_at": now}).Error
+}
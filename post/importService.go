@@ -0,0 +1,414 @@
+package post
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"post-service/apperr"
+	"post-service/logging"
+	"post-service/money"
+	"post-service/validation"
+	"strings"
+	"sync"
+	"time"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+
+	MaxImportSize   = 10 << 20
+	maxImportRows   = 5000
+	importWorkers   = 2
+	importQueueSize = 16
+	// progressEvery is how many rows are processed between progress saves.
+	progressEvery = 100
+)
+
+var ErrUnsupportedFormat = apperr.BadRequest("unsupported_import_format", "import file must be CSV or JSON Lines")
+var ErrImportTooLarge = apperr.New(apperr.KindTooLarge, "import_too_large", fmt.Sprintf("import file must be at most %d bytes", MaxImportSize))
+var ErrTooManyRows = apperr.Validation("too_many_rows", fmt.Sprintf("an import may contain at most %d rows", maxImportRows))
+var ErrEmptyImport = apperr.Validation("empty_import", "import file contains no rows")
+var ErrImportQueueFull = apperr.New(apperr.KindUnavailable, "import_queue_full", "too many imports are running, try again later")
+var ErrImportNotFound = apperr.NotFound("import_not_found", "import job not found")
+
+var csvColumns = []string{"title", "description", "pricePerDay", "address", "category"}
+
+type importRow struct {
+	line int
+	post PostDto
+	err  *RowError
+}
+
+type importTask struct {
+	jobId          uint
+	ownerId        uint
+	dryRun         bool
+	acceptLanguage string
+	rows           []importRow
+}
+
+// ImportService parses uploaded post files and creates their posts in the
+// background, recording per-row failures on the job.
+type ImportService struct {
+	service  *PostService
+	repo     *ImportRepository
+	validate *validator.Validate
+	uni      *ut.UniversalTranslator
+	logger   *zap.Logger
+
+	queue  chan importTask
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewImportService(lc fx.Lifecycle, service *PostService, repo *ImportRepository, validate *validator.Validate, uni *ut.UniversalTranslator, logger *zap.Logger) *ImportService {
+	importService := &ImportService{
+		service:  service,
+		repo:     repo,
+		validate: validate,
+		uni:      uni,
+		logger:   logger,
+		queue:    make(chan importTask, importQueueSize),
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if err := repo.FailUnfinishedJobs(ctx, "import was interrupted by a restart"); err != nil {
+				return err
+			}
+			importService.start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return importService.stop(ctx)
+		},
+	})
+	return importService
+}
+
+func (importService *ImportService) start() {
+	workerCtx, cancel := context.WithCancel(context.Background())
+	importService.cancel = cancel
+	for i := 0; i < importWorkers; i++ {
+		importService.wg.Add(1)
+		go func() {
+			defer importService.wg.Done()
+			for {
+				select {
+				case <-workerCtx.Done():
+					return
+				case task := <-importService.queue:
+					importService.run(workerCtx, task)
+				}
+			}
+		}()
+	}
+}
+
+// stop cancels running imports, which record how far they got, and waits for
+// the workers to exit. Queued imports are marked failed on the next start.
+func (importService *ImportService) stop(ctx context.Context) error {
+	if importService.cancel == nil {
+		return nil
+	}
+	importService.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		importService.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StartImport parses the uploaded file and queues it for processing. Rows
+// that cannot be parsed are reported on the job rather than failing the upload.
+func (importService *ImportService) StartImport(ctx context.Context, ownerId uint, format string, dryRun bool, body io.Reader, acceptLanguage string) (*ImportJob, error) {
+	ctx, span := tracer.Start(ctx, "ImportService.StartImport")
+	defer span.End()
+
+	var rows []importRow
+	var err error
+	switch format {
+	case FormatCSV:
+		rows, err = parseCSV(body)
+	case FormatJSONL:
+		rows, err = parseJSONL(body)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrEmptyImport
+	}
+	span.SetAttributes(attribute.Int("import.rows", len(rows)))
+
+	job := ImportJob{
+		OwnerId:   ownerId,
+		Format:    format,
+		DryRun:    dryRun,
+		Status:    ImportPending,
+		TotalRows: len(rows),
+	}
+	if err := importService.repo.AddJob(ctx, &job); err != nil {
+		return nil, err
+	}
+
+	task := importTask{jobId: job.ID, ownerId: ownerId, dryRun: dryRun, acceptLanguage: acceptLanguage, rows: rows}
+	select {
+	case importService.queue <- task:
+	default:
+		now := time.Now()
+		job.Status = ImportFailed
+		job.Message = ErrImportQueueFull.Message
+		job.FinishedAt = &now
+		if err := importService.repo.UpdateJob(ctx, &job); err != nil {
+			logging.FromContext(ctx).Error("error failing import job", zap.Uint("jobId", job.ID), zap.Error(err))
+		}
+		return nil, ErrImportQueueFull
+	}
+
+	return &job, nil
+}
+
+// GetImport returns an import job owned by ownerId.
+func (importService *ImportService) GetImport(ctx context.Context, ownerId, jobId uint) (*ImportJob, error) {
+	job, err := importService.repo.GetJob(ctx, jobId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImportNotFound
+		}
+		return nil, err
+	}
+	if job.OwnerId != ownerId {
+		return nil, ErrImportNotFound
+	}
+	return job, nil
+}
+
+func (importService *ImportService) run(ctx context.Context, task importTask) {
+	ctx, span := tracer.Start(ctx, "ImportService.run")
+	defer span.End()
+	span.SetAttributes(attribute.Int("import.job_id", int(task.jobId)), attribute.Bool("import.dry_run", task.dryRun))
+
+	job, err := importService.repo.GetJob(ctx, task.jobId)
+	if err != nil {
+		importService.logger.Error("error loading import job", zap.Uint("jobId", task.jobId), zap.Error(err))
+		return
+	}
+	job.Status = ImportRunning
+	importService.save(job)
+
+	trans := validation.Translator(importService.uni, task.acceptLanguage)
+	for i, row := range task.rows {
+		if ctx.Err() != nil {
+			importService.finish(job, ImportFailed, "import was interrupted by a shutdown")
+			return
+		}
+
+		if rowErrors := importService.importRow(ctx, task, row, trans); len(rowErrors) > 0 {
+			job.FailedRows++
+			job.Errors = append(job.Errors, rowErrors...)
+		} else {
+			job.ImportedRows++
+		}
+		job.ProcessedRows++
+
+		if (i+1)%progressEvery == 0 {
+			importService.save(job)
+		}
+	}
+
+	importService.finish(job, ImportCompleted, "")
+}
+
+// importRow validates one row with the same rules as POST /posts and, unless
+// this is a dry run, creates its post.
+func (importService *ImportService) importRow(ctx context.Context, task importTask, row importRow, trans ut.Translator) []RowError {
+	var rowErrors []RowError
+	if row.err != nil {
+		rowErrors = append(rowErrors, *row.err)
+		if row.err.Field == "" {
+			return rowErrors
+		}
+	}
+
+	if err := importService.validate.Struct(row.post); err != nil {
+		var validationErrors validator.ValidationErrors
+		if !errors.As(err, &validationErrors) {
+			return append(rowErrors, RowError{Row: row.line, Message: err.Error()})
+		}
+		for _, fe := range validationErrors {
+			// A value that failed to parse is also reported as missing.
+			if row.err != nil && row.err.Field == fe.Field() {
+				continue
+			}
+			rowErrors = append(rowErrors, RowError{Row: row.line, Field: fe.Field(), Rule: fe.Tag(), Message: fe.Translate(trans)})
+		}
+	}
+	if len(rowErrors) > 0 {
+		return rowErrors
+	}
+
+	var err error
+	if task.dryRun {
+		if _, err = importService.service.postPrice(row.post.PricePerDay, row.post.Currency); err == nil {
+			_, err = importService.service.resolveNewPostCategory(ctx, row.post.Category)
+		}
+	} else {
+		_, _, err = importService.service.CreatePost(ctx, task.ownerId, row.post)
+	}
+	if err == nil {
+		return nil
+	}
+
+	if appErr, ok := apperr.As(err); ok {
+		rowError := RowError{Row: row.line, Message: appErr.Message}
+		if len(appErr.Fields) > 0 {
+			rowError.Field = appErr.Fields[0].Field
+			rowError.Rule = appErr.Fields[0].Rule
+		}
+		return []RowError{rowError}
+	}
+	importService.logger.Error("error importing row", zap.Uint("jobId", task.jobId), zap.Int("row", row.line), zap.Error(err))
+	return []RowError{{Row: row.line, Message: "internal error while importing this row"}}
+}
+
+func (importService *ImportService) finish(job *ImportJob, status, message string) {
+	now := time.Now()
+	job.Status = status
+	job.Message = message
+	job.FinishedAt = &now
+	importService.save(job)
+}
+
+// save persists job progress. It deliberately ignores the worker context so
+// that an import cancelled by shutdown still records where it stopped.
+func (importService *ImportService) save(job *ImportJob) {
+	if err := importService.repo.UpdateJob(context.Background(), job); err != nil {
+		importService.logger.Error("error saving import job", zap.Uint("jobId", job.ID), zap.Error(err))
+	}
+}
+
+func parseCSV(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, ErrEmptyImport
+		}
+		return nil, apperr.BadRequest("invalid_csv", "failed to read CSV header").WithCause(err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	var missing []string
+	for _, name := range csvColumns {
+		if _, ok := columns[strings.ToLower(name)]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, apperr.Validation("invalid_csv_header", "CSV header is missing columns: "+strings.Join(missing, ", "))
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if len(rows) == maxImportRows {
+			return nil, ErrTooManyRows
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			rows = append(rows, importRow{line: parseErr.StartLine, err: &RowError{Row: parseErr.StartLine, Message: parseErr.Err.Error()}})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			i := columns[strings.ToLower(name)]
+			if i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		row := importRow{line: line, post: PostDto{
+			Title:       field("title"),
+			Description: field("description"),
+			Address:     field("address"),
+			Category:    field("category"),
+		}}
+		if price := field("pricePerDay"); price != "" {
+			parsed, err := money.ParseDecimal(price)
+			if err != nil {
+				row.err = &RowError{Row: line, Field: "pricePerDay", Rule: "number", Message: "pricePerDay must be a decimal number"}
+			}
+			row.post.PricePerDay = parsed
+		}
+		// The currency column is optional.
+		if _, ok := columns["currency"]; ok {
+			row.post.Currency = field("currency")
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseJSONL(body io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), MaxImportSize)
+
+	var rows []importRow
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, ErrTooManyRows
+		}
+
+		row := importRow{line: line}
+		if err := json.Unmarshal(data, &row.post); err != nil {
+			row.err = &RowError{Row: line, Message: "invalid JSON: " + err.Error()}
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, apperr.BadRequest("invalid_jsonl", "failed to read JSON Lines").WithCause(err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,467 @@
+package post
+
+import (
+	"context"
+	"post-service/category"
+	"post-service/database"
+	"post-service/moderation"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MemoryPostStore keeps posts in this process, with the same behaviour as
+// PostRepository, for tests. Posts are joined with their category from the
+// given category store, whose PostIndex it becomes, so both agree on which
+// posts a category has.
+//
+// The category store calls it with its own lock held, so it never calls the
+// category store with its lock held. Hooks run with the lock held and no
+// transaction, before the change is kept.
+type MemoryPostStore struct {
+	mu         sync.Mutex
+	categories category.CategoryStore
+	// posts are stored without their category and pricing rules.
+	posts        map[uint]Post
+	rules        map[uint][]PricingRule
+	fingerprints map[uint]Fingerprint
+	flags        []moderation.Flag
+	lastPostId   uint
+	lastRuleId   uint
+	lastFlagId   uint
+}
+
+func NewMemoryPostStore(categories *category.MemoryCategoryStore) *MemoryPostStore {
+	store := &MemoryPostStore{
+		categories:   categories,
+		posts:        map[uint]Post{},
+		rules:        map[uint][]PricingRule{},
+		fingerprints: map[uint]Fingerprint{},
+	}
+	categories.UsePosts(store)
+	return store
+}
+
+func (store *MemoryPostStore) AddPost(ctx context.Context, post *Post, fingerprint *Fingerprint, flags []moderation.Flag, hook database.Hook) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	store.lastPostId++
+	post.ID = store.lastPostId
+	if post.CreatedAt.IsZero() {
+		post.CreatedAt = now
+	}
+	if post.UpdatedAt.IsZero() {
+		post.UpdatedAt = now
+	}
+	if err := database.RunHook(ctx, nil, hook); err != nil {
+		return err
+	}
+	store.save(post)
+	store.saveAnalysis(post.ID, fingerprint, flags)
+	return nil
+}
+
+func (store *MemoryPostStore) UpdatePost(ctx context.Context, updatedpost *Post, fingerprint *Fingerprint, flags []moderation.Flag, hook database.Hook) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if updatedpost.ID == 0 {
+		store.lastPostId++
+		updatedpost.ID = store.lastPostId
+	}
+	updatedpost.UpdatedAt = time.Now()
+	if err := database.RunHook(ctx, nil, hook); err != nil {
+		return err
+	}
+	store.save(updatedpost)
+	store.saveAnalysis(updatedpost.ID, fingerprint, flags)
+	return nil
+}
+
+// save stores the post's own columns, leaving its associations as they are.
+func (store *MemoryPostStore) save(post *Post) {
+	stored := *post
+	stored.Category = category.Category{}
+	stored.PricingRules = nil
+	store.posts[post.ID] = stored
+}
+
+func (store *MemoryPostStore) saveAnalysis(postId uint, fingerprint *Fingerprint, flags []moderation.Flag) {
+	if fingerprint != nil {
+		store.fingerprints[postId] = *fingerprint
+	}
+	for i := range flags {
+		flags[i].PostID = postId
+		if store.hasFlag(flags[i]) {
+			continue
+		}
+		store.lastFlagId++
+		flags[i].ID = store.lastFlagId
+		if flags[i].CreatedAt.IsZero() {
+			flags[i].CreatedAt = time.Now()
+		}
+		store.flags = append(store.flags, flags[i])
+	}
+}
+
+// hasFlag reports whether a flag with the same post, reason and related post
+// is stored, which the database's unique index would refuse.
+func (store *MemoryPostStore) hasFlag(flag moderation.Flag) bool {
+	for _, stored := range store.flags {
+		if stored.PostID == flag.PostID && stored.Reason == flag.Reason && relatedId(stored) == relatedId(flag) {
+			return true
+		}
+	}
+	return false
+}
+
+func relatedId(flag moderation.Flag) uint {
+	if flag.RelatedPostID == nil {
+		return 0
+	}
+	return *flag.RelatedPostID
+}
+
+func (store *MemoryPostStore) DeletePost(ctx context.Context, postId uint, hook database.Hook) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := database.RunHook(ctx, nil, hook); err != nil {
+		return err
+	}
+	store.delete(postId)
+	return nil
+}
+
+// delete removes a post and, as the database cascades, what refers to it.
+func (store *MemoryPostStore) delete(postId uint) {
+	delete(store.posts, postId)
+	delete(store.rules, postId)
+	delete(store.fingerprints, postId)
+	kept := store.flags[:0]
+	for _, flag := range store.flags {
+		if flag.PostID != postId && relatedId(flag) != postId {
+			kept = append(kept, flag)
+		}
+	}
+	store.flags = kept
+}
+
+func (store *MemoryPostStore) GetPostByID(ctx context.Context, postId uint) (*Post, error) {
+	posts := store.find(func(post *Post) bool { return post.ID == postId })
+	if len(posts) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	store.joinCategories(ctx, posts)
+	return &posts[0], nil
+}
+
+func (store *MemoryPostStore) GetPostsByOwnerId(ctx context.Context, ownerId uint, offset, limit int) ([]Post, error) {
+	posts := page(store.find(func(post *Post) bool { return post.OwnerId == ownerId }), offset, limit)
+	store.joinCategories(ctx, posts)
+	return posts, nil
+}
+
+func (store *MemoryPostStore) GetAllPosts(ctx context.Context, filter PostFilter, offset, limit int) ([]Post, error) {
+	matches := filter.matcher()
+	posts := page(store.find(matches), offset, limit)
+	store.joinCategories(ctx, posts)
+	return posts, nil
+}
+
+func (store *MemoryPostStore) GetPostsByIds(ctx context.Context, ids []uint) ([]Post, error) {
+	wanted := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	posts := store.find(func(post *Post) bool { return wanted[post.ID] })
+	store.joinCategories(ctx, posts)
+	return posts, nil
+}
+
+// BulkUpdate holds the store's lock while apply runs, as the database holds
+// the row locks, so apply must not use the store.
+func (store *MemoryPostStore) BulkUpdate(ctx context.Context, ids []uint, filter PostFilter, limit int, apply func(posts []Post) (*PostChanges, error), hook database.Hook) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	matches := filter.matcher()
+	if len(ids) > 0 {
+		wanted := make(map[uint]bool, len(ids))
+		for _, id := range ids {
+			wanted[id] = true
+		}
+		matches = func(post *Post) bool { return wanted[post.ID] }
+	}
+	posts := page(store.findLocked(matches), 0, limit)
+
+	changes, err := apply(posts)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, post := range changes.Save {
+		post.UpdatedAt = now
+	}
+	if err := database.RunHook(ctx, nil, hook); err != nil {
+		return err
+	}
+	for _, post := range changes.Save {
+		store.save(post)
+	}
+	for _, postId := range changes.Delete {
+		store.delete(postId)
+	}
+	return nil
+}
+
+func (store *MemoryPostStore) ReplacePricingRules(ctx context.Context, postId uint, rules []PricingRule, hook database.Hook) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for i := range rules {
+		store.lastRuleId++
+		rules[i].ID = store.lastRuleId
+		rules[i].PostID = postId
+		if rules[i].CreatedAt.IsZero() {
+			rules[i].CreatedAt = time.Now()
+		}
+	}
+	if err := database.RunHook(ctx, nil, hook); err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		delete(store.rules, postId)
+		return nil
+	}
+	store.rules[postId] = append([]PricingRule(nil), rules...)
+	return nil
+}
+
+func (store *MemoryPostStore) FindSimilarPosts(ctx context.Context, fingerprint Fingerprint, excludeId uint, limit int) ([]similarPost, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	bands := fingerprint.bands()
+	var similar []similarPost
+	for _, postId := range store.fingerprintedIds() {
+		if limit >= 0 && len(similar) >= limit {
+			break
+		}
+		if postId == excludeId || !shareBand(bands, store.fingerprints[postId].bands()) {
+			continue
+		}
+		similar = append(similar, store.similarPost(postId))
+	}
+	return similar, nil
+}
+
+func (store *MemoryPostStore) CandidatePairs(ctx context.Context, limit int) ([][2]uint, []similarPost, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	ids := store.fingerprintedIds()
+	bands := make(map[uint][lshBands]int64, len(ids))
+	for _, postId := range ids {
+		bands[postId] = store.fingerprints[postId].bands()
+	}
+
+	var pairs [][2]uint
+	involved := map[uint]bool{}
+pairing:
+	for i, first := range ids {
+		for _, second := range ids[i+1:] {
+			if limit >= 0 && len(pairs) >= limit {
+				break pairing
+			}
+			if shareBand(bands[first], bands[second]) {
+				pairs = append(pairs, [2]uint{first, second})
+				involved[first], involved[second] = true, true
+			}
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, nil, nil
+	}
+
+	var posts []similarPost
+	for _, postId := range ids {
+		if involved[postId] {
+			posts = append(posts, store.similarPost(postId))
+		}
+	}
+	return pairs, posts, nil
+}
+
+// fingerprintedIds returns the ids of the posts with a fingerprint, in order.
+func (store *MemoryPostStore) fingerprintedIds() []uint {
+	ids := make([]uint, 0, len(store.fingerprints))
+	for postId := range store.fingerprints {
+		if _, ok := store.posts[postId]; ok {
+			ids = append(ids, postId)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (store *MemoryPostStore) similarPost(postId uint) similarPost {
+	return similarPost{PostID: postId, OwnerId: store.posts[postId].OwnerId, Signature: store.fingerprints[postId].bytes()}
+}
+
+func shareBand(a, b [lshBands]int64) bool {
+	for band := range a {
+		if a[band] == b[band] {
+			return true
+		}
+	}
+	return false
+}
+
+// CountPostsByCategory implements category.PostIndex.
+func (store *MemoryPostStore) CountPostsByCategory(activeOnly bool) map[uint]int64 {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	counts := map[uint]int64{}
+	for _, post := range store.posts {
+		if post.IsActive || !activeOnly {
+			counts[post.CategoryID]++
+		}
+	}
+	return counts
+}
+
+// MovePosts implements category.PostIndex.
+func (store *MemoryPostStore) MovePosts(sourceId, targetId uint) int64 {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var moved int64
+	now := time.Now()
+	for postId, post := range store.posts {
+		if post.CategoryID == sourceId {
+			post.CategoryID = targetId
+			post.UpdatedAt = now
+			store.posts[postId] = post
+			moved++
+		}
+	}
+	return moved
+}
+
+// find returns the posts matches accepts, in id order, with their pricing
+// rules.
+func (store *MemoryPostStore) find(matches func(post *Post) bool) []Post {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.findLocked(matches)
+}
+
+func (store *MemoryPostStore) findLocked(matches func(post *Post) bool) []Post {
+	posts := []Post{}
+	for _, stored := range store.posts {
+		post := stored
+		post.PricingRules = append([]PricingRule(nil), store.rules[post.ID]...)
+		if matches(&post) {
+			posts = append(posts, post)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].ID < posts[j].ID })
+	return posts
+}
+
+// joinCategories fills in the posts' categories, as the database's left join
+// does; a post whose category is gone gets a zero one.
+func (store *MemoryPostStore) joinCategories(ctx context.Context, posts []Post) {
+	for i := range posts {
+		cat, err := store.categories.GetCategoryById(ctx, posts[i].CategoryID)
+		if err != nil {
+			continue
+		}
+		cat.Aliases, cat.Translations = nil, nil
+		posts[i].Category = *cat
+	}
+}
+
+// page returns what OFFSET offset LIMIT limit leaves of posts. As in GORM, a
+// negative limit means no limit.
+func page(posts []Post, offset, limit int) []Post {
+	if offset > 0 {
+		posts = posts[min(offset, len(posts)):]
+	}
+	if limit >= 0 && limit < len(posts) {
+		posts = posts[:limit]
+	}
+	return posts
+}
+
+// matcher returns a func reporting whether a post, with its pricing rules,
+// passes the filter as apply's SQL would.
+func (filter PostFilter) matcher() func(post *Post) bool {
+	var title *regexp.Regexp
+	if filter.Title != "" {
+		title = likePattern("%" + strings.ToLower(filter.Title) + "%")
+	}
+	return func(post *Post) bool {
+		if filter.CategoryId != nil && *filter.CategoryId > 0 && post.CategoryID != *filter.CategoryId {
+			return false
+		}
+		if filter.OwnerId != nil && post.OwnerId != *filter.OwnerId {
+			return false
+		}
+		if title != nil && !title.MatchString(strings.ToLower(post.Title)) {
+			return false
+		}
+
+		price := post.PriceMinor
+		if filter.PriceOn != nil {
+			if rule := calendarRule(post.PricingRules, dayOf(*filter.PriceOn)); rule != nil {
+				price = rule.adjust(price)
+			}
+		}
+		if len(filter.MinPrice) > 0 {
+			if bound, ok := filter.MinPrice[post.Currency]; !ok || price < bound {
+				return false
+			}
+		}
+		if len(filter.MaxPrice) > 0 {
+			if bound, ok := filter.MaxPrice[post.Currency]; !ok || price > bound {
+				return false
+			}
+		}
+
+		return filter.VisibleAt == nil || post.VisibleAt(*filter.VisibleAt)
+	}
+}
+
+// likePattern compiles a LIKE pattern, where % matches any run of characters,
+// _ any one character and a backslash escapes the next.
+func likePattern(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("(?s)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			expr.WriteString(".*")
+		case r == '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
@@ -0,0 +1,24 @@
+package post
+
+import (
+	"fmt"
+	"os"
+	"post-service/ratelimit"
+	"strconv"
+)
+
+const defaultDailyPostCap = 20
+
+// NewPostCap reads POST_DAILY_CAP, how many posts a user may create per UTC
+// day. Zero turns the cap off.
+func NewPostCap(store ratelimit.Store) (*ratelimit.DailyCap, error) {
+	max := defaultDailyPostCap
+	if value := os.Getenv("POST_DAILY_CAP"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid POST_DAILY_CAP %q: must be a non-negative integer", value)
+		}
+		max = n
+	}
+	return ratelimit.NewDailyCap(store, "posts", max), nil
+}
@@ -3,11 +3,16 @@ package post
 import (
 	"errors"
 	"net/http"
+	"net/url"
+	"post-service/apperr"
+	"post-service/category"
+	"post-service/money"
+	"post-service/ratelimit"
 	"strconv"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
-	"go.uber.org/zap"
 )
 
 type PostHandler struct {
@@ -15,61 +20,141 @@ type PostHandler struct {
 	validate *validator.Validate
 }
 
-func NewPostHandler(service *PostService, logger *zap.Logger, validate *validator.Validate) *PostHandler {
+func NewPostHandler(service *PostService, validate *validator.Validate) *PostHandler {
 	return &PostHandler{service: service, validate: validate}
 }
 
 type PostDto struct {
-	Title       string  `json:"title" validate:"required"`
-	Description string  `json:"description" validate:"required"`
-	PricePerDay float64 `json:"pricePerDay" validate:"required"`
-	Address     string  `json:"address" validate:"required"`
-	Category    string  `json:"category" validate:"required"`
+	Title       string        `json:"title" validate:"required,min=3,max=120"`
+	Description string        `json:"description" validate:"required,max=5000,nohtml"`
+	PricePerDay money.Decimal `json:"pricePerDay" validate:"required,gt=0"`
+	// Currency is an ISO 4217 code and defaults to DEFAULT_CURRENCY.
+	Currency string `json:"currency" validate:"omitempty,len=3"`
+	Address  string `json:"address" validate:"required,max=255"`
+	Category string `json:"category" validate:"required,max=100"`
+	// PublishAt defaults to now and ExpiresAt to the end of the listing period.
+	PublishAt *time.Time `json:"publishAt"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type UpdatePostDto struct {
+	Title       string        `json:"title" validate:"required,min=3,max=120"`
+	Description string        `json:"description" validate:"required,max=5000,nohtml"`
+	PricePerDay money.Decimal `json:"pricePerDay" validate:"required,gt=0"`
+	Currency    string        `json:"currency" validate:"omitempty,len=3"`
+	Address     string        `json:"address" validate:"required,max=255"`
+	Category    string        `json:"category" validate:"required,max=100"`
+	IsActive    *bool         `json:"isActive" validate:"required"`
+	// Changing PublishAt alone restarts the listing period from it.
+	PublishAt *time.Time `json:"publishAt"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type PricingRuleDto struct {
+	Name           string        `json:"name" validate:"max=100"`
+	Kind           string        `json:"kind" validate:"required,oneof=date_range weekday duration"`
+	StartDate      string        `json:"startDate" validate:"required_if=Kind date_range"`
+	EndDate        string        `json:"endDate" validate:"required_if=Kind date_range"`
+	Weekdays       []int         `json:"weekdays" validate:"required_if=Kind weekday,max=7,dive,min=0,max=6"`
+	MinDays        int           `json:"minDays" validate:"required_if=Kind duration,omitempty,min=2,max=365"`
+	AdjustmentType string        `json:"adjustmentType" validate:"required,oneof=percent absolute"`
+	Adjustment     money.Decimal `json:"adjustment" validate:"required"`
+}
+
+type PricingRulesDto struct {
+	Rules []PricingRuleDto `json:"rules" validate:"max=50,dive"`
+}
+
+type BulkFilterDto struct {
+	Category string         `json:"category" validate:"max=100"`
+	Title    string         `json:"title" validate:"max=120"`
+	Price    string         `json:"price"`
+	MinPrice *money.Decimal `json:"minPrice"`
+	MaxPrice *money.Decimal `json:"maxPrice"`
+	Currency string         `json:"currency" validate:"omitempty,len=3"`
+}
+
+func (dto BulkFilterDto) priceQuery() PriceQuery {
+	query := PriceQuery{Price: dto.Price, Currency: dto.Currency}
+	if dto.MinPrice != nil {
+		query.MinPrice = dto.MinPrice.String()
+	}
+	if dto.MaxPrice != nil {
+		query.MaxPrice = dto.MaxPrice.String()
+	}
+	return query
+}
+
+type BulkPriceChangeDto struct {
+	Percent *money.Decimal `json:"percent" validate:"omitempty,gt=-100,lte=1000"`
+	// Amount is added to each post's price in the post's own currency.
+	Amount *money.Decimal `json:"amount"`
+}
+
+type BulkPostsDto struct {
+	Action   string              `json:"action" validate:"required,oneof=pause resume reprice recategorize delete"`
+	Ids      []uint              `json:"ids" validate:"max=500,dive,gt=0"`
+	Filter   *BulkFilterDto      `json:"filter"`
+	Price    *BulkPriceChangeDto `json:"price" validate:"required_if=Action reprice"`
+	Category string              `json:"category" validate:"required_if=Action recategorize,max=100"`
 }
 
 func (handler *PostHandler) CreatePost(c echo.Context) error {
 	var newPost PostDto
 	userId, ok := c.Get("userId").(uint)
 	if !ok {
-		zap.L().Error("failed to get userId from context")
-		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
 	}
 
 	if err := c.Bind(&newPost); err != nil {
-		zap.L().Error("failed to bind request", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+		return apperr.BadRequest("invalid_request", "failed to parse request body")
 	}
 
 	if err := handler.validate.Struct(newPost); err != nil {
-		zap.L().Error("provided data is invalid", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid data")
+		return apperr.FromValidator(err)
 	}
 
-	postId, err := handler.service.CreatePost(userId, newPost)
+	postId, warnings, err := handler.service.CreatePost(c.Request().Context(), userId, newPost)
 	if err != nil {
-		zap.L().Error("Error creating post", zap.Error(err))
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create post")
+		if errors.Is(err, ErrDailyPostCap) {
+			now := time.Now()
+			ratelimit.SetRetryAfter(c.Response().Header(), ratelimit.NextDay(now).Sub(now))
+		}
+		return err
 	}
 
-	return c.JSON(http.StatusCreated, map[string]interface{}{
+	response := map[string]interface{}{
 		"post_id": postId,
-	})
+	}
+	if len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+	return c.JSON(http.StatusCreated, response)
 }
 
 func (handler *PostHandler) GetAllPosts(c echo.Context) error {
-	category := c.Param("category")
+	categoryRef, err := url.PathUnescape(c.Param("category"))
+	if err != nil {
+		return category.ErrCategoryNotFound
+	}
 	title := c.QueryParam("title")
-	priceStr := c.QueryParam("price")
 	pageStr := c.QueryParam("page")
 	page, err := strconv.Atoi(pageStr)
 	if err != nil || page < 1 {
 		page = 1
 	}
 
-	posts, err := handler.service.GetAllPosts(category, title, priceStr, page)
+	posts, err := handler.service.GetAllPosts(c.Request().Context(), categoryRef, title, priceQuery(c), page)
 	if err != nil {
-		zap.L().Error("error getting posts", zap.Error(err))
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch posts")
+		var moved *category.MovedError
+		if errors.As(err, &moved) {
+			target := "/posts/category/" + url.PathEscape(moved.Slug)
+			if query := c.QueryString(); query != "" {
+				target += "?" + query
+			}
+			return c.Redirect(http.StatusMovedPermanently, target)
+		}
+		return err
 	}
 
 	return c.JSON(http.StatusOK, posts)
@@ -79,14 +164,12 @@ func (handler *PostHandler) GetAllPosts(c echo.Context) error {
 func (handler *PostHandler) GetPostByID(c echo.Context) error {
 	postId := c.Param("postId")
 	if postId == "" {
-		zap.L().Error("missed postId")
-		return echo.NewHTTPError(http.StatusBadRequest, "Post ID is required")
+		return ErrInvalidPostId
 	}
 
-	post, err := handler.service.GetPostByID(postId)
+	post, err := handler.service.GetPostByID(c.Request().Context(), postId)
 	if err != nil {
-		zap.L().Error("error retrieving post", zap.Error(err))
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed fo get post")
+		return err
 	}
 
 	return c.JSON(http.StatusOK, post)
@@ -95,86 +178,167 @@ func (handler *PostHandler) GetPostByID(c echo.Context) error {
 func (handler *PostHandler) GetPostsByOwnerId(c echo.Context) error {
 	userId, ok := c.Get("userId").(uint)
 	if !ok {
-		zap.L().Error("failed to get userId from context")
-		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
 	}
 
 	pageStr := c.QueryParam("page")
 
-	posts, err := handler.service.GetPostsByOwnerId(userId, pageStr)
+	posts, err := handler.service.GetPostsByOwnerId(c.Request().Context(), userId, pageStr)
 	if err != nil {
-		zap.L().Error("error retrieving post", zap.Error(err))
-		return echo.NewHTTPError(http.StatusInternalServerError, "faile to retrieve posts")
+		return err
 	}
 
 	return c.JSON(http.StatusOK, posts)
 }
 
+func (handler *PostHandler) GetDuplicateClusters(c echo.Context) error {
+	clusters, err := handler.service.GetDuplicateClusters(c.Request().Context(), c.QueryParam("page"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, clusters)
+}
+
 func (handler *PostHandler) UpdatePost(c echo.Context) error {
 	userId, ok := c.Get("userId").(uint)
 	if !ok {
-		zap.L().Error("failed to get userId from context")
-		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
 	}
 
 	postIdStr := c.Param("postId")
 	if postIdStr == "" {
-		zap.L().Error("missed postId")
-		return echo.NewHTTPError(http.StatusBadRequest, "Post ID is required")
+		return ErrInvalidPostId
 	}
 
-	var updatedPost struct {
-		Title       string  `json:"title" validate:"required"`
-		Description string  `json:"description" validate:"required"`
-		PricePerDay float64 `json:"pricePerDay" validate:"required"`
-		Address     string  `json:"address" validate:"required"`
-		Category    string  `json:"category" validate:"required"`
-		IsActive    bool    `json:"isActive" validate:"required"`
-	}
+	var updatedPost UpdatePostDto
 	if err := c.Bind(&updatedPost); err != nil {
-		zap.L().Error("failed to bind request", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+		return apperr.BadRequest("invalid_request", "failed to parse request body")
 	}
 
 	err := handler.validate.Struct(updatedPost)
 	if err != nil {
-		zap.L().Error("provided data is invalid", zap.Error(err))
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid data")
+		return apperr.FromValidator(err)
 	}
 
-	err = handler.service.UpdatePost(userId, postIdStr, updatedPost)
+	warnings, err := handler.service.UpdatePost(c.Request().Context(), userId, postIdStr, updatedPost)
 	if err != nil {
-		if errors.Is(err, ErrForbidden) {
-			return c.JSON(http.StatusForbidden, map[string]string{"error": "You are not authorized to update this post."})
-		} else if errors.Is(err, ErrPostNotFound) {
-			return c.JSON(http.StatusNotFound, map[string]string{"error": "this post not exist"})
-		}
-		zap.L().Error("error updating post")
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update post")
+		return err
+	}
+	response := map[string]interface{}{"message": "Post updated successfully"}
+	if len(warnings) > 0 {
+		response["warnings"] = warnings
 	}
-	return c.JSON(http.StatusOK, map[string]string{"message": "Post updated successfully"})
+	return c.JSON(http.StatusOK, response)
+}
+
+func (handler *PostHandler) RenewPost(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	postIdStr := c.Param("postId")
+	if postIdStr == "" {
+		return ErrInvalidPostId
+	}
+
+	expiresAt, err := handler.service.RenewPost(c.Request().Context(), userId, postIdStr)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"expiresAt": expiresAt})
+}
+
+func (handler *PostHandler) GetPricingRules(c echo.Context) error {
+	rules, err := handler.service.GetPricingRules(c.Request().Context(), c.Param("postId"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
+func (handler *PostHandler) SetPricingRules(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	var dto PricingRulesDto
+	if err := c.Bind(&dto); err != nil {
+		return apperr.BadRequest("invalid_request", "failed to parse request body")
+	}
+
+	if err := handler.validate.Struct(dto); err != nil {
+		return apperr.FromValidator(err)
+	}
+
+	rules, err := handler.service.SetPricingRules(c.Request().Context(), userId, c.Param("postId"), dto)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
+func (handler *PostHandler) GetPriceCalendar(c echo.Context) error {
+	calendar, err := handler.service.GetPriceCalendar(c.Request().Context(), c.Param("postId"), c.QueryParam("rentalDays"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, calendar)
 }
 
 func (handler *PostHandler) DeletePost(c echo.Context) error {
 	userId, ok := c.Get("userId").(uint)
 	if !ok {
-		zap.L().Error("failed to get userId from context")
-		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
 	}
 
 	postIdStr := c.Param("postId")
 	if postIdStr == "" {
-		zap.L().Error("missed postId")
-		return echo.NewHTTPError(http.StatusBadRequest, "Post ID is required")
+		return ErrInvalidPostId
 	}
 
-	err := handler.service.DeletePost(postIdStr, userId)
+	err := handler.service.DeletePost(c.Request().Context(), postIdStr, userId)
 	if err != nil {
-		if errors.Is(err, echo.NewHTTPError(http.StatusForbidden)) {
-			return c.JSON(http.StatusForbidden, map[string]string{"error": "You are not authorized to delete this post."})
-		}
-		zap.L().Error("error deleting post")
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete post")
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// BulkUpdatePosts answers 200 when every selected post was changed and 422,
+// with the same body, when the run was rolled back.
+func (handler *PostHandler) BulkUpdatePosts(c echo.Context) error {
+	userId, ok := c.Get("userId").(uint)
+	if !ok {
+		return apperr.Unauthorized("unauthorized", "you are not logged in")
+	}
+
+	var dto BulkPostsDto
+	if err := c.Bind(&dto); err != nil {
+		return apperr.BadRequest("invalid_request", "failed to parse request body")
+	}
+
+	if err := handler.validate.Struct(dto); err != nil {
+		return apperr.FromValidator(err)
+	}
+
+	result, err := handler.service.BulkUpdatePosts(c.Request().Context(), userId, dto)
+	if err != nil {
+		return err
+	}
+	if !result.Applied {
+		return c.JSON(http.StatusUnprocessableEntity, result)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// priceQuery reads the price filter parameters shared by listings and exports.
+func priceQuery(c echo.Context) PriceQuery {
+	return PriceQuery{
+		MinPrice: c.QueryParam("minPrice"),
+		MaxPrice: c.QueryParam("maxPrice"),
+		Price:    c.QueryParam("price"),
+		Currency: c.QueryParam("currency"),
 	}
-	return c.JSON(http.StatusNoContent, map[string]string{"message": "Post deleted successfully"})
 }
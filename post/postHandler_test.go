@@ -0,0 +1,432 @@
+package post
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"post-service/apperr"
+	"post-service/audit"
+	"post-service/category"
+	"post-service/database"
+	"post-service/moderation"
+	"post-service/money"
+	"post-service/ratelimit"
+	"post-service/validation"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// handlerTest serves the post routes over the in-memory stores.
+type handlerTest struct {
+	e          *echo.Echo
+	service    *PostService
+	posts      *MemoryPostStore
+	categories *category.MemoryCategoryStore
+	tools      category.Category
+	garden     category.Category
+}
+
+func newHandlerTest(t *testing.T) *handlerTest {
+	t.Helper()
+	validate, uni, err := validation.NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	categories := category.NewMemoryCategoryStore()
+	posts := NewMemoryPostStore(categories)
+	test := &handlerTest{
+		posts:      posts,
+		categories: categories,
+		tools:      category.Category{Name: "Tools", Slug: "tools", IsActive: true},
+		garden:     category.Category{Name: "Garden", Slug: "garden", IsActive: true},
+	}
+	for _, cat := range []*category.Category{&test.tools, &test.garden} {
+		if err := categories.AddCategory(context.Background(), cat, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	service := NewPostService(categories, posts,
+		&ScheduleConfig{ListingDuration: defaultListingDuration},
+		&money.Exchange{DefaultCurrency: "USD"},
+		NewPostBroker(),
+		ratelimit.NewDailyCap(ratelimit.NewMemoryStore(), "posts", 0),
+		moderation.NewPolicy(moderation.NewEmailCheck(moderation.ActionMask)),
+		newTestAuditService(t))
+	handler := NewPostHandler(service, validate)
+
+	e := echo.New()
+	e.HTTPErrorHandler = apperr.NewHTTPErrorHandler(uni)
+	e.GET("/posts", handler.GetAllPosts)
+	e.GET("/posts/category/:category", handler.GetAllPosts)
+	e.GET("/posts/:postId", handler.GetPostByID)
+	e.GET("/my-posts", handler.GetPostsByOwnerId, testLogin)
+	postGroup := e.Group("/posts", testLogin)
+	postGroup.POST("", handler.CreatePost)
+	postGroup.POST("/bulk", handler.BulkUpdatePosts)
+	postGroup.PUT("/:postId", handler.UpdatePost)
+	postGroup.DELETE("/:postId", handler.DeletePost)
+	e.GET("/admin/posts/duplicates", handler.GetDuplicateClusters)
+	test.e = e
+	test.service = service
+	return test
+}
+
+// newTestAuditService records audit entries in an in-memory database.
+func newTestAuditService(t *testing.T) *audit.AuditService {
+	t.Helper()
+	return audit.NewAuditService(audit.NewEntryRepository(newTestDB(t), &database.QueryTimeouts{Default: time.Second}))
+}
+
+// testLogin stands in for auth.AuthMiddleware, taking the user from the
+// X-User-Id header.
+func testLogin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if userId, err := strconv.ParseUint(c.Request().Header.Get("X-User-Id"), 10, 64); err == nil {
+			c.Set("userId", uint(userId))
+		}
+		return next(c)
+	}
+}
+
+// do sends a request as userId, or anonymously for 0, and decodes the JSON
+// response into out unless it is nil.
+func (test *handlerTest) do(t *testing.T, method, target string, userId uint, body interface{}, out interface{}) int {
+	t.Helper()
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, target, payload)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if userId != 0 {
+		req.Header.Set("X-User-Id", fmt.Sprint(userId))
+	}
+	rec := httptest.NewRecorder()
+	test.e.ServeHTTP(rec, req)
+
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+// createPost creates a post in category Tools for userId and returns its id.
+func (test *handlerTest) createPost(t *testing.T, userId uint, title string) uint {
+	t.Helper()
+	var created struct {
+		PostID uint `json:"post_id"`
+	}
+	dto := map[string]interface{}{
+		"title":       title,
+		"description": "Works well, " + title + " for rent",
+		"pricePerDay": "12.50",
+		"address":     "1 Main Street",
+		"category":    "tools",
+	}
+	if code := test.do(t, http.MethodPost, "/posts", userId, dto, &created); code != http.StatusCreated {
+		t.Fatalf("creating %q: got status %d", title, code)
+	}
+	return created.PostID
+}
+
+func assertProblem(t *testing.T, code int, problem apperr.Problem, wantStatus int, wantCode string) {
+	t.Helper()
+	if code != wantStatus || problem.Code != wantCode {
+		t.Errorf("got %d %q, want %d %q", code, problem.Code, wantStatus, wantCode)
+	}
+}
+
+func TestCreatePost(t *testing.T) {
+	test := newHandlerTest(t)
+
+	postId := test.createPost(t, 1, "Cordless drill")
+	var post PostResponseWithOwner
+	if code := test.do(t, http.MethodGet, fmt.Sprintf("/posts/%d", postId), 0, nil, &post); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if post.Title != "Cordless drill" || post.Category != "Tools" || post.OwnerId != 1 || post.Currency != "USD" {
+		t.Errorf("got %+v", post)
+	}
+	if post.PricePerDay.String() != "12.5" || post.ExpiresAt == nil {
+		t.Errorf("got price %s, expiry %v", post.PricePerDay, post.ExpiresAt)
+	}
+
+	var masked struct {
+		PostID   uint          `json:"post_id"`
+		Warnings []PostWarning `json:"warnings"`
+	}
+	dto := map[string]interface{}{
+		"title":       "Hedge trimmer",
+		"description": "Mail me at owner@example.com",
+		"pricePerDay": "20",
+		"address":     "1 Main Street",
+		"category":    "Garden",
+	}
+	if code := test.do(t, http.MethodPost, "/posts", 1, dto, &masked); code != http.StatusCreated || len(masked.Warnings) != 1 || masked.Warnings[0].Code != WarningContentMasked {
+		t.Errorf("got %d with warnings %+v", code, masked.Warnings)
+	}
+	stored, err := test.posts.GetPostByID(context.Background(), masked.PostID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored.Description, "owner@example.com") || stored.CategoryID != test.garden.ID {
+		t.Errorf("got %+v", stored)
+	}
+}
+
+func TestCreatePostRejected(t *testing.T) {
+	test := newHandlerTest(t)
+	valid := map[string]interface{}{
+		"title":       "Cordless drill",
+		"description": "Two batteries",
+		"pricePerDay": "12.50",
+		"address":     "1 Main Street",
+		"category":    "tools",
+	}
+	with := func(key string, value interface{}) map[string]interface{} {
+		dto := map[string]interface{}{}
+		for k, v := range valid {
+			dto[k] = v
+		}
+		dto[key] = value
+		return dto
+	}
+
+	var problem apperr.Problem
+	code := test.do(t, http.MethodPost, "/posts", 0, valid, &problem)
+	assertProblem(t, code, problem, http.StatusUnauthorized, "unauthorized")
+
+	problem = apperr.Problem{}
+	code = test.do(t, http.MethodPost, "/posts", 1, with("title", "ab"), &problem)
+	assertProblem(t, code, problem, http.StatusBadRequest, "invalid_data")
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "title" {
+		t.Errorf("got field errors %+v", problem.Errors)
+	}
+
+	problem = apperr.Problem{}
+	code = test.do(t, http.MethodPost, "/posts", 1, with("category", "Kitchen"), &problem)
+	assertProblem(t, code, problem, http.StatusBadRequest, "unknown_category")
+
+	if posts, _ := test.posts.GetAllPosts(context.Background(), PostFilter{}, 0, 10); len(posts) != 0 {
+		t.Errorf("rejected posts were stored: %+v", posts)
+	}
+}
+
+func TestListPosts(t *testing.T) {
+	test := newHandlerTest(t)
+	drill := test.createPost(t, 1, "Cordless drill")
+	press := test.createPost(t, 2, "Drill press")
+	ladder := test.createPost(t, 2, "Ladder")
+
+	// A post in Garden, and one that is not listed.
+	trimmer := &Post{Title: "Hedge trimmer", PriceMinor: 2000, Currency: "USD", CategoryID: test.garden.ID, IsActive: true, OwnerId: 1}
+	paused := &Post{Title: "Paused drill", PriceMinor: 2000, Currency: "USD", CategoryID: test.tools.ID, IsActive: false, OwnerId: 1}
+	for _, post := range []*Post{trimmer, paused} {
+		if err := test.posts.AddPost(context.Background(), post, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listings := []struct {
+		target string
+		want   []uint
+	}{
+		{"/posts/category/tools", []uint{drill, press, ladder}},
+		{"/posts/category/tools?title=drill", []uint{drill, press}},
+		{"/posts?title=DRILL&maxPrice=12.50", []uint{drill, press}},
+		{"/posts?minPrice=13", []uint{trimmer.ID}},
+		{"/posts?page=2", []uint{}},
+	}
+	for _, listing := range listings {
+		var posts []PostResponse
+		if code := test.do(t, http.MethodGet, listing.target, 0, nil, &posts); code != http.StatusOK {
+			t.Fatalf("%s: got status %d", listing.target, code)
+		}
+		got := []uint{}
+		for _, post := range posts {
+			got = append(got, post.ID)
+		}
+		if !equalIds(got, listing.want) {
+			t.Errorf("%s: got %v, want %v", listing.target, got, listing.want)
+		}
+	}
+
+	var mine []PostResponse
+	if code := test.do(t, http.MethodGet, "/my-posts", 2, nil, &mine); code != http.StatusOK || len(mine) != 2 {
+		t.Errorf("got %d with %+v", code, mine)
+	}
+
+	var problem apperr.Problem
+	code := test.do(t, http.MethodGet, "/posts/category/kitchen", 0, nil, &problem)
+	if code != http.StatusNotFound {
+		t.Errorf("unknown category: got status %d", code)
+	}
+}
+
+func TestUpdateAndDeletePost(t *testing.T) {
+	test := newHandlerTest(t)
+	postId := test.createPost(t, 1, "Cordless drill")
+	target := fmt.Sprintf("/posts/%d", postId)
+	update := map[string]interface{}{
+		"title":       "Hammer drill",
+		"description": "With a case",
+		"pricePerDay": "15",
+		"address":     "1 Main Street",
+		"category":    "garden",
+		"isActive":    true,
+	}
+
+	var problem apperr.Problem
+	code := test.do(t, http.MethodPut, target, 2, update, &problem)
+	assertProblem(t, code, problem, http.StatusForbidden, "post_forbidden")
+
+	if code := test.do(t, http.MethodPut, target, 1, update, nil); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	var post PostResponseWithOwner
+	test.do(t, http.MethodGet, target, 0, nil, &post)
+	if post.Title != "Hammer drill" || post.Category != "Garden" || post.PricePerDay.String() != "15" {
+		t.Errorf("got %+v", post)
+	}
+
+	problem = apperr.Problem{}
+	code = test.do(t, http.MethodDelete, target, 2, nil, &problem)
+	assertProblem(t, code, problem, http.StatusForbidden, "post_forbidden")
+
+	if code := test.do(t, http.MethodDelete, target, 1, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("got status %d", code)
+	}
+	problem = apperr.Problem{}
+	code = test.do(t, http.MethodGet, target, 0, nil, &problem)
+	assertProblem(t, code, problem, http.StatusNotFound, "post_not_found")
+
+	problem = apperr.Problem{}
+	code = test.do(t, http.MethodGet, "/posts/drill", 0, nil, &problem)
+	assertProblem(t, code, problem, http.StatusBadRequest, "invalid_post_id")
+}
+
+func TestBulkUpdatePosts(t *testing.T) {
+	test := newHandlerTest(t)
+	drill := test.createPost(t, 1, "Cordless drill")
+	press := test.createPost(t, 1, "Drill press")
+	ladder := test.createPost(t, 2, "Ladder")
+
+	var result BulkResult
+	code := test.do(t, http.MethodPost, "/posts/bulk", 1, map[string]interface{}{"action": "pause", "ids": []uint{drill, press}}, &result)
+	if code != http.StatusOK || !result.Applied || len(result.Results) != 2 {
+		t.Fatalf("got %d with %+v", code, result)
+	}
+	for _, postId := range []uint{drill, press} {
+		if post, err := test.posts.GetPostByID(context.Background(), postId); err != nil || post.IsActive {
+			t.Errorf("post %d was not paused: %+v, %v", postId, post, err)
+		}
+	}
+
+	// Another owner's post rolls back the whole run.
+	result = BulkResult{}
+	code = test.do(t, http.MethodPost, "/posts/bulk", 1, map[string]interface{}{"action": "resume", "ids": []uint{drill, ladder}}, &result)
+	if code != http.StatusUnprocessableEntity || result.Applied {
+		t.Fatalf("got %d with %+v", code, result)
+	}
+	if post, err := test.posts.GetPostByID(context.Background(), drill); err != nil || post.IsActive {
+		t.Errorf("rolled back run resumed %+v, %v", post, err)
+	}
+
+	result = BulkResult{}
+	dto := map[string]interface{}{"action": "reprice", "filter": map[string]interface{}{"title": "press"}, "price": map[string]interface{}{"percent": "10"}}
+	code = test.do(t, http.MethodPost, "/posts/bulk", 1, dto, &result)
+	if code != http.StatusOK || len(result.Results) != 1 || result.Results[0].ID != press {
+		t.Fatalf("got %d with %+v", code, result)
+	}
+	if post, err := test.posts.GetPostByID(context.Background(), press); err != nil || post.PriceMinor != 1375 {
+		t.Errorf("got %+v, %v", post, err)
+	}
+}
+
+func TestBulkRepriceOutOfRange(t *testing.T) {
+	test := newHandlerTest(t)
+	ctx := context.Background()
+	postId := test.createPost(t, 1, "Tower crane")
+	// Repeated reprices can walk a price up to where one more step overflows.
+	const price = math.MaxInt64 - 50
+	post, err := test.posts.GetPostByID(ctx, postId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.PriceMinor = price
+	if err := test.posts.UpdatePost(ctx, post, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := []struct {
+		name  string
+		price map[string]interface{}
+	}{
+		{"percent", map[string]interface{}{"percent": "10"}},
+		{"amount", map[string]interface{}{"amount": "1"}},
+		{"amount beyond int64", map[string]interface{}{"amount": "999999999999999"}},
+	}
+	for _, change := range changes {
+		var result BulkResult
+		dto := map[string]interface{}{"action": "reprice", "ids": []uint{postId}, "price": change.price}
+		code := test.do(t, http.MethodPost, "/posts/bulk", 1, dto, &result)
+		if code != http.StatusUnprocessableEntity || result.Applied || len(result.Results) != 1 || result.Results[0].Code != "price_out_of_range" {
+			t.Errorf("%s: got %d with %+v", change.name, code, result)
+		}
+	}
+	if post, err := test.posts.GetPostByID(ctx, postId); err != nil || post.PriceMinor != price {
+		t.Errorf("got %+v, %v", post, err)
+	}
+}
+
+func TestDuplicateClusters(t *testing.T) {
+	test := newHandlerTest(t)
+	ctx := context.Background()
+	addCopies := func(n int, title, description string, ownerId uint) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			post := &Post{Title: title, Description: description, PriceMinor: 1000, Currency: "USD", CategoryID: test.tools.ID, IsActive: true, OwnerId: ownerId}
+			fingerprint := fingerprintOf(post.Title, post.Description, post.Address)
+			if err := test.posts.AddPost(ctx, post, &fingerprint, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	addCopies(2, "Cordless drill", "An 18V drill with two batteries and a charger, barely used.", 1)
+	addCopies(1, "Cordless drill", "An 18V drill with two batteries and a charger, barely used.", 2)
+	addCopies(1, "Garden ladder", "A three-legged orchard ladder, eight feet tall, for hedges and fruit trees.", 3)
+
+	var page DuplicateClusters
+	if code := test.do(t, http.MethodGet, "/admin/posts/duplicates", 0, nil, &page); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if page.Truncated || len(page.Clusters) != 1 || len(page.Clusters[0].Posts) != 3 || !page.Clusters[0].CrossOwner {
+		t.Fatalf("got %+v", page)
+	}
+
+	// 142 copies make 10011 pairs, more than are read.
+	addCopies(139, "Cordless drill", "An 18V drill with two batteries and a charger, barely used.", 1)
+	if code := test.do(t, http.MethodGet, "/admin/posts/duplicates", 0, nil, &page); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if !page.Truncated || len(page.Clusters) != 1 {
+		t.Errorf("got truncated %v with %d clusters", page.Truncated, len(page.Clusters))
+	}
+}
@@ -1,79 +1,512 @@
 package post
 
 import (
+	"context"
+	"fmt"
+	"post-service/category"
+	"post-service/database"
+	"post-service/moderation"
+	"post-service/money"
+	"sort"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Post struct {
 	ID          uint
 	Title       string
 	Description string
-	PricePerDay float64
-	Address     string
-	CategoryID  uint
-	IsActive    bool
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	OwnerId     uint
+	// PriceMinor is the price per day in minor units of Currency.
+	PriceMinor int64
+	Currency   string
+	Address    string
+	CategoryID uint
+	Category   category.Category `gorm:"foreignKey:CategoryID"`
+	IsActive   bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	OwnerId    uint
+	// Status is maintained by the Scheduler; see VisibleAt for what is listed.
+	Status           string
+	PublishAt        *time.Time
+	ExpiresAt        *time.Time
+	ExpiryNotifiedAt *time.Time
+	PricingRules     []PricingRule `gorm:"foreignKey:PostID"`
+}
+
+// Price returns the post's price per day.
+func (post *Post) Price() money.Money {
+	return money.Money{Minor: post.PriceMinor, Currency: post.Currency}
+}
+
+// PostFingerprint stores a post's Fingerprint, with its LSH bands for
+// finding similar posts.
+type PostFingerprint struct {
+	PostID    uint `gorm:"primaryKey;autoIncrement:false"`
+	Signature []byte
+	UpdatedAt time.Time
+}
+
+type PostFingerprintBand struct {
+	PostID uint  `gorm:"primaryKey;autoIncrement:false"`
+	Band   int16 `gorm:"primaryKey;autoIncrement:false"`
+	Hash   int64
+}
+
+// similarPost is a fingerprinted post found by FindSimilarPosts.
+type similarPost struct {
+	PostID    uint
+	OwnerId   uint
+	Signature []byte
+}
+
+// PostStore keeps posts with their pricing rules, fingerprints and flags.
+// PostRepository keeps them in the database; MemoryPostStore, in this
+// process, stands in for it in tests. Lookups of a missing post fail with
+// gorm.ErrRecordNotFound, and listings are in id order. Changes run their
+// hook, which may be nil, before they are kept; it must not use the store,
+// and an error from it leaves the store unchanged.
+type PostStore interface {
+	AddPost(ctx context.Context, post *Post, fingerprint *Fingerprint, flags []moderation.Flag, hook database.Hook) error
+	UpdatePost(ctx context.Context, updatedpost *Post, fingerprint *Fingerprint, flags []moderation.Flag, hook database.Hook) error
+	DeletePost(ctx context.Context, postId uint, hook database.Hook) error
+	GetPostByID(ctx context.Context, postId uint) (*Post, error)
+	GetPostsByOwnerId(ctx context.Context, ownerId uint, offset, limit int) ([]Post, error)
+	GetAllPosts(ctx context.Context, filter PostFilter, offset, limit int) ([]Post, error)
+	GetPostsByIds(ctx context.Context, ids []uint) ([]Post, error)
+	BulkUpdate(ctx context.Context, ids []uint, filter PostFilter, limit int, apply func(posts []Post) (*PostChanges, error), hook database.Hook) error
+	ReplacePricingRules(ctx context.Context, postId uint, rules []PricingRule, hook database.Hook) error
+	FindSimilarPosts(ctx context.Context, fingerprint Fingerprint, excludeId uint, limit int) ([]similarPost, error)
+	CandidatePairs(ctx context.Context, limit int) ([][2]uint, []similarPost, error)
 }
 
 type PostRepository struct {
-	db *gorm.DB
+	db       *gorm.DB
+	timeouts *database.QueryTimeouts
 }
 
-func NewPostRepository(db *gorm.DB) *PostRepository {
-	return &PostRepository{db: db}
+func NewPostRepository(db *gorm.DB, timeouts *database.QueryTimeouts) *PostRepository {
+	return &PostRepository{db: db, timeouts: timeouts}
 }
 
-func (repo *PostRepository) AddPost(post *Post) error {
-	return repo.db.Create(&post).Error
+// AddPost stores a new post with its fingerprint and the moderation flags
+// raised against it, which get the post's id.
+func (repo *PostRepository) AddPost(ctx context.Context, post *Post, fingerprint *Fingerprint, flags []moderation.Flag, hook database.Hook) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.AddPost")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(&post).Error; err != nil {
+			return err
+		}
+		if err := saveAnalysis(tx, post.ID, fingerprint, flags); err != nil {
+			return err
+		}
+		return database.RunHook(ctx, tx, hook)
+	})
 }
 
-func (repo *PostRepository) UpdatePost(updatedpost *Post) error {
-	return repo.db.Save(updatedpost).Error
+// UpdatePost saves the post. A nil fingerprint keeps the stored one.
+func (repo *PostRepository) UpdatePost(ctx context.Context, updatedpost *Post, fingerprint *Fingerprint, flags []moderation.Flag, hook database.Hook) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.UpdatePost")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Save(updatedpost).Error; err != nil {
+			return err
+		}
+		if err := saveAnalysis(tx, updatedpost.ID, fingerprint, flags); err != nil {
+			return err
+		}
+		return database.RunHook(ctx, tx, hook)
+	})
 }
 
-func (repo *PostRepository) DeletePost(postId uint) error {
-	return repo.db.Delete(&Post{}, postId).Error
+// saveAnalysis stores what the checks on a post's text found.
+func saveAnalysis(tx *gorm.DB, postId uint, fingerprint *Fingerprint, flags []moderation.Flag) error {
+	if fingerprint != nil {
+		if err := saveFingerprint(tx, postId, *fingerprint); err != nil {
+			return err
+		}
+	}
+	for i := range flags {
+		flags[i].PostID = postId
+	}
+	if len(flags) > 0 {
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&flags).Error
+	}
+	return nil
+}
+
+func saveFingerprint(tx *gorm.DB, postId uint, fingerprint Fingerprint) error {
+	stored := PostFingerprint{PostID: postId, Signature: fingerprint.bytes(), UpdatedAt: time.Now()}
+	if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&stored).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("post_id = ?", postId).Delete(&PostFingerprintBand{}).Error; err != nil {
+		return err
+	}
+	bands := make([]PostFingerprintBand, 0, lshBands)
+	for band, hash := range fingerprint.bands() {
+		bands = append(bands, PostFingerprintBand{PostID: postId, Band: int16(band), Hash: hash})
+	}
+	return tx.Create(&bands).Error
+}
+
+func (repo *PostRepository) DeletePost(ctx context.Context, postId uint, hook database.Hook) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.DeletePost")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&Post{}, postId).Error; err != nil {
+			return err
+		}
+		return database.RunHook(ctx, tx, hook)
+	})
 }
 
-func (repo *PostRepository) GetPostByID(postId uint) (*Post, error) {
+func (repo *PostRepository) GetPostByID(ctx context.Context, postId uint) (*Post, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.GetPostByID")
+	defer cancel()
+
 	var post Post
-	err := repo.db.First(&post, postId).Error
+	err := repo.db.WithContext(ctx).Joins("Category").Preload("PricingRules").First(&post, postId).Error
 	if err != nil {
 		return nil, err
 	}
 	return &post, nil
 }
 
-func (repo *PostRepository) GetPostsByOwnerId(ownerId uint, offset, limit int) ([]Post, error) {
+func (repo *PostRepository) GetPostsByOwnerId(ctx context.Context, ownerId uint, offset, limit int) ([]Post, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.GetPostsByOwnerId")
+	defer cancel()
+
 	var posts []Post
-	err := repo.db.Model(&Post{}).Where("owner_id = ?", ownerId).Offset(offset).Limit(limit).Find(&posts).Error
+	err := repo.db.WithContext(ctx).Model(&Post{}).Joins("Category").Preload("PricingRules").Where("posts.owner_id = ?", ownerId).Order("posts.id").Offset(offset).Limit(limit).Find(&posts).Error
 	return posts, err
 }
 
-func (repo *PostRepository) GetAllPosts(minPrice, maxPrice *int, title string, categoryId *uint, offset, limit int) ([]Post, error) {
-	var posts []Post
+// effectivePriceSQL is the day rate of a one-day rental on @day, whose
+// time.Weekday bit is @weekday. It mirrors calendarRule: a date_range rule wins
+// over a weekday rule, and rule validation keeps each kind from overlapping.
+const effectivePriceSQL = `COALESCE((
+		SELECT CASE r.adjustment_type
+			WHEN 'percent' THEN ROUND(posts.price_minor * (10000 + r.adjustment) / 10000.0)
+			ELSE posts.price_minor + r.adjustment
+		END
+		FROM pricing_rules r
+		WHERE r.post_id = posts.id AND (
+			(r.kind = 'date_range' AND r.start_date <= CAST(@day AS DATE) AND r.end_date >= CAST(@day AS DATE))
+			OR (r.kind = 'weekday' AND r.weekdays & @weekday <> 0))
+		ORDER BY r.kind = 'date_range' DESC
+		LIMIT 1
+	), posts.price_minor)`
+
+// PostFilter narrows the posts listed or exported. Zero fields do not filter.
+type PostFilter struct {
+	CategoryId *uint
+	OwnerId    *uint
+	Title      string
+	// MinPrice and MaxPrice hold a bound in minor units for each currency the
+	// requested bound converts to. Posts in other currencies never match.
+	MinPrice map[string]int64
+	MaxPrice map[string]int64
+	// PriceOn compares MinPrice and MaxPrice with the price after the pricing
+	// rules for that day, rather than with PriceMinor.
+	PriceOn *time.Time
+	// VisibleAt limits the filter to posts publicly listed at that time.
+	VisibleAt *time.Time
+}
+
+func (filter PostFilter) apply(query *gorm.DB) *gorm.DB {
+	if filter.CategoryId != nil && *filter.CategoryId > 0 {
+		query = query.Where("posts.category_id = ?", filter.CategoryId)
+	}
+
+	if filter.OwnerId != nil {
+		query = query.Where("posts.owner_id = ?", filter.OwnerId)
+	}
 
-	query := repo.db.Model(&Post{})
-	if categoryId != nil && *categoryId > 0 {
-		query = query.Where("category_id = ?", categoryId)
+	if filter.Title != "" {
+		// LOWER with LIKE matches as ILIKE does, and also runs on SQLite.
+		query = query.Where("LOWER(posts.title) LIKE LOWER(?)", "%"+filter.Title+"%")
 	}
 
-	if title != "" {
-		query = query.Where("title ILIKE ?", "%"+title+"%")
+	price, priceArgs := "posts.price_minor", map[string]interface{}{}
+	if filter.PriceOn != nil {
+		day := dayOf(*filter.PriceOn)
+		price = effectivePriceSQL
+		priceArgs["day"] = day.Format(dateLayout)
+		priceArgs["weekday"] = 1 << uint(day.Weekday())
 	}
 
-	if minPrice != nil && *minPrice > 0 {
-		query = query.Where("price_per_day >= ?", minPrice)
+	if len(filter.MinPrice) > 0 {
+		query = query.Where(price+" >= "+boundSQL("min", filter.MinPrice, priceArgs), priceArgs)
 	}
 
-	if maxPrice != nil && *maxPrice > 0 {
-		query = query.Where("price_per_day <= ?", maxPrice)
+	if len(filter.MaxPrice) > 0 {
+		query = query.Where(price+" <= "+boundSQL("max", filter.MaxPrice, priceArgs), priceArgs)
+	}
+
+	if filter.VisibleAt != nil {
+		query = query.Where("posts.is_active").
+			Where("posts.publish_at IS NULL OR posts.publish_at <= ?", filter.VisibleAt).
+			Where("posts.expires_at IS NULL OR posts.expires_at > ?", filter.VisibleAt)
+	}
+	return query
+}
+
+// boundSQL picks the bound in the post's own currency, adding the named
+// arguments it uses to args.
+func boundSQL(name string, bounds map[string]int64, args map[string]interface{}) string {
+	currencies := make([]string, 0, len(bounds))
+	for currency := range bounds {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	var sql strings.Builder
+	sql.WriteString("CASE posts.currency")
+	for i, currency := range currencies {
+		currencyArg, boundArg := fmt.Sprintf("%s_currency_%d", name, i), fmt.Sprintf("%s_bound_%d", name, i)
+		args[currencyArg], args[boundArg] = currency, bounds[currency]
+		fmt.Fprintf(&sql, " WHEN @%s THEN @%s", currencyArg, boundArg)
+	}
+	sql.WriteString(" END")
+	return sql.String()
+}
+
+func (repo *PostRepository) GetAllPosts(ctx context.Context, filter PostFilter, offset, limit int) ([]Post, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.GetAllPosts")
+	defer cancel()
+
+	var posts []Post
+	query := filter.apply(repo.db.WithContext(ctx).Model(&Post{}).Joins("Category").Preload("PricingRules"))
+	err := query.Order("posts.id").Offset(offset).Limit(limit).Find(&posts).Error
+	return posts, err
+}
+
+// GetPostsAfter returns up to limit posts matching filter with an id greater
+// than afterId, in id order. Paging on the id keeps every batch an index range
+// scan, however deep into the table an export has got.
+func (repo *PostRepository) GetPostsAfter(ctx context.Context, filter PostFilter, afterId uint, limit int) ([]Post, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.GetPostsAfter")
+	defer cancel()
+
+	var posts []Post
+	query := filter.apply(repo.db.WithContext(ctx).Model(&Post{}).Joins("Category"))
+	err := query.Where("posts.id > ?", afterId).Order("posts.id").Limit(limit).Find(&posts).Error
+	return posts, err
+}
+
+// PostChanges are the writes a bulk operation makes to the posts it locked.
+type PostChanges struct {
+	Save   []*Post
+	Delete []uint
+}
+
+// BulkUpdate locks the posts with the given ids, or the first limit posts
+// matching filter when ids is empty, and applies the changes apply decides on
+// in the same transaction. An error from apply rolls the transaction back.
+func (repo *PostRepository) BulkUpdate(ctx context.Context, ids []uint, filter PostFilter, limit int, apply func(posts []Post) (*PostChanges, error), hook database.Hook) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.BulkUpdate")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&Post{}).Clauses(clause.Locking{Strength: "UPDATE"})
+		if len(ids) > 0 {
+			query = query.Where("posts.id IN ?", ids)
+		} else {
+			query = filter.apply(query)
+		}
+
+		var posts []Post
+		if err := query.Preload("PricingRules").Order("posts.id").Limit(limit).Find(&posts).Error; err != nil {
+			return err
+		}
+
+		changes, err := apply(posts)
+		if err != nil {
+			return err
+		}
+		for _, post := range changes.Save {
+			if err := tx.Omit(clause.Associations).Save(post).Error; err != nil {
+				return err
+			}
+		}
+		if len(changes.Delete) > 0 {
+			if err := tx.Delete(&Post{}, changes.Delete).Error; err != nil {
+				return err
+			}
+		}
+		return database.RunHook(ctx, tx, hook)
+	})
+}
+
+// GetPostsByIds loads the posts with the given ids, with their categories and
+// pricing rules.
+func (repo *PostRepository) GetPostsByIds(ctx context.Context, ids []uint) ([]Post, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.GetPostsByIds")
+	defer cancel()
+
+	var posts []Post
+	err := repo.db.WithContext(ctx).Model(&Post{}).Joins("Category").Preload("PricingRules").
+		Where("posts.id IN ?", ids).Order("posts.id").Find(&posts).Error
+	return posts, err
+}
+
+// PublishDuePosts marks scheduled posts whose publish time has passed as live
+// and returns them.
+func (repo *PostRepository) PublishDuePosts(ctx context.Context, now time.Time) ([]Post, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.PublishDuePosts")
+	defer cancel()
+
+	var posts []Post
+	err := repo.db.WithContext(ctx).Model(&posts).Clauses(clause.Returning{}).
+		Where("status = ? AND publish_at <= ?", PostScheduled, now).
+		Update("status", PostLive).Error
+	return posts, err
+}
+
+// ExpireDuePosts marks posts whose expiry time has passed as expired and
+// returns them.
+func (repo *PostRepository) ExpireDuePosts(ctx context.Context, now time.Time) ([]Post, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.ExpireDuePosts")
+	defer cancel()
+
+	var posts []Post
+	err := repo.db.WithContext(ctx).Model(&posts).Clauses(clause.Returning{}).
+		Where("status <> ? AND expires_at <= ?", PostExpired, now).
+		Update("status", PostExpired).Error
+	return posts, err
+}
+
+// ClaimExpiringPosts marks up to limit listed posts expiring before the given
+// time as notified and returns them. Rows locked by another claim are skipped.
+func (repo *PostRepository) ClaimExpiringPosts(ctx context.Context, now, before time.Time, limit int) ([]Post, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.ClaimExpiringPosts")
+	defer cancel()
+
+	var posts []Post
+	err := repo.db.WithContext(ctx).Raw(`UPDATE posts SET expiry_notified_at = ?
+		WHERE id IN (
+			SELECT id FROM posts
+			WHERE is_active AND expiry_notified_at IS NULL AND expires_at > ? AND expires_at <= ?
+			ORDER BY expires_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, now, now, before, limit).Scan(&posts).Error
+	return posts, err
+}
+
+// FindSimilarPosts returns up to limit fingerprinted posts sharing an LSH
+// band with fingerprint, leaving out excludeId.
+func (repo *PostRepository) FindSimilarPosts(ctx context.Context, fingerprint Fingerprint, excludeId uint, limit int) ([]similarPost, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.FindSimilarPosts")
+	defer cancel()
+
+	bands := make([][]interface{}, 0, lshBands)
+	for band, hash := range fingerprint.bands() {
+		bands = append(bands, []interface{}{band, hash})
 	}
+	matching := repo.db.Model(&PostFingerprintBand{}).Select("DISTINCT post_id").Where("(band, hash) IN ?", bands)
 
-	err := query.Offset(offset).Limit(limit).Find(&posts).Error
+	var posts []similarPost
+	err := repo.db.WithContext(ctx).Table("post_fingerprints").
+		Select("post_fingerprints.post_id, posts.owner_id, post_fingerprints.signature").
+		Joins("JOIN posts ON posts.id = post_fingerprints.post_id").
+		Where("post_fingerprints.post_id IN (?) AND post_fingerprints.post_id <> ?", matching, excludeId).
+		Limit(limit).Scan(&posts).Error
 	return posts, err
 }
+
+// CandidatePairs returns up to limit pairs of posts that share an LSH band,
+// with the lower id first, and the fingerprints of the posts involved.
+func (repo *PostRepository) CandidatePairs(ctx context.Context, limit int) ([][2]uint, []similarPost, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.CandidatePairs")
+	defer cancel()
+
+	var rows []struct {
+		First  uint
+		Second uint
+	}
+	err := repo.db.WithContext(ctx).Raw(`SELECT DISTINCT a.post_id AS first, b.post_id AS second
+		FROM post_fingerprint_bands a
+		JOIN post_fingerprint_bands b ON b.band = a.band AND b.hash = a.hash AND b.post_id > a.post_id
+		ORDER BY first, second
+		LIMIT ?`, limit).Scan(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return nil, nil, err
+	}
+
+	pairs := make([][2]uint, 0, len(rows))
+	ids := map[uint]bool{}
+	for _, row := range rows {
+		pairs = append(pairs, [2]uint{row.First, row.Second})
+		ids[row.First], ids[row.Second] = true, true
+	}
+	idList := make([]uint, 0, len(ids))
+	for id := range ids {
+		idList = append(idList, id)
+	}
+
+	var posts []similarPost
+	err = repo.db.WithContext(ctx).Table("post_fingerprints").
+		Select("post_fingerprints.post_id, posts.owner_id, post_fingerprints.signature").
+		Joins("JOIN posts ON posts.id = post_fingerprints.post_id").
+		Where("post_fingerprints.post_id IN ?", idList).Scan(&posts).Error
+	return pairs, posts, err
+}
+
+// BackfillFingerprints fingerprints up to limit posts that have none yet, such
+// as posts created before fingerprints existed, and returns how many it did.
+func (repo *PostRepository) BackfillFingerprints(ctx context.Context, limit int) (int, error) {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.BackfillFingerprints")
+	defer cancel()
+
+	var posts []Post
+	err := repo.db.WithContext(ctx).Model(&Post{}).
+		Where("NOT EXISTS (SELECT 1 FROM post_fingerprints f WHERE f.post_id = posts.id)").
+		Order("posts.id").Limit(limit).Find(&posts).Error
+	if err != nil || len(posts) == 0 {
+		return 0, err
+	}
+
+	err = repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, post := range posts {
+			if err := saveFingerprint(tx, post.ID, fingerprintOf(post.Title, post.Description, post.Address)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(posts), nil
+}
+
+// ReplacePricingRules swaps a post's pricing rules for rules.
+func (repo *PostRepository) ReplacePricingRules(ctx context.Context, postId uint, rules []PricingRule, hook database.Hook) error {
+	ctx, cancel := repo.timeouts.Context(ctx, "PostRepository.ReplacePricingRules")
+	defer cancel()
+
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("post_id = ?", postId).Delete(&PricingRule{}).Error; err != nil {
+			return err
+		}
+		for i := range rules {
+			rules[i].PostID = postId
+		}
+		if len(rules) > 0 {
+			if err := tx.Create(&rules).Error; err != nil {
+				return err
+			}
+		}
+		return database.RunHook(ctx, tx, hook)
+	})
+}
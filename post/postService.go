@@ -1,163 +1,356 @@
 package post
 
 import (
+	"context"
 	"errors"
+	"post-service/apperr"
+	"post-service/audit"
 	"post-service/category"
+	"post-service/logging"
+	"post-service/moderation"
+	"post-service/money"
+	"post-service/ratelimit"
 	"strconv"
-	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+var tracer = otel.Tracer("post-service/post")
+
 type PostService struct {
-	catRepo *category.CategoryRepository
-	repo    *PostRepository
+	catRepo  category.CategoryStore
+	repo     PostStore
+	schedule *ScheduleConfig
+	exchange *money.Exchange
+	events   *PostBroker
+	postCap  *ratelimit.DailyCap
+	policy   *moderation.Policy
+	auditLog *audit.AuditService
 }
 
-func NewPostService(catRepo *category.CategoryRepository, repo *PostRepository) *PostService {
-	return &PostService{catRepo: catRepo, repo: repo}
+func NewPostService(catRepo category.CategoryStore, repo PostStore, schedule *ScheduleConfig, exchange *money.Exchange, events *PostBroker, postCap *ratelimit.DailyCap, policy *moderation.Policy, auditLog *audit.AuditService) *PostService {
+	return &PostService{catRepo: catRepo, repo: repo, schedule: schedule, exchange: exchange, events: events, postCap: postCap, policy: policy, auditLog: auditLog}
 }
 
-var ErrPostNotFound = errors.New("post not found")
-var ErrForbidden = errors.New("not allowed to update post")
-
-func (service *PostService) CreatePost(userId uint, newPost struct {
-	Title       string  `json:"title" validate:"required"`
-	Description string  `json:"description" validate:"required"`
-	PricePerDay float64 `json:"pricePerDay" validate:"required"`
-	Address     string  `json:"address" validate:"required"`
-	Category    string  `json:"category" validate:"required"`
-}) (*uint, error) {
-	category, err := service.catRepo.GetCategoryByName(newPost.Category)
+var ErrPostNotFound = apperr.NotFound("post_not_found", "post not found")
+var ErrForbidden = apperr.Forbidden("post_forbidden", "you are not allowed to modify this post")
+var ErrInvalidPostId = apperr.BadRequest("invalid_post_id", "post id must be a positive integer")
+var ErrUnknownCategory = apperr.Validation("unknown_category", "category does not exist",
+	apperr.FieldError{Field: "category", Rule: "exists", Message: "category does not exist"})
+var ErrInactiveCategory = apperr.Validation("inactive_category", "category is not accepting new posts",
+	apperr.FieldError{Field: "category", Rule: "active", Message: "category is not accepting new posts"})
+var ErrUnsupportedCurrency = apperr.Validation("unsupported_currency", "currency is not supported",
+	apperr.FieldError{Field: "currency", Rule: "iso4217", Message: "currency is not supported"})
+var ErrPriceTooPrecise = apperr.Validation("price_too_precise", "pricePerDay has more decimal places than its currency allows",
+	apperr.FieldError{Field: "pricePerDay", Rule: "decimals", Message: "pricePerDay has more decimal places than its currency allows"})
+var ErrPriceOutOfRange = apperr.Validation("price_out_of_range", "pricePerDay is too large",
+	apperr.FieldError{Field: "pricePerDay", Rule: "max", Message: "pricePerDay is too large"})
+var ErrCurrencyHasRules = apperr.Validation("currency_has_rules", "remove absolute pricing rules before changing the currency",
+	apperr.FieldError{Field: "currency", Rule: "pricing_rules", Message: "remove absolute pricing rules before changing the currency"})
+var ErrInvalidSchedule = apperr.Validation("invalid_schedule", "expiresAt must be after publishAt and in the future",
+	apperr.FieldError{Field: "expiresAt", Rule: "gtfield", Message: "expiresAt must be after publishAt and in the future"})
+var ErrScheduleTooLong = apperr.Validation("schedule_too_long", "a post cannot be listed longer than the listing period without renewal",
+	apperr.FieldError{Field: "expiresAt", Rule: "max", Message: "a post cannot be listed longer than the listing period without renewal"})
+var ErrDailyPostCap = apperr.TooManyRequests("daily_post_cap", "you have reached the number of posts you can create today")
+
+// CreatePost stores a new post and returns its id, with warnings about
+// text the content policy masked or flagged and likely copies of the owner's
+// other posts.
+func (service *PostService) CreatePost(ctx context.Context, userId uint, newPost PostDto) (*uint, []PostWarning, error) {
+	ctx, span := tracer.Start(ctx, "PostService.CreatePost")
+	defer span.End()
+
+	category, err := service.resolveNewPostCategory(ctx, newPost.Category)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	price, err := service.postPrice(newPost.PricePerDay, newPost.Currency)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	publishAt, expiresAt, err := service.schedule.window(newPost.PublishAt, newPost.ExpiresAt, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	review, err := service.reviewText(ctx, userId, 0, &newPost.Title, &newPost.Description, newPost.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	capKey := strconv.FormatUint(uint64(userId), 10)
+	quota, err := service.postCap.Take(ctx, capKey, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !quota.Allowed {
+		return nil, nil, ErrDailyPostCap
 	}
 
 	post := Post{
 		Title:       newPost.Title,
 		Description: newPost.Description,
-		PricePerDay: newPost.PricePerDay,
+		PriceMinor:  price.Minor,
+		Currency:    price.Currency,
 		Address:     newPost.Address,
 		CategoryID:  category.ID,
 		IsActive:    true,
-		CreatedAt:   time.Now(),
+		CreatedAt:   now,
 		OwnerId:     userId,
+		Status:      scheduleStatus(&publishAt, &expiresAt, now),
+		PublishAt:   &publishAt,
+		ExpiresAt:   &expiresAt,
 	}
 
-	if err := service.repo.AddPost(&post); err != nil {
+	err = service.repo.AddPost(ctx, &post, &review.fingerprint, review.flags, func(ctx context.Context) error {
+		return service.auditLog.Record(ctx, userId, AuditPostCreate, audit.TargetPost, post.ID, nil, snapshotPost(&post))
+	})
+	if err != nil {
+		// A post that was not created does not count against the cap. Refund
+		// it even if the client went away.
+		if refundErr := service.postCap.Refund(context.WithoutCancel(ctx), capKey, now); refundErr != nil {
+			logging.FromContext(ctx).Error("error refunding daily post cap", zap.Uint("userId", userId), zap.Error(refundErr))
+		}
+		return nil, nil, err
+	}
+	post.Category = *category
+	service.events.publishPost(PostEventUpdated, nil, &post, now)
+
+	return &post.ID, review.warnings, nil
+}
+
+// resolveNewPostCategory finds the category a new post is filed under and
+// checks that it still accepts posts.
+func (service *PostService) resolveNewPostCategory(ctx context.Context, categoryRef string) (*category.Category, error) {
+	category, _, err := service.catRepo.ResolveCategory(ctx, categoryRef)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownCategory
+		}
 		return nil, err
 	}
+	if !category.IsActive {
+		return nil, ErrInactiveCategory
+	}
+	return category, nil
+}
+
+// postPrice converts a submitted price to minor units of its currency, which
+// defaults to the exchange's default currency.
+func (service *PostService) postPrice(amount money.Decimal, currency string) (money.Money, error) {
+	if currency == "" {
+		currency = service.exchange.DefaultCurrency
+	}
+	price, err := money.FromDecimal(amount, currency)
+	switch {
+	case errors.Is(err, money.ErrUnsupportedCurrency):
+		return money.Money{}, ErrUnsupportedCurrency
+	case errors.Is(err, money.ErrTooPrecise):
+		return money.Money{}, ErrPriceTooPrecise
+	case err != nil:
+		return money.Money{}, ErrPriceOutOfRange
+	case price.Minor <= 0:
+		return money.Money{}, ErrNonPositivePrice
+	}
+	return price, nil
+}
 
-	return &post.ID, nil
+// DisplayPrice is a post's price converted to the currency a client asked for.
+type DisplayPrice struct {
+	Currency    string        `json:"currency"`
+	PricePerDay money.Decimal `json:"pricePerDay"`
+	PriceToday  money.Decimal `json:"priceToday"`
 }
 
 type PostResponse struct {
-	Title       string  `json:"title"`
-	Description string  `json:"description"`
-	PricePerDay float64 `json:"pricePerDay"`
-	Address     string  `json:"address"`
-	Category    string  `json:"category"`
+	ID          uint          `json:"id"`
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	PricePerDay money.Decimal `json:"pricePerDay"`
+	Currency    string        `json:"currency"`
+	Address     string        `json:"address"`
+	Category    string        `json:"category"`
+	PriceToday  money.Decimal `json:"priceToday"`
+	Display     *DisplayPrice `json:"display,omitempty"`
+	Status      string        `json:"status,omitempty"`
+	PublishAt   *time.Time    `json:"publishAt,omitempty"`
+	ExpiresAt   *time.Time    `json:"expiresAt,omitempty"`
 }
 
 type PostResponseWithOwner struct {
-	Title       string  `json:"title"`
-	Description string  `json:"description"`
-	PricePerDay float64 `json:"pricePerDay"`
-	Address     string  `json:"address"`
-	Category    string  `json:"category"`
-	OwnerId     uint    `json:"ownerId"`
+	ID          uint          `json:"id"`
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	PricePerDay money.Decimal `json:"pricePerDay"`
+	Currency    string        `json:"currency"`
+	Address     string        `json:"address"`
+	Category    string        `json:"category"`
+	PriceToday  money.Decimal `json:"priceToday"`
+	OwnerId     uint          `json:"ownerId"`
+	PublishAt   *time.Time    `json:"publishAt,omitempty"`
+	ExpiresAt   *time.Time    `json:"expiresAt,omitempty"`
 }
 
-func (service *PostService) GetAllPosts(categoryName, title, priceStr string, page int) (*[]PostResponse, error) {
-	var postResponseList []PostResponse
-	var categoryId *uint
-	if categoryName != "" {
-		category, err := service.catRepo.GetCategoryByName(categoryName)
-		if err != nil {
-			return nil, err
-		}
-		categoryId = &category.ID
+// priceToday is the post's price for a one-day rental today.
+func priceToday(post *Post) money.Money {
+	price, _ := priceOn(post.PriceMinor, post.PricingRules, dayOf(time.Now()), 1)
+	return money.Money{Minor: price, Currency: post.Currency}
+}
+
+func toPostResponse(post Post) PostResponse {
+	return PostResponse{
+		ID:          post.ID,
+		Title:       post.Title,
+		Description: post.Description,
+		PricePerDay: post.Price().Amount(),
+		Currency:    post.Currency,
+		Address:     post.Address,
+		Category:    post.Category.Name,
+		PriceToday:  priceToday(&post).Amount(),
+		PublishAt:   post.PublishAt,
+		ExpiresAt:   post.ExpiresAt,
 	}
+}
 
-	var minPrice, maxPrice *int
+// displayPrice converts the post's prices to currency, or returns nil when no
+// currency was asked for or there is no rate for it.
+func (service *PostService) displayPrice(post *Post, currency string) *DisplayPrice {
+	if currency == "" {
+		return nil
+	}
+	pricePerDay, ok := service.exchange.Convert(post.Price(), currency)
+	if !ok {
+		return nil
+	}
+	today, _ := service.exchange.Convert(priceToday(post), currency)
+	return &DisplayPrice{Currency: currency, PricePerDay: pricePerDay.Amount(), PriceToday: today.Amount()}
+}
 
-	if priceStr != "" {
-		price := strings.Split(priceStr, "-")
-		if price[0] != "" {
-			min, err := strconv.Atoi(price[0])
-			if err != nil {
-				return nil, err
+// GetAllPosts lists visible posts. Price bounds are in prices.Currency, or the
+// default currency when it is empty, and a non-empty currency also adds
+// converted prices to each post.
+func (service *PostService) GetAllPosts(ctx context.Context, categoryName, title string, prices PriceQuery, page int) (*[]PostResponse, error) {
+	ctx, span := tracer.Start(ctx, "PostService.GetAllPosts")
+	defer span.End()
 
-			}
-			minPrice = &min
-		}
-		if price[1] != "" {
-			max, err := strconv.Atoi(price[1])
-			if err != nil {
-				return nil, err
-			}
-			maxPrice = &max
-		}
-		if minPrice != nil && maxPrice != nil && *minPrice > *maxPrice {
-
-			return nil, errors.New("minimum price cannot be greater than maximum price")
-		}
+	var postResponseList []PostResponse
+	filter, err := service.postFilter(ctx, categoryName, title, prices, false)
+	if err != nil {
+		return nil, err
 	}
+	now := time.Now()
+	filter.VisibleAt = &now
 
 	size := 10
 	offset := (page - 1) * size
 
-	posts, err := service.repo.GetAllPosts(minPrice, maxPrice, title, categoryId, offset, size)
+	posts, err := service.repo.GetAllPosts(ctx, *filter, offset, size)
 	if err != nil {
 		return nil, err
 	}
+	span.SetAttributes(attribute.Int("posts.count", len(posts)))
 
+	currency, _ := money.NormalizeCurrency(prices.Currency)
 	for _, post := range posts {
-		category, err := service.catRepo.GetCategoryById(post.CategoryID)
+		response := toPostResponse(post)
+		response.Display = service.displayPrice(&post, currency)
+		postResponseList = append(postResponseList, response)
+	}
+	return &postResponseList, nil
+}
+
+// postFilter turns the public listing parameters into a PostFilter. Price
+// bounds apply to the price after today's pricing rules, converted to each
+// post's currency. Unless followRedirect is set, a category reached through a
+// redirect alias yields a *category.MovedError.
+func (service *PostService) postFilter(ctx context.Context, categoryName, title string, priceQuery PriceQuery, followRedirect bool) (*PostFilter, error) {
+	now := time.Now()
+	filter := PostFilter{Title: title, PriceOn: &now}
+	if categoryName != "" {
+		cat, redirect, err := service.catRepo.ResolveCategory(ctx, categoryName)
 		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, category.ErrCategoryNotFound
+			}
 			return nil, err
 		}
-		postResponseList = append(postResponseList, PostResponse{
-			Title:       post.Title,
-			Description: post.Description,
-			PricePerDay: post.PricePerDay,
-			Address:     post.Address,
-			Category:    category.Name,
-		})
+		if redirect && !followRedirect {
+			return nil, &category.MovedError{Slug: cat.Slug}
+		}
+		filter.CategoryId = &cat.ID
+	}
 
+	prices, err := priceQuery.parse(service.exchange.DefaultCurrency)
+	if err != nil {
+		return nil, err
 	}
-	return &postResponseList, nil
+	if prices.min != nil {
+		filter.MinPrice = service.priceBounds(*prices.min, prices.currency, true)
+	}
+	if prices.max != nil {
+		filter.MaxPrice = service.priceBounds(*prices.max, prices.currency, false)
+	}
+	return &filter, nil
 }
 
-func (service *PostService) GetPostByID(postId string) (*PostResponseWithOwner, error) {
+// priceBounds converts a price bound in currency to minor units of every
+// currency it can be compared with.
+func (service *PostService) priceBounds(bound money.Decimal, currency string, lower bool) map[string]int64 {
+	bounds := map[string]int64{}
+	for _, target := range service.exchange.Currencies(currency) {
+		if minor, ok := service.exchange.Bound(bound, currency, target, lower); ok {
+			bounds[target] = minor
+		}
+	}
+	return bounds
+}
+
+func (service *PostService) GetPostByID(ctx context.Context, postId string) (*PostResponseWithOwner, error) {
+	ctx, span := tracer.Start(ctx, "PostService.GetPostByID")
+	defer span.End()
+
 	id, err := strconv.ParseUint(postId, 10, 32)
 	if err != nil {
-		return nil, err
+		return nil, ErrInvalidPostId
 	}
-	retrieveedPost, err := service.repo.GetPostByID(uint(id))
+	retrieveedPost, err := service.repo.GetPostByID(ctx, uint(id))
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, err
+			return nil, ErrPostNotFound
 		}
 		return nil, err
 	}
-	category, err := service.catRepo.GetCategoryById(retrieveedPost.CategoryID)
-	if err != nil {
-		return nil, err
+	now := time.Now()
+	if !retrieveedPost.VisibleAt(now) {
+		return nil, ErrPostNotFound
 	}
 
 	return &PostResponseWithOwner{
+		ID:          retrieveedPost.ID,
 		Title:       retrieveedPost.Title,
 		Description: retrieveedPost.Description,
-		PricePerDay: retrieveedPost.PricePerDay,
+		PricePerDay: retrieveedPost.Price().Amount(),
+		Currency:    retrieveedPost.Currency,
 		Address:     retrieveedPost.Address,
-		Category:    category.Name,
+		Category:    retrieveedPost.Category.Name,
+		PriceToday:  priceToday(retrieveedPost).Amount(),
 		OwnerId:     retrieveedPost.OwnerId,
+		PublishAt:   retrieveedPost.PublishAt,
+		ExpiresAt:   retrieveedPost.ExpiresAt,
 	}, nil
 }
 
-func (service *PostService) GetPostsByOwnerId(userId uint, pageStr string) ([]PostResponse, error) {
+func (service *PostService) GetPostsByOwnerId(ctx context.Context, userId uint, pageStr string) ([]PostResponse, error) {
+	ctx, span := tracer.Start(ctx, "PostService.GetPostsByOwnerId")
+	defer span.End()
+
 	var postResponseList []PostResponse
 	page, err := strconv.Atoi(pageStr)
 	if err != nil || page < 1 {
@@ -167,59 +360,58 @@ func (service *PostService) GetPostsByOwnerId(userId uint, pageStr string) ([]Po
 	size := 10
 
 	offset := (page - 1) * size
-	posts, err := service.repo.GetPostsByOwnerId(userId, offset, size)
+	posts, err := service.repo.GetPostsByOwnerId(ctx, userId, offset, size)
 	if err != nil {
 		return nil, err
 	}
 
+	now := time.Now()
 	for _, post := range posts {
-		category, err := service.catRepo.GetCategoryById(post.CategoryID)
-		if err != nil {
-			return nil, err
-		}
-
-		postResponseList = append(postResponseList, PostResponse{
-			Title:       post.Title,
-			Description: post.Description,
-			PricePerDay: post.PricePerDay,
-			Address:     post.Address,
-			Category:    category.Name,
-		})
+		response := toPostResponse(post)
+		response.Status = scheduleStatus(post.PublishAt, post.ExpiresAt, now)
+		postResponseList = append(postResponseList, response)
 	}
 	return postResponseList, nil
 }
 
-func (service *PostService) UpdatePost(userId uint, postIdStr string, updatedPost struct {
-	Title       string  `json:"title" validate:"required"`
-	Description string  `json:"description" validate:"required"`
-	PricePerDay float64 `json:"pricePerDay" validate:"required"`
-	Address     string  `json:"address" validate:"required"`
-	Category    string  `json:"category" validate:"required"`
-	IsActive    bool    `json:"isActive" validate:"required"`
-}) error {
+// UpdatePost changes the given fields of the caller's post. Changed text is
+// reviewed like a new post's.
+func (service *PostService) UpdatePost(ctx context.Context, userId uint, postIdStr string, updatedPost UpdatePostDto) ([]PostWarning, error) {
+	ctx, span := tracer.Start(ctx, "PostService.UpdatePost")
+	defer span.End()
+
 	postId, err := strconv.ParseUint(postIdStr, 10, 32)
 	if err != nil {
-		return err
+		return nil, ErrInvalidPostId
 	}
-	post, err := service.repo.GetPostByID(uint(postId))
+	post, err := service.repo.GetPostByID(ctx, uint(postId))
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return ErrPostNotFound
+			return nil, ErrPostNotFound
 		}
-		return err
+		return nil, err
 	}
 
 	if userId != post.OwnerId {
-		return ErrForbidden
+		return nil, ErrForbidden
 	}
+	now := time.Now()
+	previous := listedKey(post, now)
+	original := *post
 
-	var categoryId *uint
+	var newCategory *category.Category
 	if updatedPost.Category != "" {
-		category, err := service.catRepo.GetCategoryByName(updatedPost.Category)
+		category, _, err := service.catRepo.ResolveCategory(ctx, updatedPost.Category)
 		if err != nil {
-			return err
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrUnknownCategory
+			}
+			return nil, err
 		}
-		categoryId = &category.ID
+		if !category.IsActive && category.ID != post.CategoryID {
+			return nil, ErrInactiveCategory
+		}
+		newCategory = category
 	}
 
 	if updatedPost.Title != "" {
@@ -228,31 +420,130 @@ func (service *PostService) UpdatePost(userId uint, postIdStr string, updatedPos
 	if updatedPost.Description != "" {
 		post.Description = updatedPost.Description
 	}
-	if updatedPost.PricePerDay != 0 {
-		post.PricePerDay = updatedPost.PricePerDay
+	if !updatedPost.PricePerDay.IsZero() || updatedPost.Currency != "" {
+		amount, currency := updatedPost.PricePerDay, updatedPost.Currency
+		if amount.IsZero() {
+			amount = post.Price().Amount()
+		}
+		if currency == "" {
+			currency = post.Currency
+		}
+		price, err := service.postPrice(amount, currency)
+		if err != nil {
+			return nil, err
+		}
+		if price.Currency != post.Currency && hasAbsoluteRules(post.PricingRules) {
+			return nil, ErrCurrencyHasRules
+		}
+		if err := checkRulePrices(price.Minor, post.PricingRules); err != nil {
+			return nil, err
+		}
+		post.PriceMinor, post.Currency = price.Minor, price.Currency
 	}
 	if updatedPost.Address != "" {
 		post.Address = updatedPost.Address
 	}
-	if categoryId != nil {
-		post.CategoryID = *categoryId
+	if newCategory != nil {
+		post.CategoryID = newCategory.ID
+		post.Category = *newCategory
 	}
-	post.IsActive = updatedPost.IsActive
-	post.UpdatedAt = time.Now()
 
-	err = service.repo.UpdatePost(post)
+	if updatedPost.PublishAt != nil || updatedPost.ExpiresAt != nil {
+		publishAt := updatedPost.PublishAt
+		if publishAt == nil {
+			publishAt = post.PublishAt
+		}
+		expiresAt := updatedPost.ExpiresAt
+		if expiresAt == nil && updatedPost.PublishAt == nil {
+			expiresAt = post.ExpiresAt
+		}
+		start, end, err := service.schedule.window(publishAt, expiresAt, now)
+		if err != nil {
+			return nil, err
+		}
+		post.PublishAt, post.ExpiresAt = &start, &end
+		post.Status = scheduleStatus(post.PublishAt, post.ExpiresAt, now)
+		post.ExpiryNotifiedAt = nil
+	}
+	post.IsActive = *updatedPost.IsActive
+	post.UpdatedAt = now
+
+	var fingerprint *Fingerprint
+	review := &postReview{}
+	if post.Title != original.Title || post.Description != original.Description || post.Address != original.Address {
+		if review, err = service.reviewText(ctx, post.OwnerId, post.ID, &post.Title, &post.Description, post.Address); err != nil {
+			return nil, err
+		}
+		fingerprint = &review.fingerprint
+	}
+
+	err = service.repo.UpdatePost(ctx, post, fingerprint, review.flags, func(ctx context.Context) error {
+		return service.auditLog.Record(ctx, userId, AuditPostUpdate, audit.TargetPost, post.ID, snapshotPost(&original), snapshotPost(post))
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	service.events.publishPost(PostEventUpdated, previous, post, now)
+	return review.warnings, nil
 }
 
-func (service *PostService) DeletePost(postIdStr string, userId uint) error {
+// RenewPost extends a post's listing by the listing period, counted from now
+// or from its publish time if that is later. An expired post goes live again.
+func (service *PostService) RenewPost(ctx context.Context, userId uint, postIdStr string) (*time.Time, error) {
+	ctx, span := tracer.Start(ctx, "PostService.RenewPost")
+	defer span.End()
+
 	postId, err := strconv.ParseUint(postIdStr, 10, 32)
 	if err != nil {
-		return err
+		return nil, ErrInvalidPostId
+	}
+	post, err := service.repo.GetPostByID(ctx, uint(postId))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPostNotFound
+		}
+		return nil, err
+	}
+
+	if post.OwnerId != userId {
+		return nil, ErrForbidden
+	}
+
+	now := time.Now()
+	previous := listedKey(post, now)
+	before := snapshotPost(post)
+	start := now
+	if post.PublishAt != nil && post.PublishAt.After(now) {
+		start = *post.PublishAt
+	}
+	expiresAt := start.Add(service.schedule.ListingDuration)
+	if post.ExpiresAt != nil && post.ExpiresAt.After(expiresAt) {
+		expiresAt = *post.ExpiresAt
+	}
+
+	post.ExpiresAt = &expiresAt
+	post.ExpiryNotifiedAt = nil
+	post.Status = scheduleStatus(post.PublishAt, post.ExpiresAt, now)
+	post.UpdatedAt = now
+	err = service.repo.UpdatePost(ctx, post, nil, nil, func(ctx context.Context) error {
+		return service.auditLog.Record(ctx, userId, AuditPostRenew, audit.TargetPost, post.ID, before, snapshotPost(post))
+	})
+	if err != nil {
+		return nil, err
+	}
+	service.events.publishPost(PostEventUpdated, previous, post, now)
+	return post.ExpiresAt, nil
+}
+
+func (service *PostService) DeletePost(ctx context.Context, postIdStr string, userId uint) error {
+	ctx, span := tracer.Start(ctx, "PostService.DeletePost")
+	defer span.End()
+
+	postId, err := strconv.ParseUint(postIdStr, 10, 32)
+	if err != nil {
+		return ErrInvalidPostId
 	}
-	post, err := service.repo.GetPostByID(uint(postId))
+	post, err := service.repo.GetPostByID(ctx, uint(postId))
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return ErrPostNotFound
@@ -265,9 +556,13 @@ func (service *PostService) DeletePost(postIdStr string, userId uint) error {
 		return ErrForbidden
 	}
 
-	err = service.repo.DeletePost(uint(postId))
+	previous := listedKey(post, time.Now())
+	err = service.repo.DeletePost(ctx, uint(postId), func(ctx context.Context) error {
+		return service.auditLog.Record(ctx, userId, AuditPostDelete, audit.TargetPost, post.ID, snapshotPost(post), nil)
+	})
 	if err != nil {
 		return err
 	}
+	service.events.publishRemoved(post.ID, previous)
 	return nil
 }
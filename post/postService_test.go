@@ -0,0 +1,158 @@
+package post
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"post-service/audit"
+	"post-service/category"
+	"post-service/database"
+	"post-service/moderation"
+	"post-service/money"
+	"post-service/ratelimit"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// newBenchmarkDB returns an in-memory database seeded with a page of posts
+// spread over several categories, and a counter of the queries it executes.
+func newBenchmarkDB(b *testing.B) (*gorm.DB, *int64) {
+	b.Helper()
+	db := newTestDB(b)
+
+	for i := 1; i <= 5; i++ {
+		cat := category.Category{Name: fmt.Sprintf("Category %d", i), Slug: fmt.Sprintf("category-%d", i)}
+		if err := db.Create(&cat).Error; err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		post := Post{
+			Title:       fmt.Sprintf("post %d", i),
+			Description: "description",
+			PriceMinor:  1000,
+			Currency:    "USD",
+			Address:     "address",
+			CategoryID:  uint(i%5 + 1),
+			IsActive:    true,
+			OwnerId:     1,
+		}
+		if err := db.Omit("Category").Create(&post).Error; err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var queries int64
+	err := db.Callback().Query().After("gorm:query").Register("count_queries", func(*gorm.DB) {
+		atomic.AddInt64(&queries, 1)
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return db, &queries
+}
+
+func BenchmarkGetAllPosts(b *testing.B) {
+	ctx := context.Background()
+	db, queries := newBenchmarkDB(b)
+	timeouts := &database.QueryTimeouts{Default: time.Second}
+
+	// The strategy GetAllPosts used before categories were joined in: one
+	// query for the page and one category lookup per post.
+	b.Run("per-post lookups", func(b *testing.B) {
+		atomic.StoreInt64(queries, 0)
+		for i := 0; i < b.N; i++ {
+			var posts []Post
+			if err := db.WithContext(ctx).Offset(0).Limit(10).Find(&posts).Error; err != nil {
+				b.Fatal(err)
+			}
+			for _, post := range posts {
+				var cat category.Category
+				if err := db.WithContext(ctx).First(&cat, post.CategoryID).Error; err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.ReportMetric(float64(atomic.LoadInt64(queries))/float64(b.N), "queries/op")
+	})
+
+	b.Run("joined", func(b *testing.B) {
+		service := NewPostService(
+			category.NewCategoryRepository(db, timeouts, category.NewCategoryCache()),
+			NewPostRepository(db, timeouts),
+			&ScheduleConfig{ListingDuration: defaultListingDuration},
+			&money.Exchange{DefaultCurrency: "USD"},
+			NewPostBroker(),
+			ratelimit.NewDailyCap(ratelimit.NewMemoryStore(), "posts", 0),
+			moderation.NewPolicy(),
+			audit.NewAuditService(audit.NewEntryRepository(db, timeouts)),
+		)
+		atomic.StoreInt64(queries, 0)
+		for i := 0; i < b.N; i++ {
+			posts, err := service.GetAllPosts(ctx, "", "", PriceQuery{}, 1)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(*posts) != 10 {
+				b.Fatalf("got %d posts, want 10", len(*posts))
+			}
+			if (*posts)[0].Category == "" {
+				b.Fatal("category name was not loaded")
+			}
+		}
+		b.ReportMetric(float64(atomic.LoadInt64(queries))/float64(b.N), "queries/op")
+	})
+}
+
+// failingPostStore fails to add posts while err is set.
+type failingPostStore struct {
+	*MemoryPostStore
+	err error
+}
+
+func (store *failingPostStore) AddPost(ctx context.Context, post *Post, fingerprint *Fingerprint, flags []moderation.Flag, hook database.Hook) error {
+	if store.err != nil {
+		return store.err
+	}
+	return store.MemoryPostStore.AddPost(ctx, post, fingerprint, flags, hook)
+}
+
+func TestCreatePostRefundsCap(t *testing.T) {
+	ctx := context.Background()
+	categories := category.NewMemoryCategoryStore()
+	if err := categories.AddCategory(ctx, &category.Category{Name: "Tools", Slug: "tools", IsActive: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	posts := &failingPostStore{MemoryPostStore: NewMemoryPostStore(categories), err: errors.New("insert failed")}
+	service := NewPostService(categories, posts,
+		&ScheduleConfig{ListingDuration: defaultListingDuration},
+		&money.Exchange{DefaultCurrency: "USD"},
+		NewPostBroker(),
+		ratelimit.NewDailyCap(ratelimit.NewMemoryStore(), "posts", 1),
+		moderation.NewPolicy(),
+		newTestAuditService(t))
+	dto := PostDto{
+		Title:       "Cordless drill",
+		Description: "Two batteries",
+		PricePerDay: money.NewDecimal(1250, 2),
+		Address:     "1 Main Street",
+		Category:    "tools",
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := service.CreatePost(ctx, 1, dto); !errors.Is(err, posts.err) {
+			t.Fatalf("attempt %d: got %v", i, err)
+		}
+	}
+	posts.err = nil
+	if _, _, err := service.CreatePost(ctx, 1, dto); err != nil {
+		t.Fatalf("failed inserts used up the cap: %v", err)
+	}
+	dto.Title, dto.Description = "Aluminium ladder", "Folds to two meters"
+	if _, _, err := service.CreatePost(ctx, 1, dto); !errors.Is(err, ErrDailyPostCap) {
+		t.Errorf("got %v, want the cap reached", err)
+	}
+}
@@ -0,0 +1,508 @@
+package post
+
+import (
+	"context"
+	"errors"
+	"post-service/audit"
+	"post-service/category"
+	"post-service/database"
+	"post-service/idempotency"
+	"post-service/moderation"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// postStores are the PostStore implementations the conformance tests run
+// against, each with the CategoryStore sharing its posts.
+var postStores = []struct {
+	name string
+	new  func(t *testing.T) (PostStore, category.CategoryStore)
+}{
+	{"gorm", newGormStores},
+	{"memory", newMemoryStores},
+}
+
+// newTestDB returns an empty in-memory database with the tables of posts,
+// categories, imports, idempotency keys and the audit log.
+func newTestDB(tb testing.TB) *gorm.DB {
+	tb.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	tb.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(&category.Category{}, &category.CategoryAlias{}, &category.CategoryTranslation{},
+		&Post{}, &PricingRule{}, &PostFingerprint{}, &PostFingerprintBand{}, &moderation.Flag{}, &ImportJob{}, &idempotency.Key{}, &audit.Entry{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+func newGormStores(t *testing.T) (PostStore, category.CategoryStore) {
+	db := newTestDB(t)
+	timeouts := &database.QueryTimeouts{Default: time.Second}
+	return NewPostRepository(db, timeouts), category.NewCategoryRepository(db, timeouts, category.NewCategoryCache())
+}
+
+func newMemoryStores(t *testing.T) (PostStore, category.CategoryStore) {
+	categories := category.NewMemoryCategoryStore()
+	return NewMemoryPostStore(categories), categories
+}
+
+// storeFixture holds the posts seedPosts adds, by their role in the tests.
+type storeFixture struct {
+	tools, garden *category.Category
+	// drill, trimmer and press are listed; drill costs half as much again
+	// on every day through a weekday rule.
+	drill, trimmer, press *Post
+	// mower is paused, auger scheduled and ladder expired.
+	mower, auger, ladder *Post
+}
+
+func seedPosts(t *testing.T, posts PostStore, categories category.CategoryStore) *storeFixture {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	past, future := now.Add(-24*time.Hour), now.Add(24*time.Hour)
+
+	f := &storeFixture{
+		tools:  &category.Category{Name: "Tools", Slug: "tools", IsActive: true},
+		garden: &category.Category{Name: "Garden", Slug: "garden", IsActive: true},
+	}
+	for _, cat := range []*category.Category{f.tools, f.garden} {
+		if err := categories.AddCategory(ctx, cat, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newPost := func(title, description string, ownerId uint, cat *category.Category, price int64, currency string, active bool, publishAt, expiresAt time.Time) *Post {
+		post := &Post{
+			Title:       title,
+			Description: description,
+			PriceMinor:  price,
+			Currency:    currency,
+			CategoryID:  cat.ID,
+			IsActive:    active,
+			OwnerId:     ownerId,
+			Status:      scheduleStatus(&publishAt, &expiresAt, now),
+			PublishAt:   &publishAt,
+			ExpiresAt:   &expiresAt,
+		}
+		fingerprint := fingerprintOf(post.Title, post.Description, post.Address)
+		if err := posts.AddPost(ctx, post, &fingerprint, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		return post
+	}
+	f.drill = newPost("Cordless drill", "Eighteen volt with two batteries and a charger", 1, f.tools, 1000, "USD", true, past, future)
+	f.trimmer = newPost("Hedge trimmer", "Petrol powered, long reach for tall hedges", 1, f.garden, 2500, "USD", true, past, future)
+	f.press = newPost("Drill press", "Bench mounted, fits bits up to sixteen millimetres", 2, f.tools, 4000, "EUR", true, past, future)
+	f.mower = newPost("Lawn mower", "Self propelled with a grass box", 2, f.garden, 3000, "USD", false, past, future)
+	f.auger = newPost("Garden drill auger", "Digs holes for fence posts and bulbs", 1, f.garden, 1500, "USD", true, future, future.Add(time.Hour))
+	f.ladder = newPost("Ladder", "Aluminium extension ladder reaching six metres", 2, f.tools, 2000, "USD", true, past.Add(-time.Hour), past)
+
+	err := posts.ReplacePricingRules(ctx, f.drill.ID, []PricingRule{{
+		Name:           "Every day",
+		Kind:           RuleWeekday,
+		Weekdays:       0b1111111,
+		AdjustmentType: AdjustPercent,
+		Adjustment:     5000,
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func postIds(posts []Post) []uint {
+	ids := []uint{}
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	return ids
+}
+
+func idsOf(posts ...*Post) []uint {
+	ids := []uint{}
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	return ids
+}
+
+func equalIds(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPostStore(t *testing.T) {
+	for _, impl := range postStores {
+		t.Run(impl.name, func(t *testing.T) {
+			testPostStore(t, impl.new)
+		})
+	}
+}
+
+func testPostStore(t *testing.T, newStores func(t *testing.T) (PostStore, category.CategoryStore)) {
+	ctx := context.Background()
+
+	t.Run("get", func(t *testing.T) {
+		posts, categories := newStores(t)
+		f := seedPosts(t, posts, categories)
+
+		post, err := posts.GetPostByID(ctx, f.drill.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if post.Title != "Cordless drill" || post.Category.Name != "Tools" || len(post.PricingRules) != 1 {
+			t.Errorf("got %+v", post)
+		}
+		if post.PricingRules[0].ID == 0 || post.PricingRules[0].PostID != post.ID {
+			t.Errorf("got rule %+v", post.PricingRules[0])
+		}
+
+		_, err = posts.GetPostByID(ctx, f.ladder.ID+100)
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("got error %v, want gorm.ErrRecordNotFound", err)
+		}
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		posts, categories := newStores(t)
+		f := seedPosts(t, posts, categories)
+		now := time.Now()
+		zero := uint(0)
+		owner := uint(2)
+
+		tests := []struct {
+			name   string
+			filter PostFilter
+			want   []uint
+		}{
+			{"none", PostFilter{}, idsOf(f.drill, f.trimmer, f.press, f.mower, f.auger, f.ladder)},
+			{"category", PostFilter{CategoryId: &f.tools.ID}, idsOf(f.drill, f.press, f.ladder)},
+			{"zero category", PostFilter{CategoryId: &zero}, idsOf(f.drill, f.trimmer, f.press, f.mower, f.auger, f.ladder)},
+			{"owner", PostFilter{OwnerId: &owner}, idsOf(f.press, f.mower, f.ladder)},
+			{"title ignores case", PostFilter{Title: "DRILL"}, idsOf(f.drill, f.press, f.auger)},
+			{"title wildcards", PostFilter{Title: "l_dder"}, idsOf(f.ladder)},
+			{"min price", PostFilter{MinPrice: map[string]int64{"USD": 2000}}, idsOf(f.trimmer, f.mower, f.ladder)},
+			{"max price", PostFilter{MaxPrice: map[string]int64{"USD": 1500, "EUR": 5000}}, idsOf(f.drill, f.press, f.auger)},
+			{"base price", PostFilter{MinPrice: map[string]int64{"USD": 1200}}, idsOf(f.trimmer, f.mower, f.auger, f.ladder)},
+			{"price on day", PostFilter{MinPrice: map[string]int64{"USD": 1200}, PriceOn: &now}, idsOf(f.drill, f.trimmer, f.mower, f.auger, f.ladder)},
+			{"visible", PostFilter{VisibleAt: &now}, idsOf(f.drill, f.trimmer, f.press)},
+			{"combined", PostFilter{CategoryId: &f.tools.ID, Title: "drill", VisibleAt: &now}, idsOf(f.drill, f.press)},
+		}
+		for _, test := range tests {
+			found, err := posts.GetAllPosts(ctx, test.filter, 0, 10)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if got := postIds(found); !equalIds(got, test.want) {
+				t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+			}
+		}
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		posts, categories := newStores(t)
+		f := seedPosts(t, posts, categories)
+
+		tests := []struct {
+			offset, limit int
+			want          []uint
+		}{
+			{0, 2, idsOf(f.drill, f.trimmer)},
+			{2, 2, idsOf(f.press, f.mower)},
+			{5, 10, idsOf(f.ladder)},
+			{10, 10, idsOf()},
+			{0, 0, idsOf()},
+		}
+		for _, test := range tests {
+			found, err := posts.GetAllPosts(ctx, PostFilter{}, test.offset, test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := postIds(found); !equalIds(got, test.want) {
+				t.Errorf("offset %d, limit %d: got %v, want %v", test.offset, test.limit, got, test.want)
+			}
+		}
+		if found, _ := posts.GetAllPosts(ctx, PostFilter{}, 0, 1); len(found) != 1 || found[0].Category.Name != "Tools" || len(found[0].PricingRules) != 1 {
+			t.Errorf("associations were not loaded: %+v", found)
+		}
+
+		found, err := posts.GetPostsByOwnerId(ctx, 1, 2, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := postIds(found), idsOf(f.auger); !equalIds(got, want) {
+			t.Errorf("owner's posts: got %v, want %v", got, want)
+		}
+
+		found, err = posts.GetPostsByIds(ctx, idsOf(f.ladder, f.drill))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := postIds(found), idsOf(f.drill, f.ladder); !equalIds(got, want) {
+			t.Errorf("by ids: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("add with flags", func(t *testing.T) {
+		posts, categories := newStores(t)
+		f := seedPosts(t, posts, categories)
+
+		post := &Post{Title: "Wheelbarrow", PriceMinor: 500, Currency: "USD", CategoryID: f.garden.ID, IsActive: true, OwnerId: 3}
+		flags := []moderation.Flag{{Reason: moderation.ContentReason(moderation.RuleEmail), Status: moderation.StatusOpen}}
+		if err := posts.AddPost(ctx, post, nil, flags, nil); err != nil {
+			t.Fatal(err)
+		}
+		if post.ID <= f.ladder.ID || post.CreatedAt.IsZero() {
+			t.Errorf("got %+v", post)
+		}
+		if flags[0].PostID != post.ID {
+			t.Errorf("flag got post %d, want %d", flags[0].PostID, post.ID)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		posts, categories := newStores(t)
+		f := seedPosts(t, posts, categories)
+
+		post, err := posts.GetPostByID(ctx, f.drill.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		post.Title = "Hammer drill"
+		post.CategoryID = f.garden.ID
+		post.PricingRules = nil
+		if err := posts.UpdatePost(ctx, post, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		updated, err := posts.GetPostByID(ctx, f.drill.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Title != "Hammer drill" || updated.Category.Name != "Garden" {
+			t.Errorf("got %+v", updated)
+		}
+		if len(updated.PricingRules) != 1 {
+			t.Errorf("pricing rules were changed: %+v", updated.PricingRules)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		posts, categories := newStores(t)
+		f := seedPosts(t, posts, categories)
+
+		if err := posts.DeletePost(ctx, f.drill.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+		_, err := posts.GetPostByID(ctx, f.drill.ID)
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("got error %v, want gorm.ErrRecordNotFound", err)
+		}
+		if err := posts.DeletePost(ctx, f.drill.ID, nil); err != nil {
+			t.Errorf("deleting again: %v", err)
+		}
+	})
+
+	t.Run("pricing rules", func(t *testing.T) {
+		posts, categories := newStores(t)
+		f := seedPosts(t, posts, categories)
+
+		rules := []PricingRule{
+			{Name: "Weekend", Kind: RuleWeekday, Weekdays: 0b1000001, AdjustmentType: AdjustAbsolute, Adjustment: 500},
+			{Name: "Week", Kind: RuleDuration, MinDays: 7, AdjustmentType: AdjustPercent, Adjustment: -1000},
+		}
+		if err := posts.ReplacePricingRules(ctx, f.trimmer.ID, rules, nil); err != nil {
+			t.Fatal(err)
+		}
+		post, err := posts.GetPostByID(ctx, f.trimmer.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(post.PricingRules) != 2 || post.PricingRules[0].Name != "Weekend" || post.PricingRules[1].MinDays != 7 {
+			t.Errorf("got %+v", post.PricingRules)
+		}
+
+		if err := posts.ReplacePricingRules(ctx, f.trimmer.ID, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if post, err := posts.GetPostByID(ctx, f.trimmer.ID); err != nil || len(post.PricingRules) != 0 {
+			t.Errorf("got %+v, %v", post, err)
+		}
+	})
+
+	t.Run("bulk update", func(t *testing.T) {
+		posts, categories := newStores(t)
+		f := seedPosts(t, posts, categories)
+
+		var locked []uint
+		err := posts.BulkUpdate(ctx, idsOf(f.trimmer, f.drill, &Post{ID: 999}), PostFilter{}, 10, func(found []Post) (*PostChanges, error) {
+			locked = postIds(found)
+			if len(found[0].PricingRules) != 1 {
+				t.Errorf("pricing rules were not loaded: %+v", found[0])
+			}
+			found[0].IsActive = false
+			return &PostChanges{Save: []*Post{&found[0]}, Delete: []uint{found[1].ID}}, nil
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := idsOf(f.drill, f.trimmer); !equalIds(locked, want) {
+			t.Errorf("got posts %v, want %v", locked, want)
+		}
+		if post, err := posts.GetPostByID(ctx, f.drill.ID); err != nil || post.IsActive {
+			t.Errorf("got %+v, %v", post, err)
+		}
+		if _, err := posts.GetPostByID(ctx, f.trimmer.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("got error %v, want gorm.ErrRecordNotFound", err)
+		}
+
+		owner := uint(2)
+		err = posts.BulkUpdate(ctx, nil, PostFilter{OwnerId: &owner}, 2, func(found []Post) (*PostChanges, error) {
+			locked = postIds(found)
+			return &PostChanges{}, nil
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := idsOf(f.press, f.mower); !equalIds(locked, want) {
+			t.Errorf("got posts %v, want %v", locked, want)
+		}
+
+		failure := errors.New("failed")
+		err = posts.BulkUpdate(ctx, idsOf(f.press), PostFilter{}, 10, func(found []Post) (*PostChanges, error) {
+			found[0].Title = "Changed"
+			return &PostChanges{Save: []*Post{&found[0]}}, failure
+		}, nil)
+		if !errors.Is(err, failure) {
+			t.Errorf("got error %v, want %v", err, failure)
+		}
+		if post, err := posts.GetPostByID(ctx, f.press.ID); err != nil || post.Title != "Drill press" {
+			t.Errorf("failed run changed %+v, %v", post, err)
+		}
+	})
+
+	t.Run("failing hook", func(t *testing.T) {
+		posts, categories := newStores(t)
+		f := seedPosts(t, posts, categories)
+		errHook := errors.New("hook failed")
+		fail := func(context.Context) error { return errHook }
+
+		added := *f.press
+		added.ID, added.Title = 0, "Added"
+		if err := posts.AddPost(ctx, &added, nil, nil, fail); !errors.Is(err, errHook) {
+			t.Fatalf("add: got error %v, want %v", err, errHook)
+		}
+		if added.ID != 0 {
+			if _, err := posts.GetPostByID(ctx, added.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Errorf("added post was kept: %v", err)
+			}
+		}
+
+		post, err := posts.GetPostByID(ctx, f.drill.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		post.Title = "Hammer drill"
+		if err := posts.UpdatePost(ctx, post, nil, nil, fail); !errors.Is(err, errHook) {
+			t.Errorf("update: got error %v, want %v", err, errHook)
+		}
+		if err := posts.ReplacePricingRules(ctx, f.drill.ID, nil, fail); !errors.Is(err, errHook) {
+			t.Errorf("pricing rules: got error %v, want %v", err, errHook)
+		}
+		err = posts.BulkUpdate(ctx, idsOf(f.drill), PostFilter{}, 10, func(found []Post) (*PostChanges, error) {
+			found[0].IsActive = false
+			return &PostChanges{Save: []*Post{&found[0]}}, nil
+		}, fail)
+		if !errors.Is(err, errHook) {
+			t.Errorf("bulk update: got error %v, want %v", err, errHook)
+		}
+		if err := posts.DeletePost(ctx, f.drill.ID, fail); !errors.Is(err, errHook) {
+			t.Errorf("delete: got error %v, want %v", err, errHook)
+		}
+
+		kept, err := posts.GetPostByID(ctx, f.drill.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kept.Title != f.drill.Title || !kept.IsActive || len(kept.PricingRules) != 1 {
+			t.Errorf("failed hooks changed %+v", kept)
+		}
+	})
+
+	t.Run("similar posts", func(t *testing.T) {
+		posts, categories := newStores(t)
+		f := seedPosts(t, posts, categories)
+
+		copied := *f.drill
+		copied.ID, copied.OwnerId = 0, 3
+		fingerprint := fingerprintOf(copied.Title, copied.Description, copied.Address)
+		if err := posts.AddPost(ctx, &copied, &fingerprint, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		similar, err := posts.FindSimilarPosts(ctx, fingerprint, copied.ID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(similar) != 1 || similar[0].PostID != f.drill.ID || similar[0].OwnerId != 1 {
+			t.Fatalf("got %+v", similar)
+		}
+		if stored, ok := parseFingerprint(similar[0].Signature); !ok || stored.Similarity(fingerprint) != 1 {
+			t.Errorf("signature was not returned")
+		}
+
+		pairs, fingerprinted, err := posts.CandidatePairs(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pairs) != 1 || pairs[0] != [2]uint{f.drill.ID, copied.ID} || len(fingerprinted) != 2 {
+			t.Errorf("got pairs %v with %d fingerprints", pairs, len(fingerprinted))
+		}
+	})
+
+	t.Run("category changes", func(t *testing.T) {
+		posts, categories := newStores(t)
+		f := seedPosts(t, posts, categories)
+
+		counts, err := categories.CountPosts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts[f.tools.ID] != 3 || counts[f.garden.ID] != 2 {
+			t.Errorf("got counts %v", counts)
+		}
+
+		moved, err := categories.MergeCategories(ctx, f.tools.ID, f.garden.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved != 3 {
+			t.Errorf("moved %d posts, want 3", moved)
+		}
+		if post, err := posts.GetPostByID(ctx, f.press.ID); err != nil || post.CategoryID != f.garden.ID || post.Category.Name != "Garden" {
+			t.Errorf("got %+v, %v", post, err)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package post
+
+import (
+	"post-service/apperr"
+	"post-service/money"
+	"strings"
+)
+
+// PriceQuery holds the raw price filter of a listing: minPrice and maxPrice,
+// or the legacy price=min-max form, in Currency.
+type PriceQuery struct {
+	MinPrice string
+	MaxPrice string
+	// Price is the legacy form: "min-max", "min-" or "-max".
+	Price    string
+	Currency string
+}
+
+// priceRange is a parsed PriceQuery. Nil bounds are open.
+type priceRange struct {
+	min, max *money.Decimal
+	currency string
+}
+
+// parse checks every parameter and reports all problems at once.
+func (query PriceQuery) parse(defaultCurrency string) (*priceRange, error) {
+	var fields []apperr.FieldError
+	invalid := func(field, rule, message string) {
+		fields = append(fields, apperr.FieldError{Field: field, Rule: rule, Message: message})
+	}
+	bound := func(field, value string) *money.Decimal {
+		if value == "" {
+			return nil
+		}
+		amount, err := money.ParseDecimal(value)
+		if err != nil {
+			invalid(field, "decimal", field+" must be a decimal number such as 120 or 99.50")
+			return nil
+		}
+		if amount.Sign() < 0 {
+			invalid(field, "gte", field+" must not be negative")
+			return nil
+		}
+		return &amount
+	}
+
+	result := &priceRange{currency: defaultCurrency}
+	if query.Currency != "" {
+		currency, err := money.NormalizeCurrency(query.Currency)
+		if err != nil {
+			invalid("currency", "iso4217", "currency is not supported")
+		}
+		result.currency = currency
+	}
+
+	if query.Price != "" {
+		if query.MinPrice != "" || query.MaxPrice != "" {
+			invalid("price", "excluded_with", "price cannot be combined with minPrice or maxPrice")
+		} else if min, max, ok := strings.Cut(query.Price, "-"); !ok || min == "" && max == "" {
+			invalid("price", "range", "price must be in the form min-max, min- or -max")
+		} else {
+			result.min, result.max = bound("price", min), bound("price", max)
+		}
+	} else {
+		result.min, result.max = bound("minPrice", query.MinPrice), bound("maxPrice", query.MaxPrice)
+	}
+
+	if result.min != nil && result.max != nil && result.min.Rat().Cmp(result.max.Rat()) > 0 {
+		if query.Price != "" {
+			invalid("price", "range", "the maximum price must not be less than the minimum")
+		} else {
+			invalid("maxPrice", "gtefield", "maxPrice must not be less than minPrice")
+		}
+	}
+
+	if len(fields) > 0 {
+		return nil, apperr.Validation("invalid_price_filter", "price filter is invalid", fields...)
+	}
+	return result, nil
+}
@@ -0,0 +1,266 @@
+package post
+
+import (
+	"fmt"
+	"math/big"
+	"post-service/apperr"
+	"post-service/money"
+	"sort"
+	"time"
+)
+
+const (
+	RuleDateRange = "date_range"
+	RuleWeekday   = "weekday"
+	RuleDuration  = "duration"
+
+	AdjustPercent  = "percent"
+	AdjustAbsolute = "absolute"
+
+	MaxPricingRules = 50
+	// PriceCalendarDays is how far ahead the price calendar looks.
+	PriceCalendarDays = 90
+
+	dateLayout = "2006-01-02"
+
+	// Percentage adjustments are stored in basis points, 1/100 of a percent.
+	basisPoints  = 10000
+	minPercentBp = -basisPoints
+	maxPercentBp = 10 * basisPoints
+)
+
+// PricingRule adjusts a post's price on some days or for long rentals.
+//
+// Precedence: a day gets at most one calendar rule, a date_range rule covering
+// it if there is one and otherwise a weekday rule. The duration rule with the
+// largest MinDays the rental reaches is then applied on top. Rules are
+// validated so that no two rules of the same kind can match the same day or
+// rental, which makes that choice unambiguous.
+type PricingRule struct {
+	ID        uint
+	PostID    uint
+	Name      string
+	Kind      string
+	StartDate *time.Time `gorm:"type:date"`
+	EndDate   *time.Time `gorm:"type:date"`
+	// Weekdays is a bit set with bit n standing for time.Weekday(n).
+	Weekdays       int
+	MinDays        int
+	AdjustmentType string
+	// Adjustment is in basis points for percent rules and in minor units of
+	// the post's currency for absolute ones.
+	Adjustment int64
+	CreatedAt  time.Time
+}
+
+// adjust applies the rule to a price in minor units. Percentages round half
+// away from zero, as ROUND does in effectivePriceSQL.
+func (rule PricingRule) adjust(price int64) int64 {
+	if rule.AdjustmentType == AdjustPercent {
+		return roundDiv(price*(basisPoints+rule.Adjustment), basisPoints)
+	}
+	return price + rule.Adjustment
+}
+
+// adjustFits reports whether adjusting price stays within int64, including
+// the product a percentage takes in effectivePriceSQL before dividing.
+func (rule PricingRule) adjustFits(price int64) bool {
+	var result big.Int
+	if rule.AdjustmentType == AdjustPercent {
+		result.Mul(big.NewInt(price), big.NewInt(basisPoints+rule.Adjustment))
+	} else {
+		result.Add(big.NewInt(price), big.NewInt(rule.Adjustment))
+	}
+	return result.IsInt64()
+}
+
+func roundDiv(numerator, denominator int64) int64 {
+	if numerator < 0 {
+		return -roundDiv(-numerator, denominator)
+	}
+	return (numerator + denominator/2) / denominator
+}
+
+func (rule PricingRule) matchesDay(day time.Time) bool {
+	switch rule.Kind {
+	case RuleDateRange:
+		return !day.Before(*rule.StartDate) && !day.After(*rule.EndDate)
+	case RuleWeekday:
+		return rule.Weekdays&(1<<uint(day.Weekday())) != 0
+	}
+	return false
+}
+
+// calendarRule returns the rule that sets the day rate on day, if any.
+func calendarRule(rules []PricingRule, day time.Time) *PricingRule {
+	var weekday *PricingRule
+	for i := range rules {
+		if !rules[i].matchesDay(day) {
+			continue
+		}
+		if rules[i].Kind == RuleDateRange {
+			return &rules[i]
+		}
+		weekday = &rules[i]
+	}
+	return weekday
+}
+
+// durationRule returns the tier a rental of rentalDays days falls into, if any.
+func durationRule(rules []PricingRule, rentalDays int) *PricingRule {
+	var tier *PricingRule
+	for i := range rules {
+		if rules[i].Kind == RuleDuration && rules[i].MinDays <= rentalDays && (tier == nil || rules[i].MinDays > tier.MinDays) {
+			tier = &rules[i]
+		}
+	}
+	return tier
+}
+
+// priceOn returns the day rate, in minor units, on day for a rental of
+// rentalDays days and the names of the rules that produced it.
+func priceOn(base int64, rules []PricingRule, day time.Time, rentalDays int) (int64, []string) {
+	price := base
+	applied := []string{}
+	for _, rule := range []*PricingRule{calendarRule(rules, day), durationRule(rules, rentalDays)} {
+		if rule != nil {
+			price = rule.adjust(price)
+			applied = append(applied, rule.Name)
+		}
+	}
+	return price, applied
+}
+
+// dayOf returns the UTC calendar day t falls on.
+func dayOf(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// toPricingRules converts and checks the rules an owner submitted for a post
+// priced at base. Absolute adjustments are in base's currency.
+func toPricingRules(base money.Money, dtos []PricingRuleDto) ([]PricingRule, error) {
+	var rules []PricingRule
+	var fields []apperr.FieldError
+	invalid := func(i int, field, rule, message string) {
+		fields = append(fields, apperr.FieldError{Field: fmt.Sprintf("rules[%d].%s", i, field), Rule: rule, Message: message})
+	}
+
+	for i, dto := range dtos {
+		rule := PricingRule{
+			Name:           dto.Name,
+			Kind:           dto.Kind,
+			MinDays:        dto.MinDays,
+			AdjustmentType: dto.AdjustmentType,
+		}
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("%s rule %d", rule.Kind, i+1)
+		}
+		if rule.AdjustmentType == AdjustPercent {
+			bp, err := dto.Adjustment.Scaled(2)
+			switch {
+			case err != nil:
+				invalid(i, "adjustment", "decimals", "a percentage adjustment may have at most 2 decimal places")
+			case bp <= minPercentBp || bp > maxPercentBp:
+				invalid(i, "adjustment", "range", "a percentage adjustment must be greater than -100 and at most 1000")
+			}
+			rule.Adjustment = bp
+		} else {
+			minor, err := dto.Adjustment.Scaled(money.Exponent(base.Currency))
+			if err != nil {
+				invalid(i, "adjustment", "decimals", "adjustment has more decimal places than "+base.Currency+" allows")
+			}
+			rule.Adjustment = minor
+		}
+
+		switch rule.Kind {
+		case RuleDateRange:
+			start, startErr := time.Parse(dateLayout, dto.StartDate)
+			end, endErr := time.Parse(dateLayout, dto.EndDate)
+			if startErr != nil || endErr != nil {
+				invalid(i, "startDate", "datetime", "dates must be in the form YYYY-MM-DD")
+				continue
+			}
+			if end.Before(start) {
+				invalid(i, "endDate", "gtefield", "endDate must not be before startDate")
+				continue
+			}
+			rule.StartDate, rule.EndDate = &start, &end
+			for j := range rules {
+				if rules[j].Kind == RuleDateRange && !start.After(*rules[j].EndDate) && !end.Before(*rules[j].StartDate) {
+					invalid(i, "startDate", "overlap", fmt.Sprintf("date range overlaps %q", rules[j].Name))
+				}
+			}
+		case RuleWeekday:
+			for _, weekday := range dto.Weekdays {
+				bit := 1 << uint(weekday)
+				for j := range rules {
+					if rules[j].Kind == RuleWeekday && rules[j].Weekdays&bit != 0 {
+						invalid(i, "weekdays", "overlap", fmt.Sprintf("%s is already priced by %q", time.Weekday(weekday), rules[j].Name))
+					}
+				}
+				rule.Weekdays |= bit
+			}
+		case RuleDuration:
+			for j := range rules {
+				if rules[j].Kind == RuleDuration && rules[j].MinDays == rule.MinDays {
+					invalid(i, "minDays", "unique", fmt.Sprintf("%q already starts at %d days", rules[j].Name, rule.MinDays))
+				}
+			}
+		}
+		rules = append(rules, rule)
+	}
+
+	if len(fields) == 0 {
+		if err := checkRulePrices(base.Minor, rules); err != nil {
+			return nil, err
+		}
+		return rules, nil
+	}
+	sort.SliceStable(fields, func(a, b int) bool { return fields[a].Field < fields[b].Field })
+	return nil, apperr.Validation("invalid_pricing_rules", "pricing rules are invalid", fields...)
+}
+
+// checkRulePrices makes sure that every combination of a calendar rule and a
+// duration rule leaves a price above zero for a post priced at base, and that
+// computing it, here or in effectivePriceSQL, does not overflow.
+func checkRulePrices(base int64, rules []PricingRule) error {
+	calendar := []*PricingRule{nil}
+	duration := []*PricingRule{nil}
+	for i := range rules {
+		if rules[i].Kind == RuleDuration {
+			duration = append(duration, &rules[i])
+		} else {
+			calendar = append(calendar, &rules[i])
+		}
+	}
+
+	for _, first := range calendar {
+		for _, second := range duration {
+			price := base
+			for _, rule := range []*PricingRule{first, second} {
+				if rule == nil {
+					continue
+				}
+				if !rule.adjustFits(price) {
+					return ErrRulePriceTooLarge
+				}
+				price = rule.adjust(price)
+			}
+			if price <= 0 {
+				return ErrRulePriceNotPositive
+			}
+		}
+	}
+	return nil
+}
+
+// hasAbsoluteRules reports whether any rule is tied to the post's currency.
+func hasAbsoluteRules(rules []PricingRule) bool {
+	for _, rule := range rules {
+		if rule.AdjustmentType == AdjustAbsolute {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package post
+
+import (
+	"context"
+	"errors"
+	"post-service/apperr"
+	"post-service/audit"
+	"post-service/money"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrRulePriceNotPositive = apperr.Validation("rule_price_not_positive", "pricing rules would bring the price to zero or below",
+	apperr.FieldError{Field: "rules", Rule: "gt", Message: "pricing rules would bring the price to zero or below"})
+var ErrRulePriceTooLarge = apperr.Validation("rule_price_too_large", "pricing rules would bring the price out of range",
+	apperr.FieldError{Field: "rules", Rule: "lte", Message: "pricing rules would bring the price out of range"})
+var ErrInvalidRentalDays = apperr.Validation("invalid_rental_days", "rentalDays must be a whole number between 1 and 365",
+	apperr.FieldError{Field: "rentalDays", Rule: "range", Message: "rentalDays must be a whole number between 1 and 365"})
+
+type PricingRuleResponse struct {
+	ID             uint          `json:"id"`
+	Name           string        `json:"name"`
+	Kind           string        `json:"kind"`
+	StartDate      string        `json:"startDate,omitempty"`
+	EndDate        string        `json:"endDate,omitempty"`
+	Weekdays       []int         `json:"weekdays,omitempty"`
+	MinDays        int           `json:"minDays,omitempty"`
+	AdjustmentType string        `json:"adjustmentType"`
+	Adjustment     money.Decimal `json:"adjustment"`
+}
+
+type CalendarDay struct {
+	Date  string        `json:"date"`
+	Price money.Decimal `json:"price"`
+	Rules []string      `json:"rules"`
+}
+
+type PriceCalendar struct {
+	PostID      uint          `json:"postId"`
+	PricePerDay money.Decimal `json:"pricePerDay"`
+	Currency    string        `json:"currency"`
+	RentalDays  int           `json:"rentalDays"`
+	Days        []CalendarDay `json:"days"`
+}
+
+// toPricingRuleResponses shows absolute adjustments in currency, the post's.
+func toPricingRuleResponses(rules []PricingRule, currency string) []PricingRuleResponse {
+	responses := []PricingRuleResponse{}
+	for _, rule := range rules {
+		response := PricingRuleResponse{
+			ID:             rule.ID,
+			Name:           rule.Name,
+			Kind:           rule.Kind,
+			MinDays:        rule.MinDays,
+			AdjustmentType: rule.AdjustmentType,
+			Adjustment:     money.Money{Minor: rule.Adjustment, Currency: currency}.Amount(),
+		}
+		if rule.AdjustmentType == AdjustPercent {
+			response.Adjustment = money.NewDecimal(rule.Adjustment, 2)
+		}
+		if rule.StartDate != nil && rule.EndDate != nil {
+			response.StartDate = rule.StartDate.Format(dateLayout)
+			response.EndDate = rule.EndDate.Format(dateLayout)
+		}
+		for weekday := 0; weekday < 7; weekday++ {
+			if rule.Weekdays&(1<<uint(weekday)) != 0 {
+				response.Weekdays = append(response.Weekdays, weekday)
+			}
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+// visiblePost loads a post for a public endpoint, hiding it outside its
+// publishing window.
+func (service *PostService) visiblePost(ctx context.Context, postIdStr string) (*Post, error) {
+	postId, err := strconv.ParseUint(postIdStr, 10, 32)
+	if err != nil {
+		return nil, ErrInvalidPostId
+	}
+	post, err := service.repo.GetPostByID(ctx, uint(postId))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPostNotFound
+		}
+		return nil, err
+	}
+	if !post.VisibleAt(time.Now()) {
+		return nil, ErrPostNotFound
+	}
+	return post, nil
+}
+
+func (service *PostService) GetPricingRules(ctx context.Context, postIdStr string) ([]PricingRuleResponse, error) {
+	ctx, span := tracer.Start(ctx, "PostService.GetPricingRules")
+	defer span.End()
+
+	post, err := service.visiblePost(ctx, postIdStr)
+	if err != nil {
+		return nil, err
+	}
+	return toPricingRuleResponses(post.PricingRules, post.Currency), nil
+}
+
+// SetPricingRules replaces all of a post's pricing rules.
+func (service *PostService) SetPricingRules(ctx context.Context, userId uint, postIdStr string, dto PricingRulesDto) ([]PricingRuleResponse, error) {
+	ctx, span := tracer.Start(ctx, "PostService.SetPricingRules")
+	defer span.End()
+
+	postId, err := strconv.ParseUint(postIdStr, 10, 32)
+	if err != nil {
+		return nil, ErrInvalidPostId
+	}
+	post, err := service.repo.GetPostByID(ctx, uint(postId))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPostNotFound
+		}
+		return nil, err
+	}
+
+	if post.OwnerId != userId {
+		return nil, ErrForbidden
+	}
+
+	rules, err := toPricingRules(post.Price(), dto.Rules)
+	if err != nil {
+		return nil, err
+	}
+	before := toPricingRuleResponses(post.PricingRules, post.Currency)
+	err = service.repo.ReplacePricingRules(ctx, post.ID, rules, func(ctx context.Context) error {
+		return service.auditLog.Record(ctx, userId, AuditPostPricingRules, audit.TargetPost, post.ID, before, toPricingRuleResponses(rules, post.Currency))
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	post.PricingRules = rules
+	responses := toPricingRuleResponses(rules, post.Currency)
+	service.events.publishPost(PostEventCalendarChanged, listedKey(post, now), post, now)
+	return responses, nil
+}
+
+// GetPriceCalendar previews the day rate of a rental of rentalDays days for
+// each of the next PriceCalendarDays days.
+func (service *PostService) GetPriceCalendar(ctx context.Context, postIdStr, rentalDaysStr string) (*PriceCalendar, error) {
+	ctx, span := tracer.Start(ctx, "PostService.GetPriceCalendar")
+	defer span.End()
+
+	rentalDays := 1
+	if rentalDaysStr != "" {
+		days, err := strconv.Atoi(rentalDaysStr)
+		if err != nil || days < 1 || days > 365 {
+			return nil, ErrInvalidRentalDays
+		}
+		rentalDays = days
+	}
+
+	post, err := service.visiblePost(ctx, postIdStr)
+	if err != nil {
+		return nil, err
+	}
+
+	calendar := &PriceCalendar{PostID: post.ID, PricePerDay: post.Price().Amount(), Currency: post.Currency, RentalDays: rentalDays}
+	today := dayOf(time.Now())
+	for i := 0; i < PriceCalendarDays; i++ {
+		day := today.AddDate(0, 0, i)
+		price, rules := priceOn(post.PriceMinor, post.PricingRules, day, rentalDays)
+		amount := money.Money{Minor: price, Currency: post.Currency}.Amount()
+		calendar.Days = append(calendar.Days, CalendarDay{Date: day.Format(dateLayout), Price: amount, Rules: rules})
+	}
+	return calendar, nil
+}
@@ -0,0 +1,37 @@
+package post
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCheckRulePrices(t *testing.T) {
+	weekend := PricingRule{Kind: RuleWeekday, Weekdays: 0b1000001, AdjustmentType: AdjustPercent, Adjustment: 10000}
+	week := PricingRule{Kind: RuleDuration, MinDays: 7, AdjustmentType: AdjustPercent, Adjustment: -5000}
+	peak := PricingRule{Kind: RuleDuration, MinDays: 7, AdjustmentType: AdjustPercent, Adjustment: 10000}
+	discount := PricingRule{Kind: RuleDuration, MinDays: 7, AdjustmentType: AdjustAbsolute, Adjustment: -1500}
+	surcharge := PricingRule{Kind: RuleWeekday, Weekdays: 0b1000001, AdjustmentType: AdjustAbsolute, Adjustment: math.MaxInt64 - 1000}
+
+	tests := []struct {
+		name  string
+		base  int64
+		rules []PricingRule
+		want  error
+	}{
+		{"no rules", 1000, nil, nil},
+		{"combined", 1000, []PricingRule{weekend, week}, nil},
+		{"not positive", 1000, []PricingRule{discount}, ErrRulePriceNotPositive},
+		{"large price", 1e14, []PricingRule{weekend, week}, nil},
+		{"percent overflow", 1e15, []PricingRule{weekend}, ErrRulePriceTooLarge},
+		{"overflow after another rule", 4e14, []PricingRule{weekend, peak}, ErrRulePriceTooLarge},
+		{"absolute overflow", 2000, []PricingRule{surcharge}, ErrRulePriceTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkRulePrices(test.base, test.rules); !errors.Is(err, test.want) {
+				t.Errorf("got error %v, want %v", err, test.want)
+			}
+		})
+	}
+}
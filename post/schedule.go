@@ -0,0 +1,96 @@
+package post
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	PostScheduled = "scheduled"
+	PostLive      = "live"
+	PostExpired   = "expired"
+
+	defaultListingDuration   = 30 * 24 * time.Hour
+	defaultExpiryNotice      = 72 * time.Hour
+	defaultSchedulerInterval = time.Minute
+)
+
+// ScheduleConfig controls how long listings run and how the scheduler polls.
+type ScheduleConfig struct {
+	// ListingDuration is how long a post stays listed before it must be renewed.
+	ListingDuration time.Duration
+	// ExpiryNotice is how long before expiry owners are told to renew.
+	ExpiryNotice time.Duration
+	// Interval is how often the scheduler looks for due posts.
+	Interval time.Duration
+}
+
+// NewScheduleConfig reads POST_LISTING_DURATION, POST_EXPIRY_NOTICE and
+// POST_SCHEDULER_INTERVAL as Go durations, e.g. "720h", "72h" and "1m".
+func NewScheduleConfig() (*ScheduleConfig, error) {
+	config := &ScheduleConfig{
+		ListingDuration: defaultListingDuration,
+		ExpiryNotice:    defaultExpiryNotice,
+		Interval:        defaultSchedulerInterval,
+	}
+	for name, target := range map[string]*time.Duration{
+		"POST_LISTING_DURATION":   &config.ListingDuration,
+		"POST_EXPIRY_NOTICE":      &config.ExpiryNotice,
+		"POST_SCHEDULER_INTERVAL": &config.Interval,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid %s: must be positive", name)
+		}
+		*target = d
+	}
+	return config, nil
+}
+
+// window fills in the defaults for a publishing window and checks it: a post
+// goes live now unless told otherwise and runs for at most ListingDuration.
+func (config *ScheduleConfig) window(publishAt, expiresAt *time.Time, now time.Time) (time.Time, time.Time, error) {
+	start := now
+	if publishAt != nil {
+		start = *publishAt
+	}
+	end := start.Add(config.ListingDuration)
+	if expiresAt != nil {
+		end = *expiresAt
+	}
+
+	if !end.After(start) || !end.After(now) {
+		return time.Time{}, time.Time{}, ErrInvalidSchedule
+	}
+	if end.Sub(start) > config.ListingDuration {
+		return time.Time{}, time.Time{}, ErrScheduleTooLong
+	}
+	return start, end, nil
+}
+
+// scheduleStatus is the status a post with the given window has at now.
+func scheduleStatus(publishAt, expiresAt *time.Time, now time.Time) string {
+	if expiresAt != nil && !expiresAt.After(now) {
+		return PostExpired
+	}
+	if publishAt != nil && publishAt.After(now) {
+		return PostScheduled
+	}
+	return PostLive
+}
+
+// VisibleAt reports whether the post is publicly listed at t. It goes by the
+// post's window rather than its status, which the scheduler updates late.
+func (post *Post) VisibleAt(t time.Time) bool {
+	return post.IsActive &&
+		(post.PublishAt == nil || !post.PublishAt.After(t)) &&
+		(post.ExpiresAt == nil || post.ExpiresAt.After(t))
+}
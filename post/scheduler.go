@@ -0,0 +1,157 @@
+package post
+
+import (
+	"context"
+	"fmt"
+	"post-service/notification"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// expiringBatchSize is how many expiring posts are claimed per query.
+const expiringBatchSize = 100
+
+// fingerprintBackfillBatch is how many unfingerprinted posts each tick
+// fingerprints.
+const fingerprintBackfillBatch = 100
+
+// Scheduler moves posts between scheduled, live and expired as their
+// publishing windows open and close, and tells owners before a post expires.
+type Scheduler struct {
+	repo     *PostRepository
+	notifier notification.Notifier
+	config   *ScheduleConfig
+	logger   *zap.Logger
+	events   *PostBroker
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewScheduler(lc fx.Lifecycle, repo *PostRepository, notifier notification.Notifier, config *ScheduleConfig, logger *zap.Logger, events *PostBroker) *Scheduler {
+	scheduler := &Scheduler{repo: repo, notifier: notifier, config: config, logger: logger, events: events}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			scheduler.start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return scheduler.stop(ctx)
+		},
+	})
+	return scheduler
+}
+
+func (scheduler *Scheduler) start() {
+	runCtx, cancel := context.WithCancel(context.Background())
+	scheduler.cancel = cancel
+	scheduler.done = make(chan struct{})
+
+	go func() {
+		defer close(scheduler.done)
+		ticker := time.NewTicker(scheduler.config.Interval)
+		defer ticker.Stop()
+		for {
+			scheduler.tick(runCtx, time.Now())
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (scheduler *Scheduler) stop(ctx context.Context) error {
+	if scheduler.cancel == nil {
+		return nil
+	}
+	scheduler.cancel()
+	select {
+	case <-scheduler.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (scheduler *Scheduler) tick(ctx context.Context, now time.Time) {
+	ctx, span := tracer.Start(ctx, "Scheduler.tick")
+	defer span.End()
+
+	if published, err := scheduler.repo.PublishDuePosts(ctx, now); err != nil {
+		scheduler.logger.Error("publishing scheduled posts failed", zap.Error(err))
+	} else if len(published) > 0 {
+		scheduler.logger.Info("published scheduled posts", zap.Int("count", len(published)))
+		scheduler.announcePublished(ctx, published, now)
+	}
+
+	if expired, err := scheduler.repo.ExpireDuePosts(ctx, now); err != nil {
+		scheduler.logger.Error("expiring posts failed", zap.Error(err))
+	} else if len(expired) > 0 {
+		scheduler.logger.Info("expired posts", zap.Int("count", len(expired)))
+		for i := range expired {
+			post := &expired[i]
+			if post.IsActive {
+				previous := &postKey{id: post.ID, categoryId: post.CategoryID, title: post.Title}
+				scheduler.events.publishPost(PostEventDeactivated, previous, post, now)
+			}
+		}
+	}
+
+	scheduler.notifyExpiring(ctx, now)
+
+	if count, err := scheduler.repo.BackfillFingerprints(ctx, fingerprintBackfillBatch); err != nil {
+		scheduler.logger.Error("fingerprinting posts failed", zap.Error(err))
+	} else if count > 0 {
+		scheduler.logger.Info("fingerprinted posts", zap.Int("count", count))
+	}
+}
+
+// announcePublished reloads newly published posts with their categories and
+// pricing rules and announces the ones now listed.
+func (scheduler *Scheduler) announcePublished(ctx context.Context, published []Post, now time.Time) {
+	ids := make([]uint, 0, len(published))
+	for _, post := range published {
+		ids = append(ids, post.ID)
+	}
+	posts, err := scheduler.repo.GetPostsByIds(ctx, ids)
+	if err != nil {
+		scheduler.logger.Error("loading published posts failed", zap.Error(err))
+		return
+	}
+	for i := range posts {
+		scheduler.events.publishPost(PostEventUpdated, nil, &posts[i], now)
+	}
+}
+
+// notifyExpiring claims posts entering the notice window before notifying
+// their owners, so each post is announced at most once even with several
+// instances running.
+func (scheduler *Scheduler) notifyExpiring(ctx context.Context, now time.Time) {
+	for ctx.Err() == nil {
+		posts, err := scheduler.repo.ClaimExpiringPosts(ctx, now, now.Add(scheduler.config.ExpiryNotice), expiringBatchSize)
+		if err != nil {
+			scheduler.logger.Error("claiming expiring posts failed", zap.Error(err))
+			return
+		}
+		for _, post := range posts {
+			err := scheduler.notifier.Notify(ctx, notification.Notification{
+				UserId:  post.OwnerId,
+				Kind:    notification.KindPostExpiring,
+				Subject: "Your listing expires soon",
+				Body:    fmt.Sprintf("%q expires on %s. Renew it to keep it listed.", post.Title, post.ExpiresAt.UTC().Format(time.RFC1123)),
+				PostId:  post.ID,
+			})
+			if err != nil {
+				scheduler.logger.Error("expiry notification failed", zap.Uint("postId", post.ID), zap.Error(err))
+			}
+		}
+		if len(posts) < expiringBatchSize {
+			return
+		}
+	}
+}
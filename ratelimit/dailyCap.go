@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// DailyCap allows an action at most Max times per key each UTC day. A zero
+// Max means no cap.
+type DailyCap struct {
+	Max   int
+	name  string
+	store Store
+}
+
+func NewDailyCap(store Store, name string, max int) *DailyCap {
+	return &DailyCap{Max: max, name: name, store: store}
+}
+
+// Take counts one action for key. A refused action is counted too, so
+// retrying does not help until the day is over.
+func (dailyCap *DailyCap) Take(ctx context.Context, key string, now time.Time) (Result, error) {
+	if dailyCap.Max == 0 {
+		return Result{Allowed: true}, nil
+	}
+	reset := NextDay(now)
+
+	count, err := dailyCap.store.IncrBy(ctx, dailyCap.key(key, now), 1, reset)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{
+		Allowed:   count <= int64(dailyCap.Max),
+		Limit:     dailyCap.Max,
+		Remaining: int(math.Max(0, float64(int64(dailyCap.Max)-count))),
+		Reset:     reset.Sub(now),
+	}
+	if !result.Allowed {
+		result.RetryAfter = result.Reset
+	}
+	return result, nil
+}
+
+// Refund gives back an action taken at now that did not happen after all.
+func (dailyCap *DailyCap) Refund(ctx context.Context, key string, now time.Time) error {
+	if dailyCap.Max == 0 {
+		return nil
+	}
+	_, err := dailyCap.store.IncrBy(ctx, dailyCap.key(key, now), -1, NextDay(now))
+	return err
+}
+
+func (dailyCap *DailyCap) key(key string, now time.Time) string {
+	return "dailycap:" + dailyCap.name + ":" + key + ":" + now.UTC().Format("2006-01-02")
+}
+
+// NextDay returns the start of the UTC day after now.
+func NextDay(now time.Time) time.Time {
+	year, month, day := now.UTC().Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,206 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/fx"
+)
+
+// Limit is a token bucket: Requests tokens are added every Period, up to Burst
+// tokens. A zero Burst means Requests, and a zero Requests means no limit.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+func (limit Limit) capacity() float64 {
+	if limit.Burst > 0 {
+		return float64(limit.Burst)
+	}
+	return float64(limit.Requests)
+}
+
+// rate is the number of tokens added per second.
+func (limit Limit) rate() float64 {
+	return float64(limit.Requests) / limit.Period.Seconds()
+}
+
+// Result describes a bucket after one request was counted against it.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request is allowed, or zero if
+	// this one was.
+	RetryAfter time.Duration
+}
+
+// bucketResult builds the Result of a take that left tokens in the bucket.
+func bucketResult(limit Limit, tokens float64, allowed bool) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     int(limit.capacity()),
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((limit.capacity() - tokens) / limit.rate()),
+	}
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / limit.rate())
+	}
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Store keeps rate limit state. MemoryStore suits a single instance; with
+// several, use a shared RedisStore so that limits hold across them.
+type Store interface {
+	// Take counts one request against the token bucket at key.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+	// IncrBy adds delta to the counter at key, which is dropped at
+	// expiresAt, and returns the new count.
+	IncrBy(ctx context.Context, key string, delta int64, expiresAt time.Time) (int64, error)
+}
+
+// redisKeyPrefix keeps the service's keys apart on a shared server.
+const redisKeyPrefix = "post-service:"
+
+// NewStore returns a RedisStore on the server at REDIS_URL, such as
+// redis://:password@redis:6379/0, or a MemoryStore when it is not set.
+func NewStore(lc fx.Lifecycle) (Store, error) {
+	rawURL := os.Getenv("REDIS_URL")
+	if rawURL == "" {
+		return NewMemoryStore(), nil
+	}
+	options, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	client := redis.NewClient(options)
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return client.Close()
+		},
+	})
+	return NewRedisStore(goRedisClient{client: client}, redisKeyPrefix), nil
+}
+
+// Config holds the limit for each route, keyed by method and route pattern,
+// e.g. "POST /posts" or "GET /posts/:postId". Routes without their own limit
+// share Default.
+type Config struct {
+	Default Limit
+	Routes  map[string]Limit
+}
+
+var defaultRoutes = map[string]Limit{
+	"GET /posts":                    {Requests: 60, Period: time.Minute},
+	"GET /posts/category/:category": {Requests: 60, Period: time.Minute},
+	"GET /posts/events":             {Requests: 20, Period: time.Minute},
+	"GET /posts/:postId/events":     {Requests: 20, Period: time.Minute},
+	"POST /posts":                   {Requests: 10, Period: time.Minute, Burst: 3},
+	"POST /posts/imports":           {Requests: 10, Period: time.Hour},
+	"GET /my-posts/export":          {Requests: 10, Period: time.Hour},
+	"GET /admin/posts/export":       {Requests: 10, Period: time.Hour},
+
+	"POST /posts/:postId/conversations":            {Requests: 20, Period: time.Hour},
+	"POST /conversations/:conversationId/messages": {Requests: 60, Period: time.Minute},
+}
+
+type limitFile struct {
+	Requests int    `json:"requests"`
+	Period   string `json:"period"`
+	Burst    int    `json:"burst"`
+}
+
+type configFile struct {
+	Default *limitFile           `json:"default"`
+	Routes  map[string]limitFile `json:"routes"`
+}
+
+// NewConfig starts from the built-in limits and applies RATE_LIMITS_FILE, a
+// JSON file such as
+//
+//	{"default": {"requests": 120, "period": "1m"},
+//	 "routes": {"POST /posts": {"requests": 5, "period": "1m", "burst": 2}}}
+//
+// where a route with zero requests is not limited.
+func NewConfig() (*Config, error) {
+	config := &Config{
+		Default: Limit{Requests: 120, Period: time.Minute},
+		Routes:  map[string]Limit{},
+	}
+	for route, limit := range defaultRoutes {
+		config.Routes[route] = limit
+	}
+
+	path := os.Getenv("RATE_LIMITS_FILE")
+	if path == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading RATE_LIMITS_FILE: %w", err)
+	}
+	if err := config.load(data); err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMITS_FILE %s: %w", path, err)
+	}
+	return config, nil
+}
+
+func (config *Config) load(data []byte) error {
+	var file configFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	if file.Default != nil {
+		limit, err := file.Default.limit()
+		if err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+		config.Default = limit
+	}
+	for route, entry := range file.Routes {
+		limit, err := entry.limit()
+		if err != nil {
+			return fmt.Errorf("route %q: %w", route, err)
+		}
+		config.Routes[route] = limit
+	}
+	return nil
+}
+
+func (entry limitFile) limit() (Limit, error) {
+	if entry.Requests < 0 || entry.Burst < 0 {
+		return Limit{}, fmt.Errorf("requests and burst must not be negative")
+	}
+	if entry.Requests == 0 {
+		return Limit{}, nil
+	}
+	period, err := time.ParseDuration(entry.Period)
+	if err != nil {
+		return Limit{}, fmt.Errorf("period: %w", err)
+	}
+	if period <= 0 {
+		return Limit{}, fmt.Errorf("period must be positive")
+	}
+	return Limit{Requests: entry.Requests, Period: period, Burst: entry.Burst}, nil
+}
+
+// limitFor returns the limit for route and the name of the bucket it uses.
+func (config *Config) limitFor(route string) (Limit, string) {
+	if limit, ok := config.Routes[route]; ok {
+		return limit, route
+	}
+	return config.Default, "default"
+}
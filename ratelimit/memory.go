@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore drops buckets that refilled and
+// counters that expired.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+	// full is when the bucket refills if nothing else is taken.
+	full time.Time
+}
+
+type counter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// MemoryStore keeps rate limit state in this process.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	counters  map[string]*counter
+	lastSweep time.Time
+}
+
+func NewMemoryStore() Store {
+	return &MemoryStore{buckets: map[string]*bucket{}, counters: map[string]*counter{}}
+}
+
+func (store *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.sweep(now)
+
+	capacity := limit.capacity()
+	b, ok := store.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, at: now}
+		store.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.at).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*limit.rate())
+		b.at = now
+	}
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	result := bucketResult(limit, b.tokens, allowed)
+	b.full = now.Add(result.Reset)
+	return result, nil
+}
+
+func (store *MemoryStore) IncrBy(ctx context.Context, key string, delta int64, expiresAt time.Time) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now()
+	store.sweep(now)
+
+	c, ok := store.counters[key]
+	if !ok || !now.Before(c.expiresAt) {
+		c = &counter{expiresAt: expiresAt}
+		store.counters[key] = c
+	}
+	c.count += delta
+	return c.count, nil
+}
+
+func (store *MemoryStore) sweep(now time.Time) {
+	if now.Sub(store.lastSweep) < sweepInterval {
+		return
+	}
+	store.lastSweep = now
+	for key, b := range store.buckets {
+		if !now.Before(b.full) {
+			delete(store.buckets, key)
+		}
+	}
+	for key, c := range store.counters {
+		if !now.Before(c.expiresAt) {
+			delete(store.counters, key)
+		}
+	}
+}
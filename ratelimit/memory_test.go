@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	// One token a second, up to three.
+	limit := Limit{Requests: 60, Period: time.Minute, Burst: 3}
+
+	steps := []struct {
+		name       string
+		key        string
+		at         time.Duration
+		allowed    bool
+		remaining  int
+		reset      time.Duration
+		retryAfter time.Duration
+	}{
+		{"full bucket", "a", 0, true, 2, time.Second, 0},
+		{"burst", "a", 0, true, 1, 2 * time.Second, 0},
+		{"last token", "a", 0, true, 0, 3 * time.Second, 0},
+		{"empty", "a", 0, false, 0, 3 * time.Second, time.Second},
+		{"other key", "b", 0, true, 2, time.Second, 0},
+		{"half refilled", "a", 500 * time.Millisecond, false, 0, 2500 * time.Millisecond, 500 * time.Millisecond},
+		{"refilled", "a", time.Second, true, 0, 3 * time.Second, 0},
+		{"capped at burst", "a", time.Hour, true, 2, time.Second, 0},
+	}
+	store := NewMemoryStore()
+	for _, step := range steps {
+		result, err := store.Take(ctx, step.key, limit, start.Add(step.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Result{Allowed: step.allowed, Limit: 3, Remaining: step.remaining, Reset: step.reset, RetryAfter: step.retryAfter}
+		if result != want {
+			t.Errorf("%s: got %+v, want %+v", step.name, result, want)
+		}
+	}
+}
+
+func TestDailyCap(t *testing.T) {
+	ctx := context.Background()
+	// MemoryStore expires counters by the clock, so the day must be today.
+	now := time.Now()
+
+	steps := []struct {
+		name      string
+		key       string
+		at        time.Time
+		allowed   bool
+		remaining int
+	}{
+		{"first", "user:1", now, true, 1},
+		{"second", "user:1", now, true, 0},
+		{"over the cap", "user:1", now, false, 0},
+		{"refused still counts", "user:1", now, false, 0},
+		{"other user", "user:2", now, true, 1},
+		{"next day", "user:1", now.Add(24 * time.Hour), true, 1},
+	}
+	dailyCap := NewDailyCap(NewMemoryStore(), "posts", 2)
+	for _, step := range steps {
+		result, err := dailyCap.Take(ctx, step.key, step.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reset := NextDay(step.at).Sub(step.at)
+		want := Result{Allowed: step.allowed, Limit: 2, Remaining: step.remaining, Reset: reset}
+		if !step.allowed {
+			want.RetryAfter = reset
+		}
+		if result != want {
+			t.Errorf("%s: got %+v, want %+v", step.name, result, want)
+		}
+	}
+
+	// A refunded action no longer counts.
+	refunded := NewDailyCap(NewMemoryStore(), "posts", 1)
+	for i := 0; i < 3; i++ {
+		if result, err := refunded.Take(ctx, "user:1", now); err != nil || !result.Allowed {
+			t.Fatalf("take %d: got %+v, %v", i, result, err)
+		}
+		if err := refunded.Refund(ctx, "user:1", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unlimited := NewDailyCap(NewMemoryStore(), "posts", 0)
+	for i := 0; i < 5; i++ {
+		if result, err := unlimited.Take(ctx, "user:1", now); err != nil || !result.Allowed {
+			t.Fatalf("uncapped take %d: got %+v, %v", i, result, err)
+		}
+	}
+}
+
+func TestNextDay(t *testing.T) {
+	at := time.Date(2024, 12, 31, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*60*60))
+	if got, want := NextDay(at), time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
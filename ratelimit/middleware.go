@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"post-service/apperr"
+	"post-service/logging"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+var ErrRateLimited = apperr.TooManyRequests("rate_limited", "too many requests, try again later")
+
+// Limiter applies the configured limit of each route.
+type Limiter struct {
+	config *Config
+	store  Store
+}
+
+func NewLimiter(config *Config, store Store) *Limiter {
+	return &Limiter{config: config, store: store}
+}
+
+// Middleware limits requests per user on routes behind auth.AuthMiddleware,
+// which must run first, and per client IP elsewhere. Every limited response
+// carries RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers, and a rejected one also carries Retry-After.
+// Requests are let through if the store fails.
+func (limiter *Limiter) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit, bucket := limiter.config.limitFor(c.Request().Method + " " + c.Path())
+		if limit.Requests == 0 {
+			return next(c)
+		}
+
+		identity := "ip:" + c.RealIP()
+		if userId, ok := c.Get("userId").(uint); ok {
+			identity = "user:" + strconv.FormatUint(uint64(userId), 10)
+		}
+
+		result, err := limiter.store.Take(c.Request().Context(), "ratelimit:"+bucket+":"+identity, limit, time.Now())
+		if err != nil {
+			logging.FromContext(c.Request().Context()).Error("rate limit store failed", zap.String("bucket", bucket), zap.Error(err))
+			return next(c)
+		}
+
+		header := c.Response().Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", limit.Requests, ceilSeconds(limit.Period), result.Limit))
+		if !result.Allowed {
+			SetRetryAfter(header, result.RetryAfter)
+			return ErrRateLimited
+		}
+		return next(c)
+	}
+}
+
+// SetRetryAfter tells the client to wait d before retrying.
+func SetRetryAfter(header http.Header, d time.Duration) {
+	header.Set("Retry-After", strconv.Itoa(ceilSeconds(d)))
+}
+
+// ceilSeconds rounds d up to whole seconds, as the headers require.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"post-service/apperr"
+	"post-service/validation"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestLimiterHeaders(t *testing.T) {
+	_, uni, err := validation.NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		Default: Limit{},
+		Routes:  map[string]Limit{"GET /things": {Requests: 2, Period: 2 * time.Second}},
+	}
+	limiter := NewLimiter(config, NewMemoryStore())
+
+	e := echo.New()
+	e.HTTPErrorHandler = apperr.NewHTTPErrorHandler(uni)
+	login := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if id := c.Request().Header.Get("X-User"); id != "" {
+				userId, _ := strconv.Atoi(id)
+				c.Set("userId", uint(userId))
+			}
+			return next(c)
+		}
+	}
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/things", ok, login, limiter.Middleware)
+	e.GET("/other", ok, login, limiter.Middleware)
+
+	steps := []struct {
+		name       string
+		path       string
+		user       string
+		status     int
+		remaining  string
+		retryAfter string
+	}{
+		{"first", "/things", "", http.StatusOK, "1", ""},
+		{"second", "/things", "", http.StatusOK, "0", ""},
+		{"limited", "/things", "", http.StatusTooManyRequests, "0", "1"},
+		{"by user, not IP", "/things", "7", http.StatusOK, "1", ""},
+		{"unlimited route", "/other", "", http.StatusOK, "", ""},
+	}
+	for _, step := range steps {
+		req := httptest.NewRequest(http.MethodGet, step.path, nil)
+		req.Header.Set("X-User", step.user)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		header := rec.Header()
+		if rec.Code != step.status {
+			t.Errorf("%s: got status %d, want %d", step.name, rec.Code, step.status)
+		}
+		if got := header.Get("RateLimit-Remaining"); got != step.remaining {
+			t.Errorf("%s: got RateLimit-Remaining %q, want %q", step.name, got, step.remaining)
+		}
+		if got := header.Get("Retry-After"); got != step.retryAfter {
+			t.Errorf("%s: got Retry-After %q, want %q", step.name, got, step.retryAfter)
+		}
+		if step.remaining == "" {
+			continue
+		}
+		if got := header.Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("%s: got RateLimit-Limit %q", step.name, got)
+		}
+		if got := header.Get("RateLimit-Reset"); got != "1" && got != "2" {
+			t.Errorf("%s: got RateLimit-Reset %q", step.name, got)
+		}
+		if got := header.Get("RateLimit-Policy"); got != "2;w=2;burst=2" {
+			t.Errorf("%s: got RateLimit-Policy %q", step.name, got)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisClient runs a Lua script on a Redis-compatible server. It is the only
+// call RedisStore makes, so any client fits behind a small adapter.
+type RedisClient interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// goRedisClient adapts a go-redis client to RedisClient.
+type goRedisClient struct {
+	client *redis.Client
+}
+
+func (adapter goRedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return adapter.client.Eval(ctx, script, keys, args...).Result()
+}
+
+// takeScript refills and takes from the bucket atomically, using the
+// server's clock so that instances with skewed clocks agree. It returns
+// whether the request is allowed and the tokens left, as a string because
+// Redis truncates Lua numbers to integers.
+const takeScript = `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(state[1]) or capacity
+local at = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - at) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate) + 1000)
+return {allowed, tostring(tokens)}
+`
+
+const incrScript = `
+local count = redis.call('INCRBY', KEYS[1], ARGV[2])
+if redis.call('PTTL', KEYS[1]) == -1 then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[1])
+end
+return count
+`
+
+// RedisStore keeps rate limit state in Redis, shared by every instance.
+type RedisStore struct {
+	client RedisClient
+	prefix string
+}
+
+// NewRedisStore stores its keys under prefix, e.g. "post-service:".
+func NewRedisStore(client RedisClient, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (store *RedisStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	perMillisecond := limit.rate() / 1000
+	reply, err := store.client.Eval(ctx, takeScript, []string{store.prefix + key},
+		limit.capacity(), strconv.FormatFloat(perMillisecond, 'g', -1, 64))
+	if err != nil {
+		return Result{}, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return Result{}, fmt.Errorf("unexpected reply %v from rate limit script", reply)
+	}
+	allowed, ok := values[0].(int64)
+	if !ok {
+		return Result{}, fmt.Errorf("unexpected reply %v from rate limit script", reply)
+	}
+	tokensStr, ok := values[1].(string)
+	if !ok {
+		return Result{}, fmt.Errorf("unexpected reply %v from rate limit script", reply)
+	}
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("unexpected reply %v from rate limit script", reply)
+	}
+	return bucketResult(limit, tokens, allowed == 1), nil
+}
+
+func (store *RedisStore) IncrBy(ctx context.Context, key string, delta int64, expiresAt time.Time) (int64, error) {
+	reply, err := store.client.Eval(ctx, incrScript, []string{store.prefix + key}, expiresAt.UnixMilli(), delta)
+	if err != nil {
+		return 0, err
+	}
+	count, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected reply %v from counter script", reply)
+	}
+	return count, nil
+}
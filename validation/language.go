@@ -0,0 +1,57 @@
+package validation
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	ut "github.com/go-playground/universal-translator"
+)
+
+// Translator picks the best supported translator for an Accept-Language
+// header, falling back to English.
+func Translator(uni *ut.UniversalTranslator, acceptLanguage string) ut.Translator {
+	trans, _ := uni.FindTranslator(PreferredLanguages(acceptLanguage)...)
+	return trans
+}
+
+// PreferredLanguages parses an Accept-Language header into locale names
+// ordered by preference. A regional tag such as "fr-CA" is followed by its
+// base language so that "fr" still matches.
+func PreferredLanguages(acceptLanguage string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag: strings.ToLower(tag), q: q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	languages := make([]string, 0, len(tags)*2)
+	for _, t := range tags {
+		base, region, found := strings.Cut(t.tag, "-")
+		if found {
+			languages = append(languages, base+"_"+strings.ToUpper(region))
+		}
+		languages = append(languages, base)
+	}
+	return languages
+}
@@ -0,0 +1,18 @@
+package validation
+
+import (
+	"regexp"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var htmlTagPattern = regexp.MustCompile(`(?i)<\s*/?\s*[a-z!][^>]*>`)
+
+var rules = map[string]validator.Func{
+	"nohtml": noHTML,
+}
+
+// noHTML rejects strings containing markup such as tags or comments.
+func noHTML(fl validator.FieldLevel) bool {
+	return !htmlTagPattern.MatchString(fl.Field().String())
+}
@@ -0,0 +1,93 @@
+package validation
+
+import (
+	"post-service/money"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/fa"
+	"github.com/go-playground/locales/fr"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	fa_translations "github.com/go-playground/validator/v10/translations/fa"
+	fr_translations "github.com/go-playground/validator/v10/translations/fr"
+)
+
+type registerFunc func(v *validator.Validate, trans ut.Translator) error
+
+// locale describes a supported language: the built-in validator messages and
+// the messages for the rules defined in this package.
+type locale struct {
+	name     string
+	register registerFunc
+	messages map[string]string
+}
+
+var locales = []locale{
+	{name: "en", register: en_translations.RegisterDefaultTranslations, messages: map[string]string{
+		"nohtml": "{0} must not contain HTML",
+	}},
+	{name: "fa", register: fa_translations.RegisterDefaultTranslations, messages: map[string]string{
+		"nohtml": "{0} نباید شامل HTML باشد",
+	}},
+	{name: "fr", register: fr_translations.RegisterDefaultTranslations, messages: map[string]string{
+		"nohtml": "{0} ne doit pas contenir de HTML",
+	}},
+}
+
+// NewValidator returns a validator reporting fields by their JSON names, with
+// the domain rules registered, and a translator holding messages for every
+// supported locale. English is the fallback.
+func NewValidator() (*validator.Validate, *ut.UniversalTranslator, error) {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return field.Name
+		}
+		return name
+	})
+
+	// Exact decimals are checked by their value, so gt=0 and friends apply.
+	validate.RegisterCustomTypeFunc(func(field reflect.Value) interface{} {
+		return field.Interface().(money.Decimal).Float64()
+	}, money.Decimal{})
+
+	for tag, rule := range rules {
+		if err := validate.RegisterValidation(tag, rule); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	uni := ut.New(en.New(), en.New(), fa.New(), fr.New())
+	for _, l := range locales {
+		trans, _ := uni.GetTranslator(l.name)
+		if err := l.register(validate, trans); err != nil {
+			return nil, nil, err
+		}
+		for tag, message := range l.messages {
+			if err := registerMessage(validate, trans, tag, message); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return validate, uni, nil
+}
+
+func registerMessage(validate *validator.Validate, trans ut.Translator, tag, message string) error {
+	return validate.RegisterTranslation(tag, trans,
+		func(trans ut.Translator) error {
+			return trans.Add(tag, message, true)
+		},
+		func(trans ut.Translator, fe validator.FieldError) string {
+			text, err := trans.T(tag, fe.Field())
+			if err != nil {
+				return fe.Error()
+			}
+			return text
+		},
+	)
+}
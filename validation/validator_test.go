@@ -0,0 +1,164 @@
+package validation
+
+import (
+	"errors"
+	"post-service/money"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+)
+
+type testDto struct {
+	Price       money.Decimal  `json:"price" validate:"required,gt=0"`
+	Percent     *money.Decimal `json:"percent" validate:"omitempty,gt=-100,lte=1000"`
+	Description string         `json:"description" validate:"nohtml"`
+}
+
+func decimal(t *testing.T, value string) money.Decimal {
+	t.Helper()
+	d, err := money.ParseDecimal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// failedRules returns the field and rule of each validation error of dto.
+func failedRules(t *testing.T, validate *validator.Validate, dto testDto) []string {
+	t.Helper()
+	err := validate.Struct(dto)
+	if err == nil {
+		return nil
+	}
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		t.Fatal(err)
+	}
+	var failed []string
+	for _, fe := range validationErrors {
+		failed = append(failed, fe.Field()+":"+fe.Tag())
+	}
+	return failed
+}
+
+func TestValidatorDecimals(t *testing.T) {
+	validate, _, err := NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	percent := func(value string) *money.Decimal {
+		d := decimal(t, value)
+		return &d
+	}
+
+	tests := []struct {
+		name string
+		dto  testDto
+		want string
+	}{
+		{"positive price", testDto{Price: decimal(t, "0.01")}, ""},
+		{"zero price", testDto{Price: decimal(t, "0.00")}, "price:required"},
+		{"negative price", testDto{Price: decimal(t, "-5")}, "price:gt"},
+		{"percent in range", testDto{Price: decimal(t, "1"), Percent: percent("1000")}, ""},
+		{"percent too large", testDto{Price: decimal(t, "1"), Percent: percent("1000.01")}, "percent:lte"},
+		{"percent too small", testDto{Price: decimal(t, "1"), Percent: percent("-100")}, "percent:gt"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(failedRules(t, validate, test.dto), ","); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// gt=0 alone also rejects zero written with decimals.
+	type priceOnly struct {
+		Price money.Decimal `validate:"gt=0"`
+	}
+	if err := validate.Struct(priceOnly{Price: decimal(t, "0.00")}); err == nil {
+		t.Error("gt=0 accepted 0.00")
+	}
+}
+
+func TestNoHTML(t *testing.T) {
+	validate, _, err := NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		description string
+		valid       bool
+	}{
+		{"Cordless drill, two batteries", true},
+		{"Fits pipes 2 < 3 inches", true},
+		{"Price <= 10 per day", true},
+		{"<b>Cordless</b> drill", false},
+		{"<script>alert(1)</script>", false},
+		{"Drill </p>", false},
+		{"< img src=x onerror=alert(1)>", false},
+		{"<!-- hidden -->", false},
+		{"<A HREF='x'>link</A>", false},
+	}
+	for _, test := range tests {
+		failed := failedRules(t, validate, testDto{Price: decimal(t, "1"), Description: test.description})
+		if valid := len(failed) == 0; valid != test.valid {
+			t.Errorf("%q: got %v, want valid %t", test.description, failed, test.valid)
+		}
+	}
+}
+
+func TestTranslations(t *testing.T) {
+	validate, uni, err := NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = validate.Struct(testDto{Price: decimal(t, "-1"), Description: "<b>drill</b>"})
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) || len(validationErrors) != 2 {
+		t.Fatalf("got %v", err)
+	}
+
+	tests := []struct {
+		acceptLanguage string
+		locale         string
+		nohtml         string
+	}{
+		{"", "en", "description must not contain HTML"},
+		{"en-US", "en", "description must not contain HTML"},
+		{"fa", "fa", "description نباید شامل HTML باشد"},
+		{"fr-CA, en;q=0.5", "fr", "description ne doit pas contenir de HTML"},
+		{"de, fr;q=0.8", "fr", "description ne doit pas contenir de HTML"},
+		{"de", "en", "description must not contain HTML"},
+	}
+	for _, test := range tests {
+		trans := Translator(uni, test.acceptLanguage)
+		if trans.Locale() != test.locale {
+			t.Errorf("%q: got locale %s, want %s", test.acceptLanguage, trans.Locale(), test.locale)
+			continue
+		}
+		messages := validationErrors.Translate(trans)
+		if got := messages["testDto.description"]; got != test.nohtml {
+			t.Errorf("%s: got nohtml message %q, want %q", test.locale, got, test.nohtml)
+		}
+		price := messages["testDto.price"]
+		if price == "" || price == validationErrors[0].Error() || !strings.Contains(price, "price") {
+			t.Errorf("%s: got gt message %q", test.locale, price)
+		}
+	}
+}
+
+func TestPreferredLanguages(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"fr-CA", "fr_CA,fr"},
+		{"en;q=0.5, fa", "fa,en"},
+		{"de;q=0, *, fr;q=bad, en", "en"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(PreferredLanguages(test.header), ","); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.header, got, test.want)
+		}
+	}
+}